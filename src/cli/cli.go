@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"blockchain/core/wallet"
 	"errors"
 	"flag"
 	"os"
@@ -14,23 +15,127 @@ func Run() {
 		panic("use command `help` to check out usage")
 	}
 
-	// 钱包创建。
+	// 钱包被锁定时从终端读取口令。
+	wallet.Prompt = readPassphrase
+
+	// 钱包创建与加密。
 	walletCmd := flag.NewFlagSet("wallet", flag.ExitOnError)
+	walletUnlockCmd := flag.NewFlagSet("wallet unlock", flag.ExitOnError)
+	walletUnlockTimeout := walletUnlockCmd.Int("timeout", 300, "Seconds to keep the wallet unlocked. The key file is removed by `wallet lock` or the first wallet command after that.")
+	walletCreateCmd := flag.NewFlagSet("wallet create", flag.ExitOnError)
+	walletCreateMnemonic := walletCreateCmd.Bool("mnemonic", false, "Derive wallets from a new seed backed up by a mnemonic.")
+	walletRestoreCmd := flag.NewFlagSet("wallet restore", flag.ExitOnError)
+	walletRestoreGap := walletRestoreCmd.Int("gap", wallet.DefaultGapLimit, "Stop rescanning after this many consecutive unused addresses.")
+	walletExportCmd := flag.NewFlagSet("wallet export", flag.ExitOnError)
+	walletExportAddr := walletExportCmd.String("address", "", "The address whose private key is being exported.")
+	walletImportCmd := flag.NewFlagSet("wallet import", flag.ExitOnError)
+	walletImportKey := walletImportCmd.String("key", "", "The private key exported by `wallet export`.")
+	walletPubkeyCmd := flag.NewFlagSet("wallet pubkey", flag.ExitOnError)
+	walletPubkeyAddr := walletPubkeyCmd.String("address", "", "The address whose public key is being printed.")
+	walletWatchCmd := flag.NewFlagSet("wallet watch", flag.ExitOnError)
+	walletWatchAddr := walletWatchCmd.String("address", "", "The address to watch.")
+	walletWatchPubkey := walletWatchCmd.String("pubkey", "", "The public key in hex to watch, instead of -address.")
+	walletImportRescan := walletImportCmd.Bool("rescan", false, "Show the balance and latest transactions of the imported address.")
+	// 创建多重签名地址。
+	multisigCmd := flag.NewFlagSet("multisig", flag.ExitOnError)
+	multisigCreateCmd := flag.NewFlagSet("multisig create", flag.ExitOnError)
+	multisigCreateM := multisigCreateCmd.Int("m", 0, "Number of signatures required to spend.")
+	multisigCreateKeys := multisigCreateCmd.String("keys", "", "Comma separated public keys in hex, or wallet addresses whose public keys are known.")
+	// 哈希时间锁合约。
+	htlcCmd := flag.NewFlagSet("htlc", flag.ExitOnError)
+	htlcLockCmd := flag.NewFlagSet("htlc lock", flag.ExitOnError)
+	htlcLockFrom := htlcLockCmd.String("from", "", "Sender wallet address, which can refund after the lock time.")
+	htlcLockTo := htlcLockCmd.String("to", "", "Recipient address, which can claim with the preimage.")
+	htlcLockAmount := htlcLockCmd.Int("amount", 0, "Amount of coins to lock.")
+	htlcLockFee := htlcLockCmd.Int("fee", 0, "Absolute fee paid to the miner.")
+	htlcLockFeeRate := htlcLockCmd.Int("feerate", 0, "Fee per byte of the transaction, overrides -fee.")
+	htlcLockLockTime := htlcLockCmd.Int64("locktime", 0, "Block height, or Unix time if at least 500000000, after which the sender can refund.")
+	htlcLockHash := htlcLockCmd.String("hash", "", "SHA-256 hash of the secret in hex, from the counterparty's contract; a new secret is generated if empty.")
+	htlcLockNode := htlcLockCmd.String("node", "", "Also relay the transaction to the node listening on this address.")
+	htlcShowCmd := flag.NewFlagSet("htlc show", flag.ExitOnError)
+	htlcShowContract := htlcShowCmd.String("contract", "", "The contract in hex.")
+	htlcClaimCmd := flag.NewFlagSet("htlc claim", flag.ExitOnError)
+	htlcClaimContract := htlcClaimCmd.String("contract", "", "The contract in hex.")
+	htlcClaimPreimage := htlcClaimCmd.String("preimage", "", "The secret in hex.")
+	htlcClaimFee := htlcClaimCmd.Int("fee", 0, "Absolute fee paid to the miner.")
+	htlcClaimNode := htlcClaimCmd.String("node", "", "Also relay the transaction to the node listening on this address.")
+	htlcRefundCmd := flag.NewFlagSet("htlc refund", flag.ExitOnError)
+	htlcRefundContract := htlcRefundCmd.String("contract", "", "The contract in hex.")
+	htlcRefundFee := htlcRefundCmd.Int("fee", 0, "Absolute fee paid to the miner.")
+	htlcRefundNode := htlcRefundCmd.String("node", "", "Also relay the transaction to the node listening on this address.")
 	// 列出地址。
 	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
 	// 创建区块链。
 	chainCmd := flag.NewFlagSet("chain", flag.ExitOnError)
 	chainAddr := chainCmd.String("address", "", "The address who mined out genesis block.")
+	chainTxIndex := chainCmd.Bool("txindex", false, "Maintain an index of transactions by ID.")
 	// 查询余额。
 	balanceCmd := flag.NewFlagSet("balance", flag.ExitOnError)
 	balanceAddr := balanceCmd.String("address", "", "The address whose balance is being queried.")
+	// 查询交易记录。
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	historyAddr := historyCmd.String("address", "", "The address whose transaction history is being queried.")
+	historyPage := historyCmd.Int("page", 1, "Page number, starting from 1 with the newest transactions.")
+	historySize := historyCmd.Int("size", 10, "Number of transactions per page.")
 	// 发起交易。
 	tradeCmd := flag.NewFlagSet("trade", flag.ExitOnError)
 	tradeFrom := tradeCmd.String("from", "", "Source wallet address.")
 	tradeTo := tradeCmd.String("to", "", "Destination wallet address.")
 	tradeAmount := tradeCmd.String("amount", "0", "Amount of coins to trade.")
+	tradeFee := tradeCmd.Int("fee", 0, "Absolute fee paid to the miner.")
+	tradeFeeRate := tradeCmd.Int("feerate", 0, "Fee per byte of the transaction, overrides -fee.")
+	tradeNode := tradeCmd.String("node", "", "Also relay the transaction to the node listening on this address.")
+	tradeLockTime := tradeCmd.Int64("locktime", 0, "Block height, or Unix time if at least 500000000, before which the transaction cannot be mined.")
+	// 将文件的哈希值写入区块链，或查找写入它的区块。
+	notarizeCmd := flag.NewFlagSet("notarize", flag.ExitOnError)
+	notarizeFile := notarizeCmd.String("file", "", "The document to notarize.")
+	notarizeFrom := notarizeCmd.String("from", "", "Wallet address paying the fee.")
+	notarizeFee := notarizeCmd.Int("fee", 0, "Absolute fee paid to the miner.")
+	notarizeFeeRate := notarizeCmd.Int("feerate", 0, "Fee per byte of the transaction, overrides -fee.")
+	notarizeNode := notarizeCmd.String("node", "", "Also relay the transaction to the node listening on this address.")
+	notarizeVerifyCmd := flag.NewFlagSet("notarize verify", flag.ExitOnError)
+	notarizeVerifyFile := notarizeVerifyCmd.String("file", "", "The document to look up.")
+	// 挖出新区块。
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	mineAddr := mineCmd.String("address", "", "The address who receives the block reward.")
+	// 启动节点。
+	nodeCmd := flag.NewFlagSet("node", flag.ExitOnError)
+	nodePort := nodeCmd.Int("port", 3000, "The TCP port to listen on.")
+	nodePeers := nodeCmd.String("peers", "", "Comma separated addresses of peers to connect to.")
+	nodeMiner := nodeCmd.String("miner", "", "Mine pending transactions, rewarding this address.")
+	// 查询区块。
+	blockCmd := flag.NewFlagSet("block", flag.ExitOnError)
+	blockHeight := blockCmd.Int("height", -1, "Height of the block on the chain.")
+	blockHash := blockCmd.String("hash", "", "Hash of the block in hex.")
 	// 重新索引区块链。
 	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
+	reindexTxIndex := reindexCmd.Bool("txindex", false, "Also build the index of transactions by ID, enabling it from now on.")
+	// 查询交易。
+	txCmd := flag.NewFlagSet("tx", flag.ExitOnError)
+	txID := txCmd.String("id", "", "ID of the transaction in hex.")
+	txCreateCmd := flag.NewFlagSet("tx create", flag.ExitOnError)
+	txCreateFrom := txCreateCmd.String("from", "", "Source address, which need not be in the wallet.")
+	txCreateTo := txCreateCmd.String("to", "", "Destination wallet address.")
+	txCreateAmount := txCreateCmd.Int("amount", 0, "Amount of coins to trade.")
+	txCreateFee := txCreateCmd.Int("fee", 0, "Absolute fee paid to the miner.")
+	txCreateFeeRate := txCreateCmd.Int("feerate", 0, "Fee per byte of the signed transaction, overrides -fee.")
+	txCreateLockTime := txCreateCmd.Int64("locktime", 0, "Block height, or Unix time if at least 500000000, before which the transaction cannot be mined.")
+	txCreateOut := txCreateCmd.String("out", "", "File to write the unsigned transaction to.")
+	txSignCmd := flag.NewFlagSet("tx sign", flag.ExitOnError)
+	txSignIn := txSignCmd.String("in", "", "File of the transaction to sign.")
+	txSignOut := txSignCmd.String("out", "", "File to write the signed transaction to, defaults to -in.")
+	txSubmitCmd := flag.NewFlagSet("tx submit", flag.ExitOnError)
+	txSubmitIn := txSubmitCmd.String("in", "", "File of the signed transaction.")
+	txSubmitNode := txSubmitCmd.String("node", "", "Also relay the transaction to the node listening on this address.")
+	// 生成或验证交易的包含证明。
+	proofCmd := flag.NewFlagSet("proof", flag.ExitOnError)
+	proofTx := proofCmd.String("tx", "", "ID of the transaction in hex.")
+	proofOut := proofCmd.String("out", "", "File to write the proof to.")
+	proofVerifyCmd := flag.NewFlagSet("proof verify", flag.ExitOnError)
+	proofVerifyIn := proofVerifyCmd.String("in", "", "File of the proof.")
+	proofVerifyHeader := proofVerifyCmd.String("header", "", "Hash in hex of a trusted block header, instead of the local chain.")
+	// 校验区块链。
+	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
 	// 打印区块链。
 	printCmd := flag.NewFlagSet("print", flag.ExitOnError)
 	// 显示帮助。
@@ -43,14 +148,34 @@ func Run() {
 		err = chainCmd.Parse(os.Args[2:])
 	case "wallet":
 		err = walletCmd.Parse(os.Args[2:])
+	case "multisig":
+		err = multisigCmd.Parse(os.Args[2:])
+	case "htlc":
+		err = htlcCmd.Parse(os.Args[2:])
 	case "list":
 		err = listCmd.Parse(os.Args[2:])
 	case "balance":
 		err = balanceCmd.Parse(os.Args[2:])
+	case "history":
+		err = historyCmd.Parse(os.Args[2:])
 	case "trade":
 		err = tradeCmd.Parse(os.Args[2:])
+	case "notarize":
+		err = notarizeCmd.Parse(os.Args[2:])
+	case "mine":
+		err = mineCmd.Parse(os.Args[2:])
+	case "node":
+		err = nodeCmd.Parse(os.Args[2:])
+	case "block":
+		err = blockCmd.Parse(os.Args[2:])
+	case "tx":
+		err = txCmd.Parse(os.Args[2:])
+	case "proof":
+		err = proofCmd.Parse(os.Args[2:])
 	case "reindex":
 		err = reindexCmd.Parse(os.Args[2:])
+	case "verify":
+		err = verifyCmd.Parse(os.Args[2:])
 	case "print":
 		err = printCmd.Parse(os.Args[2:])
 	case "help":
@@ -67,11 +192,154 @@ func Run() {
 		if *chainAddr == "" {
 			chainCmd.Usage()
 		} else {
-			newChain(*chainAddr)
+			newChain(*chainAddr, *chainTxIndex)
 		}
 
 	} else if walletCmd.Parsed() {
-		newWallet()
+		switch walletCmd.Arg(0) {
+		case "":
+			newWallet()
+		case "create":
+			err = walletCreateCmd.Parse(walletCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *walletCreateMnemonic {
+				newSeedWallet()
+			} else {
+				newWallet()
+			}
+		case "restore":
+			err = walletRestoreCmd.Parse(walletCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *walletRestoreGap <= 0 {
+				walletRestoreCmd.Usage()
+			} else {
+				restoreWallet(*walletRestoreGap)
+			}
+		case "export":
+			err = walletExportCmd.Parse(walletCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *walletExportAddr == "" {
+				walletExportCmd.Usage()
+			} else {
+				exportKey(*walletExportAddr)
+			}
+		case "import":
+			err = walletImportCmd.Parse(walletCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *walletImportKey == "" {
+				walletImportCmd.Usage()
+			} else {
+				importKey(*walletImportKey, *walletImportRescan)
+			}
+		case "pubkey":
+			err = walletPubkeyCmd.Parse(walletCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *walletPubkeyAddr == "" {
+				walletPubkeyCmd.Usage()
+			} else {
+				showPubkey(*walletPubkeyAddr)
+			}
+		case "watch":
+			err = walletWatchCmd.Parse(walletCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if (*walletWatchAddr == "") == (*walletWatchPubkey == "") {
+				walletWatchCmd.Usage()
+			} else {
+				watchAddress(*walletWatchAddr, *walletWatchPubkey)
+			}
+		case "encrypt":
+			encryptWallet()
+		case "unlock":
+			err = walletUnlockCmd.Parse(walletCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *walletUnlockTimeout <= 0 {
+				walletUnlockCmd.Usage()
+			} else {
+				unlockWallet(*walletUnlockTimeout)
+			}
+		case "lock":
+			lockWallet()
+		case "changepassphrase":
+			changePassphrase()
+		default:
+			walletCmd.Usage()
+		}
+
+	} else if multisigCmd.Parsed() {
+		switch multisigCmd.Arg(0) {
+		case "create":
+			err = multisigCreateCmd.Parse(multisigCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *multisigCreateM <= 0 || *multisigCreateKeys == "" {
+				multisigCreateCmd.Usage()
+			} else {
+				createMultisig(*multisigCreateM, *multisigCreateKeys)
+			}
+		default:
+			multisigCmd.Usage()
+		}
+
+	} else if htlcCmd.Parsed() {
+		switch htlcCmd.Arg(0) {
+		case "lock":
+			err = htlcLockCmd.Parse(htlcCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *htlcLockFrom == "" || *htlcLockTo == "" || *htlcLockAmount <= 0 || *htlcLockFee < 0 || *htlcLockFeeRate < 0 || *htlcLockLockTime <= 0 {
+				htlcLockCmd.Usage()
+			} else {
+				lockHTLC(*htlcLockFrom, *htlcLockTo, *htlcLockAmount, *htlcLockFee, *htlcLockFeeRate, *htlcLockLockTime, *htlcLockHash, *htlcLockNode)
+			}
+		case "show":
+			err = htlcShowCmd.Parse(htlcCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *htlcShowContract == "" {
+				htlcShowCmd.Usage()
+			} else {
+				showHTLC(*htlcShowContract)
+			}
+		case "claim":
+			err = htlcClaimCmd.Parse(htlcCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *htlcClaimContract == "" || *htlcClaimPreimage == "" || *htlcClaimFee < 0 {
+				htlcClaimCmd.Usage()
+			} else {
+				claimHTLC(*htlcClaimContract, *htlcClaimPreimage, *htlcClaimFee, *htlcClaimNode)
+			}
+		case "refund":
+			err = htlcRefundCmd.Parse(htlcCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *htlcRefundContract == "" || *htlcRefundFee < 0 {
+				htlcRefundCmd.Usage()
+			} else {
+				refundHTLC(*htlcRefundContract, *htlcRefundFee, *htlcRefundNode)
+			}
+		default:
+			htlcCmd.Usage()
+		}
 
 	} else if listCmd.Parsed() {
 		listAddresses()
@@ -83,16 +351,135 @@ func Run() {
 			queryBalance(*balanceAddr)
 		}
 
+	} else if historyCmd.Parsed() {
+		if *historyAddr == "" || *historyPage <= 0 || *historySize <= 0 {
+			historyCmd.Usage()
+		} else {
+			showHistory(*historyAddr, *historyPage, *historySize)
+		}
+
 	} else if tradeCmd.Parsed() {
 		amount, err := strconv.Atoi(*tradeAmount)
-		if err != nil || *tradeFrom == "" || *tradeTo == "" || amount <= 0 {
+		if err != nil || *tradeFrom == "" || *tradeTo == "" || amount <= 0 || *tradeFee < 0 || *tradeFeeRate < 0 || *tradeLockTime < 0 {
 			tradeCmd.Usage()
 		} else {
-			startTrade(*tradeFrom, *tradeTo, amount)
+			startTrade(*tradeFrom, *tradeTo, amount, *tradeFee, *tradeFeeRate, *tradeLockTime, *tradeNode)
+		}
+
+	} else if notarizeCmd.Parsed() {
+		switch notarizeCmd.Arg(0) {
+		case "":
+			if *notarizeFile == "" || *notarizeFrom == "" || *notarizeFee < 0 || *notarizeFeeRate < 0 {
+				notarizeCmd.Usage()
+			} else {
+				notarizeDocument(*notarizeFile, *notarizeFrom, *notarizeFee, *notarizeFeeRate, *notarizeNode)
+			}
+		case "verify":
+			err = notarizeVerifyCmd.Parse(notarizeCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *notarizeVerifyFile == "" {
+				notarizeVerifyCmd.Usage()
+			} else {
+				verifyDocument(*notarizeVerifyFile)
+			}
+		default:
+			notarizeCmd.Usage()
+		}
+
+	} else if mineCmd.Parsed() {
+		if *mineAddr == "" {
+			mineCmd.Usage()
+		} else {
+			mineBlock(*mineAddr)
+		}
+
+	} else if nodeCmd.Parsed() {
+		if *nodePort <= 0 || *nodePort > 65535 {
+			nodeCmd.Usage()
+		} else {
+			startNode(*nodePort, *nodePeers, *nodeMiner)
+		}
+
+	} else if blockCmd.Parsed() {
+		if (*blockHeight < 0) == (*blockHash == "") {
+			blockCmd.Usage()
+		} else {
+			showBlock(*blockHeight, *blockHash)
+		}
+
+	} else if txCmd.Parsed() {
+		switch txCmd.Arg(0) {
+		case "":
+			if *txID == "" {
+				txCmd.Usage()
+			} else {
+				showTx(*txID)
+			}
+		case "create":
+			err = txCreateCmd.Parse(txCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *txCreateFrom == "" || *txCreateTo == "" || *txCreateAmount <= 0 || *txCreateFee < 0 || *txCreateFeeRate < 0 || *txCreateLockTime < 0 || *txCreateOut == "" {
+				txCreateCmd.Usage()
+			} else {
+				createTx(*txCreateFrom, *txCreateTo, *txCreateAmount, *txCreateFee, *txCreateFeeRate, *txCreateLockTime, *txCreateOut)
+			}
+		case "sign":
+			err = txSignCmd.Parse(txCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *txSignIn == "" {
+				txSignCmd.Usage()
+			} else if *txSignOut == "" {
+				signTx(*txSignIn, *txSignIn)
+			} else {
+				signTx(*txSignIn, *txSignOut)
+			}
+		case "submit", "broadcast":
+			err = txSubmitCmd.Parse(txCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *txSubmitIn == "" {
+				txSubmitCmd.Usage()
+			} else {
+				submitTx(*txSubmitIn, *txSubmitNode)
+			}
+		default:
+			txCmd.Usage()
+		}
+
+	} else if proofCmd.Parsed() {
+		switch proofCmd.Arg(0) {
+		case "":
+			if *proofTx == "" || *proofOut == "" {
+				proofCmd.Usage()
+			} else {
+				proveTx(*proofTx, *proofOut)
+			}
+		case "verify":
+			err = proofVerifyCmd.Parse(proofCmd.Args()[1:])
+			if err != nil {
+				panic(err)
+			}
+			if *proofVerifyIn == "" {
+				proofVerifyCmd.Usage()
+			} else {
+				verifyTxProof(*proofVerifyIn, *proofVerifyHeader)
+			}
+		default:
+			proofCmd.Usage()
 		}
 
 	} else if reindexCmd.Parsed() {
-		reindexChain()
+		reindexChain(*reindexTxIndex)
+
+	} else if verifyCmd.Parsed() {
+		verifyChain()
 
 	} else if printCmd.Parsed() {
 		printChain()
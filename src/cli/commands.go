@@ -1,12 +1,22 @@
 package cli
 
 import (
+	"blockchain/core/block"
 	"blockchain/core/blockchain"
 	"blockchain/core/transaction"
 	"blockchain/core/wallet"
+	"blockchain/network"
 	"blockchain/utils"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 )
 
 // 判断是否是有效地址。
@@ -27,25 +37,259 @@ func newWallet() {
 	fmt.Printf("New wallet created: %s\n", address)
 }
 
+// 生成新的种子并由它派生钱包，打印用于备份的助记词。
+func newSeedWallet() {
+	wallets := wallet.LoadWallets()
+
+	mnemonic, err := wallets.CreateSeed()
+	if err != nil {
+		panic(err)
+	}
+	address := wallets.AddWallet()
+	wallets.Persist()
+
+	fmt.Println("Write down the following mnemonic and keep it safe, it will not be shown again:")
+	fmt.Printf("  %s\n", mnemonic)
+	fmt.Printf("New wallet created: %s\n", address)
+}
+
+// 从助记词恢复种子，并在区块链上重新扫描，找回被使用过的地址。
+func restoreWallet(gapLimit int) {
+	wallets := wallet.LoadWallets()
+	if wallets.HasSeed() {
+		panic(wallet.ErrHasSeed)
+	}
+
+	mnemonic, err := readPassphrase("Enter mnemonic: ")
+	if err != nil {
+		panic(err)
+	}
+	err = wallets.RestoreSeed(string(mnemonic))
+	if err != nil {
+		panic(err)
+	}
+
+	var addresses []string
+	if blockchain.ChainExists(blockchain.DbPath()) {
+		chain := blockchain.LoadChain(blockchain.DbPath())
+		defer chain.Close()
+
+		addresses = wallets.Rescan(gapLimit, func(address string) bool {
+			_, total := chain.History(address, 0, 1)
+			return total > 0
+		})
+	}
+	if len(addresses) == 0 {
+		addresses = append(addresses, wallets.AddWallet())
+	}
+	wallets.Persist()
+
+	for _, address := range addresses {
+		fmt.Printf("Wallet restored: %s\n", address)
+	}
+}
+
+// 导出指定地址的私钥。
+func exportKey(address string) {
+	wallets := wallet.LoadWallets()
+
+	key := wallets.ExportKey(address)
+
+	fmt.Printf("Private key of %s: %s\n", address, key)
+}
+
+// 导入私钥。rescan 为 true 时打印导入地址在区块链上的余额和最近 10 条交易记录。
+// 地址索引记录区块链上出现过的所有地址，导入之前的交易也已在索引中，无需重放区块链。
+func importKey(key string, rescan bool) {
+	wallets := wallet.LoadWallets()
+
+	address, err := wallets.ImportKey(key)
+	if err != nil {
+		panic(err)
+	}
+	wallets.Persist()
+
+	fmt.Printf("Wallet imported: %s\n", address)
+
+	if rescan && blockchain.ChainExists(blockchain.DbPath()) {
+		chain := blockchain.LoadChain(blockchain.DbPath())
+		defer chain.Close()
+
+		balance := chain.GetBalance(address)
+		entries, total := chain.History(address, 0, 10)
+		fmt.Printf("Balance of %s: %d, %d transactions.\n", address, balance, total)
+		for _, entry := range entries {
+			printHistoryEntry(entry)
+		}
+	}
+}
+
+// 打印指定地址的公钥，用于创建多重签名地址。
+func showPubkey(address string) {
+	wallets := wallet.LoadWallets()
+
+	pubkey := wallets.Pubkey(address)
+	if pubkey == nil {
+		panic("public key of the address is unknown")
+	}
+
+	fmt.Printf("Public key of %s: %x\n", address, pubkey)
+}
+
+// 添加只观察的地址或公钥。
+func watchAddress(address string, pubkeyHex string) {
+	var pubkey []byte
+	if pubkeyHex != "" {
+		var err error
+		pubkey, err = hex.DecodeString(pubkeyHex)
+		if err != nil {
+			panic(err)
+		}
+	} else if !isValidAddress(address) {
+		panic("invalid address")
+	}
+
+	wallets := wallet.LoadWallets()
+
+	address, err := wallets.Watch(address, pubkey)
+	if err != nil {
+		panic(err)
+	}
+	wallets.Persist()
+
+	fmt.Printf("Watching address: %s\n", address)
+}
+
+// 创建 m-of-n 多重签名地址。keys 中的每一项是十六进制公钥，或公钥已知的钱包地址。
+func createMultisig(m int, keys string) {
+	wallets := wallet.LoadWallets()
+
+	var pubkeys [][]byte
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if pubkey := wallets.Pubkey(key); pubkey != nil {
+			pubkeys = append(pubkeys, pubkey)
+			continue
+		}
+		pubkey, err := hex.DecodeString(key)
+		if err != nil {
+			panic(fmt.Sprintf("%s is neither a public key nor a wallet address with known public key", key))
+		}
+		pubkeys = append(pubkeys, pubkey)
+	}
+
+	address, err := wallets.AddMultisig(m, pubkeys)
+	if err != nil {
+		panic(err)
+	}
+	wallets.Persist()
+
+	fmt.Printf("Multisig address created (%d-of-%d): %s\n", m, len(pubkeys), address)
+	fmt.Println("Share the address with the participants, who create it with the same -m and -keys in the same order.")
+}
+
+// 用口令加密钱包。
+func encryptWallet() {
+	wallets := wallet.LoadWallets()
+	if wallets.IsEncrypted() {
+		panic(wallet.ErrEncrypted)
+	}
+
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		panic(err)
+	}
+	err = wallets.Encrypt(passphrase)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Wallet encrypted.")
+}
+
+// 解锁钱包，在 timeout 秒内使用钱包无需再输入口令。
+func unlockWallet(timeout int) {
+	wallets := wallet.LoadWallets()
+	if !wallets.IsEncrypted() {
+		panic(wallet.ErrNotEncrypted)
+	}
+
+	passphrase, err := readPassphrase("Enter wallet passphrase: ")
+	if err != nil {
+		panic(err)
+	}
+	err = wallets.Unlock(passphrase)
+	if err != nil {
+		panic(err)
+	}
+	wallets.KeepUnlocked(time.Duration(timeout) * time.Second)
+
+	fmt.Printf("Wallet unlocked for %d seconds.\n", timeout)
+	fmt.Println("The decryption key stays in wallets.unlock until `wallet lock`, or until a wallet command runs after it expires.")
+}
+
+// 立即锁定钱包。
+func lockWallet() {
+	wallet.Lock()
+
+	fmt.Println("Wallet locked.")
+}
+
+// 更换钱包口令。
+func changePassphrase() {
+	wallets := wallet.LoadWallets()
+	if !wallets.IsEncrypted() {
+		panic(wallet.ErrNotEncrypted)
+	}
+
+	oldPassphrase, err := readPassphrase("Enter current passphrase: ")
+	if err != nil {
+		panic(err)
+	}
+	newPassphrase, err := readNewPassphrase()
+	if err != nil {
+		panic(err)
+	}
+	err = wallets.ChangePassphrase(oldPassphrase, newPassphrase)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Passphrase changed.")
+}
+
 // 列出地址。
 func listAddresses() {
 	wallets := wallet.LoadWallets()
 
-	for index, address := range wallets.Addresses() {
-		fmt.Printf("Wallet %d address: %s\n", index, address)
+	for index, address := range append(wallets.Addresses(), wallets.MultisigAddresses()...) {
+		fmt.Printf("Wallet %d address: %s%s\n", index, address, addressMark(wallets.Multisig(address), wallets.IsWatchOnly(address)))
+	}
+}
+
+// 只观察的地址和多重签名地址在输出中附加的标记。
+func addressMark(script *transaction.MultisigScript, watchOnly bool) string {
+	if script != nil {
+		return fmt.Sprintf(" (multisig %d-of-%d)", script.M, len(script.Pubkeys))
+	}
+	if watchOnly {
+		return " (watch-only)"
 	}
+	return ""
 }
 
 // 创建区块链。
-func newChain(address string) {
+func newChain(address string, txIndex bool) {
 	if !isValidAddress(address) {
 		panic("invalid address")
 	}
 
-	chain := blockchain.NewChain(address)
+	chain := blockchain.NewChain(blockchain.DbPath(), address)
 	defer chain.Close()
 
-	chain.Reindex()
+	if txIndex {
+		chain.IndexTxs()
+	}
 
 	fmt.Println("New chain created.")
 }
@@ -56,16 +300,173 @@ func queryBalance(address string) {
 		panic("invalid address")
 	}
 
-	chain := blockchain.LoadChain()
+	chain := blockchain.LoadChain(blockchain.DbPath())
 	defer chain.Close()
 
 	balance := chain.GetBalance(address)
+	wallets := wallet.LoadWallets()
+	mark := addressMark(wallets.Multisig(address), wallets.IsWatchOnly(address))
+
+	fmt.Printf("Balance of %s%s: %d\n", address, mark, balance)
+}
+
+// 分页查询地址的交易记录，从新到旧排列。
+func showHistory(address string, page int, size int) {
+	if !isValidAddress(address) {
+		panic("invalid address")
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
 
-	fmt.Printf("Balance of %s: %d\n", address, balance)
+	entries, total := chain.History(address, (page-1)*size, size)
+	pages := (total + size - 1) / size
+	fmt.Printf("History of %s: %d transactions, page %d of %d.\n", address, total, page, pages)
+
+	for _, entry := range entries {
+		printHistoryEntry(entry)
+	}
+}
+
+// 打印一条交易记录。
+func printHistoryEntry(entry *blockchain.HistoryEntry) {
+	fmt.Printf("Height %d, transaction %x: received %d, sent %d, change %d, net %+d\n",
+		entry.Height, entry.TxID, entry.Received, entry.Sent, entry.Change, entry.Net())
 }
 
 // 发起交易。
-func startTrade(from string, to string, amount int) {
+func startTrade(from string, to string, amount int, fee int, feeRate int, lockTime int64, node string) {
+	if !isValidAddress(from) {
+		panic("invalid address <from>")
+	}
+	if !isValidAddress(to) {
+		panic("invalid address <to>")
+	}
+	if from == to {
+		panic("invalid trade cycle")
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	submitNewTx(chain, chain.NewUtxoTx(from, to, amount, fee, feeRate, lockTime), node)
+	if lockTime != 0 {
+		fmt.Printf("It stays in the mempool until lock time %d is reached.\n", lockTime)
+	}
+}
+
+// 将新建的交易提交到交易池，并转发给节点。
+func submitNewTx(chain *blockchain.Chain, tx *transaction.Transaction, node string) {
+	paid, err := chain.TxFee(tx)
+	if err != nil {
+		panic(err)
+	}
+	err = chain.SubmitTx(tx)
+	if err != nil {
+		panic(err)
+	}
+	if node != "" {
+		err = network.SendTx(node, tx)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	fmt.Printf("Transaction %x submitted: paid fee %d for %d bytes.\n", tx.ID, paid, tx.Size())
+}
+
+// 挖出新区块。
+func mineBlock(address string) {
+	if !isValidAddress(address) {
+		panic("invalid address")
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	cnt, err := chain.MinePending(context.Background(), address)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Block mined with %d pending transactions.\n", cnt)
+}
+
+// 启动节点。
+func startNode(port int, peers string, miner string) {
+	if miner != "" && !isValidAddress(miner) {
+		panic("invalid address")
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	var seeds []string
+	for _, peer := range strings.Split(peers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			seeds = append(seeds, peer)
+		}
+	}
+
+	node := network.NewNode(fmt.Sprintf("localhost:%d", port), chain, miner)
+	err := node.Run(seeds)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// 查询区块：给出哈希值时按哈希值查找，否则按高度查找。
+func showBlock(height int, hash string) {
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	var (
+		b   *block.Block
+		err error
+	)
+	if hash != "" {
+		hashBytes, decodeErr := hex.DecodeString(hash)
+		if decodeErr != nil {
+			panic("invalid block hash")
+		}
+		b, err = chain.BlockByHash(hashBytes)
+	} else {
+		b, err = chain.BlockByHeight(height)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	b.Print()
+}
+
+// 查询交易及其确认数。交易仍在交易池内时确认数为 0。
+func showTx(id string) {
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		panic("invalid transaction id")
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	tx, loc, err := chain.LocateTx(txID)
+	if err != nil {
+		tx = chain.PendingTx(txID)
+		if tx == nil {
+			panic(err)
+		}
+		fmt.Println("Status:        pending, 0 confirmations")
+	} else {
+		fmt.Printf("Status:        %d confirmations\n", chain.Height()-loc.Height+1)
+		fmt.Printf("Block:         %x (height %d, position %d)\n", loc.BlockHash, loc.Height, loc.Position)
+	}
+
+	tx.Print()
+}
+
+// 创建未签名的交易，连同被引用的交易写入文件，交给持有私钥的机器签名。
+func createTx(from string, to string, amount int, fee int, feeRate int, lockTime int64, out string) {
 	if !isValidAddress(from) {
 		panic("invalid address <from>")
 	}
@@ -76,30 +477,334 @@ func startTrade(from string, to string, amount int) {
 		panic("invalid trade cycle")
 	}
 
-	chain := blockchain.LoadChain()
+	chain := blockchain.LoadChain(blockchain.DbPath())
 	defer chain.Close()
 
-	tx := chain.NewUtxoTx(from, to, amount)
-	coinbaseTx := blockchain.NewCoinbaseTx(from, "")
-	chain.AddBlock([]*transaction.Transaction{coinbaseTx, tx})
+	p := chain.NewPartialTx(from, to, amount, fee, feeRate, lockTime)
+	err := ioutil.WriteFile(out, p.Marshal(), 0644)
+	if err != nil {
+		panic(err)
+	}
 
-	fmt.Println("Trade completed.")
+	fmt.Printf("Unsigned transaction %x written to %s: %d inputs, fee %d.\n", p.Tx.ID, out, len(p.Tx.Inputs), p.Fee())
+}
+
+// 用钱包中的私钥对文件中的交易签名，无需访问区块链。
+func signTx(in string, out string) {
+	p := readPartialTx(in)
+	wallets := wallet.LoadWallets()
+
+	// 签名前列出交易的去向，便于在离线机器上核对。
+	for index, txo := range p.Tx.Outputs {
+		fmt.Printf("Output %d: %d to %s\n", index, txo.Value, wallet.OutputAddress(txo))
+	}
+	fmt.Printf("Fee: %d\n", p.Fee())
+
+	signed := wallets.SignPartialTx(p)
+	err := ioutil.WriteFile(out, p.Marshal(), 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	if p.IsComplete() {
+		fmt.Printf("Signed %d inputs, transaction complete, written to %s.\n", signed, out)
+	} else {
+		fmt.Printf("Signed %d inputs, more signatures needed, written to %s.\n", signed, out)
+	}
+}
+
+// 验证文件中签名完成的交易，并提交到交易池。
+func submitTx(in string, node string) {
+	p := readPartialTx(in)
+	if !p.IsComplete() {
+		panic("transaction is not fully signed")
+	}
+
+	if err := p.Verify(); err != nil {
+		panic(err)
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	tx := p.Tx
+	paid, err := chain.TxFee(tx)
+	if err != nil {
+		panic(err)
+	}
+	err = chain.SubmitTx(tx)
+	if err != nil {
+		panic(err)
+	}
+	if node != "" {
+		err = network.SendTx(node, tx)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	fmt.Printf("Transaction %x submitted: paid fee %d for %d bytes.\n", tx.ID, paid, tx.Size())
+}
+
+// 为区块链上的交易生成包含证明，写入文件。证明只含区块头和 Merkle 路径，无需整个区块即可验证。
+func proveTx(id string, out string) {
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		panic("invalid transaction id")
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	_, loc, err := chain.LocateTx(txID)
+	if err != nil {
+		panic(err)
+	}
+	b, err := chain.BlockByHash(loc.BlockHash)
+	if err != nil {
+		panic(err)
+	}
+	proof, err := b.ProveTx(loc.Position)
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile(out, proof.Marshal(), 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Proof of transaction %x in block %x (height %d) written to %s: %d hashes in the merkle path.\n", txID, b.Hash, b.Height, out, len(proof.Proof.Siblings))
+}
+
+// 验证文件中的交易包含证明。证明中的区块须与可信的区块头核对：
+// 给出 header 时，证明中的区块哈希值须与之相同；否则须在本地主链上的同一高度。
+func verifyTxProof(in string, header string) {
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		panic(err)
+	}
+	proof, err := block.ParseTxProof(data)
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		trusted       []byte
+		confirmations int
+	)
+	if header != "" {
+		trusted, err = hex.DecodeString(header)
+		if err != nil {
+			panic("invalid block header hash")
+		}
+	} else {
+		if !blockchain.ChainExists(blockchain.DbPath()) {
+			panic("no trusted header: run with a local chain or pass -header <hash>")
+		}
+		chain := blockchain.LoadChain(blockchain.DbPath())
+		defer chain.Close()
+
+		local, err := chain.HeaderByHeight(proof.Height)
+		if err != nil {
+			panic(fmt.Sprintf("block %x is not on the local main chain: %s", proof.Hash, err))
+		}
+		trusted = local.ComputeHash()
+		confirmations = chain.Height() - proof.Height + 1
+	}
+	if err = proof.Verify(trusted); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Transaction %x is included in block %x.\n", proof.Tx.ID, proof.Hash)
+	fmt.Printf("Block height:  %d\n", proof.Height)
+	fmt.Printf("Timestamp:     %s\n", time.Unix(proof.Header.Timestamp, 0).UTC().Format(time.RFC3339))
+	if confirmations > 0 {
+		fmt.Printf("The block is on the local main chain with %d confirmations.\n", confirmations)
+	}
+}
+
+// 读取文件中的部分签名交易。
+func readPartialTx(path string) *transaction.PartialTx {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	p, err := transaction.ParsePartialTx(data)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// 创建哈希时间锁合约并向其付款。未给出哈希时生成新的原像。
+func lockHTLC(from string, to string, amount int, fee int, feeRate int, lockTime int64, hash string, node string) {
+	if !isValidAddress(from) {
+		panic("invalid address <from>")
+	}
+	if !isValidAddress(to) {
+		panic("invalid address <to>")
+	}
+
+	var secret []byte
+	secretHash, err := hex.DecodeString(hash)
+	if err != nil {
+		panic(err)
+	}
+	if hash == "" {
+		secret = make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			panic(err)
+		}
+		digest := sha256.Sum256(secret)
+		secretHash = digest[:]
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	contract, tx := chain.NewHTLCTx(from, to, amount, fee, feeRate, secretHash, lockTime)
+	printHTLC(contract)
+	fmt.Printf("Contract: %x\n", contract.Encode())
+	if secret != nil {
+		fmt.Printf("Secret: %x\n", secret)
+		fmt.Println("Keep the secret private until the counterparty has locked coins to the same secret hash.")
+	}
+	submitNewTx(chain, tx, node)
+}
+
+// 显示哈希时间锁合约的内容及其地址上的余额，供交易对方在锁定自己的钱之前核对。
+func showHTLC(contractHex string) {
+	contract := parseHTLC(contractHex)
+	printHTLC(contract)
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	fmt.Printf("Balance: %d\n", chain.GetBalance(wallet.ScriptAddressOf(contract.Hash())))
+}
+
+// 出示原像，领取哈希时间锁合约上的钱。
+func claimHTLC(contractHex string, preimageHex string, fee int, node string) {
+	contract := parseHTLC(contractHex)
+	preimage, err := hex.DecodeString(preimageHex)
+	if err != nil {
+		panic(err)
+	}
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	submitNewTx(chain, chain.NewHTLCClaimTx(contract, preimage, fee), node)
+}
+
+// 锁定时间到达后，取回哈希时间锁合约上的钱。
+func refundHTLC(contractHex string, fee int, node string) {
+	contract := parseHTLC(contractHex)
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	submitNewTx(chain, chain.NewHTLCRefundTx(contract, fee), node)
+	fmt.Printf("It stays in the mempool until lock time %d is reached.\n", contract.LockTime)
+}
+
+// 解析十六进制的哈希时间锁合约。
+func parseHTLC(contractHex string) *transaction.HTLCScript {
+	data, err := hex.DecodeString(contractHex)
+	if err != nil {
+		panic(err)
+	}
+	contract, err := transaction.ParseHTLCScript(data)
+	if err != nil {
+		panic(err)
+	}
+	return contract
+}
+
+// 打印哈希时间锁合约的内容。
+func printHTLC(contract *transaction.HTLCScript) {
+	fmt.Printf("Contract address: %s\n", wallet.ScriptAddressOf(contract.Hash()))
+	fmt.Printf("Recipient:        %s\n", wallet.AddressOf(contract.Recipient))
+	fmt.Printf("Refund to:        %s\n", wallet.AddressOf(contract.Sender))
+	fmt.Printf("Lock time:        %d\n", contract.LockTime)
+	fmt.Printf("Secret hash:      %x\n", contract.SecretHash)
+}
+
+// 将文件的 SHA-256 哈希值写入一笔交易的数据输出，作为文件在此时已经存在的证明。
+func notarizeDocument(path string, from string, fee int, feeRate int, node string) {
+	if !isValidAddress(from) {
+		panic("invalid address <from>")
+	}
+	digest := documentDigest(path)
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	tx := chain.NewDataTx(from, digest, fee, feeRate)
+	fmt.Printf("Document hash: %x\n", digest)
+	submitNewTx(chain, tx, node)
+	fmt.Println("Once the transaction is mined, `notarize verify` proves the document existed at the time of its block.")
+}
+
+// 查找写入了文件哈希值的区块，打印区块的时间戳作为文件存在的证明。
+func verifyDocument(path string) {
+	digest := documentDigest(path)
+
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	tx, loc, b, err := chain.FindData(digest)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Document hash: %x\n", digest)
+	fmt.Printf("Transaction:   %x\n", tx.ID)
+	fmt.Printf("Block:         %x (height %d, %d confirmations)\n", loc.BlockHash, loc.Height, chain.Height()-loc.Height+1)
+	fmt.Printf("Timestamp:     %s\n", time.Unix(b.Timestamp, 0).UTC().Format(time.RFC3339))
+}
+
+// 计算文件的 SHA-256 哈希值。
+func documentDigest(path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	digest := sha256.Sum256(data)
+	return digest[:]
 }
 
 // 重新索引区块链。
-func reindexChain() {
-	chain := blockchain.LoadChain()
+func reindexChain(txIndex bool) {
+	chain := blockchain.LoadChain(blockchain.DbPath())
 	defer chain.Close()
 
 	chain.Reindex()
+	if txIndex || chain.HasTxIndex() {
+		chain.IndexTxs()
+	}
 	cnt := chain.CountTx()
 
 	fmt.Printf("Reindex completed: %d transactions in chain.", cnt)
 }
 
+// 校验区块链。
+func verifyChain() {
+	chain := blockchain.LoadChain(blockchain.DbPath())
+	defer chain.Close()
+
+	err := chain.Validate()
+	if err != nil {
+		fmt.Printf("Chain is invalid: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Chain is valid.")
+}
+
 // 打印区块链。
 func printChain() {
-	chain := blockchain.LoadChain()
+	chain := blockchain.LoadChain(blockchain.DbPath())
 	defer chain.Close()
 
 	chain.Print()
@@ -109,11 +814,64 @@ func printChain() {
 func showHelp() {
 	fmt.Println("Usage:")
 	fmt.Println("  wallet                                               Create a new wallet.")
+	fmt.Println("  wallet     create [-mnemonic]                        Create a new wallet. With -mnemonic, derive wallets from a new seed")
+	fmt.Println("                                                       and print its mnemonic for backup.")
+	fmt.Println("  wallet     restore [-gap <count>]                    Restore the seed from a mnemonic and rediscover used addresses,")
+	fmt.Println("                                                       stopping after <count> consecutive unused ones.")
+	fmt.Println("  wallet     export -address <address>                 Print the private key of <address>.")
+	fmt.Println("  wallet     import -key <key> [-rescan]               Import a private key printed by `wallet export`.")
+	fmt.Println("                                                       With -rescan, show its balance and latest transactions.")
+	fmt.Println("  wallet     pubkey -address <address>                 Print the public key of <address>, to share for multisig.")
+	fmt.Println("  wallet     watch -address <address> | -pubkey <hex>  Track the balance and history of an address without its private key.")
+	fmt.Println("  wallet     encrypt                                   Encrypt the wallet file with a passphrase.")
+	fmt.Println("  wallet     unlock [-timeout <seconds>]               Keep the encrypted wallet unlocked for <seconds>. The decryption key")
+	fmt.Println("                                                       is written to wallets.unlock and only removed by `wallet lock`, or by")
+	fmt.Println("                                                       the first wallet command after <seconds>; until then anyone who can")
+	fmt.Println("                                                       read the file can decrypt the keys.")
+	fmt.Println("  wallet     lock                                      Lock the encrypted wallet now.")
+	fmt.Println("  wallet     changepassphrase                          Change the passphrase of the encrypted wallet.")
+	fmt.Println("  multisig   create -m <m> -keys <a,b,c>               Create an address spendable with <m> signatures of the keys <a,b,c>,")
+	fmt.Println("                                                       given as public keys in hex or wallet addresses.")
+	fmt.Println("  htlc       lock -from <from> -to <to> -amount <n>    Lock <n> coins that <to> can claim with a secret, and <from> can refund")
+	fmt.Println("             -locktime <t> [-hash <hex>]               after lock time <t>. Use the secret hash <hex> of the counterparty's")
+	fmt.Println("             [-fee <fee> | -feerate <rate>]            contract for an atomic swap, or generate a new secret.")
+	fmt.Println("             [-node <address>]")
+	fmt.Println("  htlc       show -contract <hex>                      Print the terms and balance of a contract before trusting it.")
+	fmt.Println("  htlc       claim -contract <hex> -preimage <hex>     Claim the coins of a contract with its secret, which then becomes")
+	fmt.Println("             [-fee <fee>] [-node <address>]            visible to the sender in the input script of the transaction.")
+	fmt.Println("  htlc       refund -contract <hex> [-fee <fee>]       Take back the coins of a contract once its lock time is reached.")
+	fmt.Println("             [-node <address>]")
 	fmt.Println("  list                                                 List the addresses of all wallets.")
-	fmt.Println("  chain      -address <address>                        Create a new blockchain mined out by <address>.")
+	fmt.Println("  chain      -address <address> [-txindex]             Create a new blockchain mined out by <address>.")
+	fmt.Println("                                                       With -txindex, maintain an index of transactions by ID.")
 	fmt.Println("  balance    -address <address>                        Query balance of <address>.")
-	fmt.Println("  trade      -from <from> -to <to> -amount <amount>    Trade <amount> of coins from <from> to <to>.")
-	fmt.Println("  reindex                                              Reindex the transactions in chain.")
+	fmt.Println("  history    -address <address> [-page <n>]            List transactions of <address>, newest first,")
+	fmt.Println("             [-size <size>]                            <size> per page.")
+	fmt.Println("  trade      -from <from> -to <to> -amount <amount>    Submit a trade of <amount> coins from <from> to <to> to the mempool.")
+	fmt.Println("             [-fee <fee> | -feerate <rate>]            Pay <fee> coins, or <rate> coins per byte, to the miner.")
+	fmt.Println("             [-locktime <n>]                           Not mined before block height <n>, or Unix time <n> if at least 500000000.")
+	fmt.Println("             [-node <address>]                         Also relay the transaction to the node at <address>.")
+	fmt.Println("  notarize   -file <file> -from <from>                 Anchor the SHA-256 hash of <file> on chain in a data output,")
+	fmt.Println("             [-fee <fee> | -feerate <rate>]            paying the fee from <from>.")
+	fmt.Println("             [-node <address>]")
+	fmt.Println("  notarize   verify -file <file>                       Find the block anchoring <file> and print its timestamp.")
+	fmt.Println("  mine       -address <address>                        Mine pending transactions into a block rewarding <address>.")
+	fmt.Println("  node       -port <port> [-peers <a,b>]               Run a P2P node on <port> syncing with peers <a,b>.")
+	fmt.Println("             [-miner <address>]                        Mine pending transactions, rewarding <address>.")
+	fmt.Println("                                                       Set NODE_ID to give each local node its own chain database.")
+	fmt.Println("  block      -height <height> | -hash <hash>           Print the block at <height> on the chain, or with <hash>.")
+	fmt.Println("  tx         -id <id>                                  Print the transaction <id> with its confirmations.")
+	fmt.Println("  tx         create -from <from> -to <to>              Write an unsigned trade of <amount> coins from <from> to <to> to <file>,")
+	fmt.Println("             -amount <amount> -out <file>              together with the transactions it spends.")
+	fmt.Println("             [-fee <fee> | -feerate <rate>]            Pay <fee> coins, or <rate> coins per byte, to the miner.")
+	fmt.Println("             [-locktime <n>]                           Not mined before block height <n>, or Unix time <n> if at least 500000000.")
+	fmt.Println("  tx         sign -in <file> [-out <file>]             Sign the transaction in <file> with the keys in the wallet, offline.")
+	fmt.Println("  tx         submit -in <file> [-node <address>]       Verify the signed transaction in <file> and submit it to the mempool.")
+	fmt.Println("  proof      -tx <id> -out <file>                      Write a proof that transaction <id> is in its block to <file>.")
+	fmt.Println("  proof      verify -in <file> [-header <hash>]        Check the proof in <file> against the local main chain, or the trusted")
+	fmt.Println("                                                       block header <hash>.")
+	fmt.Println("  reindex    [-txindex]                                Reindex the transactions in chain, and the index of transactions by ID.")
+	fmt.Println("  verify                                               Validate every block and transaction from genesis.")
 	fmt.Println("  print                                                Print blockchain information.")
 	fmt.Println("  help                                                 Show help of commands.")
 }
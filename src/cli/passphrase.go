@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// 标准输入不是终端时，从中按行读取口令。
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// 读取口令。标准输入是终端时关闭回显。
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+
+	line, err := stdinReader.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// 读取新口令，并要求再输入一遍确认。
+func readNewPassphrase() ([]byte, error) {
+	passphrase, err := readPassphrase("Enter new passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+
+	confirm, err := readPassphrase("Repeat new passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, confirm) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
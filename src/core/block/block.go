@@ -2,6 +2,7 @@ package block
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"time"
@@ -11,41 +12,87 @@ import (
 
 // 区块结构。
 type Block struct {
-	Timestamp     int64                      // 时间戳。
-	Transactions  []*transaction.Transaction // 交易列表。
-	PrevBlockHash []byte                     // 前一区块哈希值。
-	Hash          []byte                     // 本区块哈希值。
-	Nonce         int                        // 随机数。
+	Header                                  // 区块头。
+	Transactions []*transaction.Transaction // 交易列表。
+	Hash         []byte                     // 本区块哈希值，即区块头的哈希值。
+	Height       int                        // 区块高度，创世块为 0。不参与哈希计算，由父区块的高度确定。
+}
+
+// 区块在数据库中的格式。升级前的区块没有区块头，其字段直接位于区块内，也没有记录 Merkle 树根。
+type storedBlock struct {
+	Header        Header
+	Transactions  []*transaction.Transaction
+	Hash          []byte
+	Height        int
+	Timestamp     int64  // 升级前区块的时间戳。
+	PrevBlockHash []byte // 升级前区块的前一区块哈希值。
+	Nonce         int    // 升级前区块的随机数。
+	Target        []byte // 升级前区块的目标值。
 }
 
 // 创建区块。
-func NewBlock(txs []*transaction.Transaction, prevBlockHash []byte) *Block {
-	block := &Block{
-		Timestamp:     time.Now().Unix(),
-		Transactions:  txs,
-		PrevBlockHash: prevBlockHash,
-		Hash:          []byte{},
-		Nonce:         0,
+func NewBlock(txs []*transaction.Transaction, prevBlockHash []byte, height int, target []byte) *Block {
+	block, err := NewBlockWithContext(context.Background(), txs, prevBlockHash, height, target)
+	if err != nil {
+		panic(err)
 	}
+	return block
+}
 
+// 创建区块，挖矿过程可通过 ctx 中止。
+func NewBlockWithContext(ctx context.Context, txs []*transaction.Transaction, prevBlockHash []byte, height int, target []byte) (*Block, error) {
+	block := NewBlockTemplate(txs, prevBlockHash, height, target)
+	err := block.MineAndReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// 证明区块的工作量，并打印挖矿统计信息。
+func (b *Block) MineAndReport(ctx context.Context) error {
 	fmt.Println("Mining new block...")
-	block.proofWork()
+	stats, err := b.Mine(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Block mined by %d workers: %d hashes in %s (%.0f H/s).\n", stats.Workers, stats.Hashes, stats.Duration, stats.HashRate())
+	return nil
+}
 
-	return block
+// 创建尚未挖矿的区块模板，之后调用 Mine 证明工作量。
+func NewBlockTemplate(txs []*transaction.Transaction, prevBlockHash []byte, height int, target []byte) *Block {
+	return &Block{
+		Header: Header{
+			Version:       HeaderVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     time.Now().Unix(),
+			Target:        target,
+			Nonce:         0,
+		},
+		Transactions: txs,
+		Hash:         []byte{},
+		Height:       height,
+	}
 }
 
 // 创建创世块。
 func NewGenesisBlock(coinbaseTx *transaction.Transaction) *Block {
-	return NewBlock([]*transaction.Transaction{coinbaseTx}, []byte{})
+	return NewBlock([]*transaction.Transaction{coinbaseTx}, []byte{}, 0, InitialTarget())
 }
 
 // 打印区块信息。
 func (b *Block) Print() {
 	fmt.Println("--------------------------------------------------------------------------------")
 
-	fmt.Printf("Hash:      %x\n", b.Hash)
-	fmt.Printf("Nonce:     %d\n", b.Nonce)
-	fmt.Printf("Prev hash: %x\n", b.PrevBlockHash)
+	fmt.Printf("Height:      %d\n", b.Height)
+	fmt.Printf("Hash:        %x\n", b.Hash)
+	fmt.Printf("Version:     %d\n", b.Version)
+	fmt.Printf("Timestamp:   %d\n", b.Timestamp)
+	fmt.Printf("Nonce:       %d\n", b.Nonce)
+	fmt.Printf("Target:      %064x\n", b.Target)
+	fmt.Printf("Prev hash:   %x\n", b.PrevBlockHash)
+	fmt.Printf("Merkle root: %x\n", b.MerkleRoot)
 
 	for index, tx := range b.Transactions {
 		fmt.Printf("\nTransaction %d:\n", index)
@@ -68,15 +115,25 @@ func (b *Block) Serialize() []byte {
 	return seq.Bytes()
 }
 
-// 反序列化区块。
+// 反序列化区块。升级前的区块补全区块头，版本为 0，Merkle 树根由交易计算。
 func DeserializeBlock(seq []byte) *Block {
-	var block Block
+	var stored storedBlock
 
 	decoder := gob.NewDecoder(bytes.NewReader(seq))
-	err := decoder.Decode(&block)
+	err := decoder.Decode(&stored)
 	if err != nil {
 		panic(err)
 	}
 
-	return &block
+	block := &Block{stored.Header, stored.Transactions, stored.Hash, stored.Height}
+	if len(block.MerkleRoot) == 0 {
+		block.Header = Header{
+			PrevBlockHash: stored.PrevBlockHash,
+			Timestamp:     stored.Timestamp,
+			Target:        stored.Target,
+			Nonce:         stored.Nonce,
+		}
+		block.MerkleRoot = block.MerkleTree().Root.Data
+	}
+	return block
 }
@@ -0,0 +1,84 @@
+package block
+
+import (
+	"blockchain/utils"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+)
+
+// 新区块使用的区块头版本。升级前的区块为 0。
+const HeaderVersion = 2
+
+// 从该版本起，区块的 Merkle 树区分底层与上层结点（merkle.NewTaggedMerkleTree）。
+// 之前版本的区块沿用原来的 Merkle 树，已有区块的树根和哈希值保持不变。
+const TaggedMerkleVersion = 2
+
+// 区块头：区块哈希值覆盖的全部字段。交易只通过 Merkle 树根参与哈希计算，
+// 因此只凭区块头即可验证工作量证明，无需解码交易。
+type Header struct {
+	Version       int    // 区块头版本。
+	PrevBlockHash []byte // 前一区块哈希值。
+	MerkleRoot    []byte // 区块内交易的 Merkle 树根结点值。
+	Timestamp     int64  // 时间戳。
+	Target        []byte // 工作量证明的目标值。
+	Nonce         int    // 随机数。
+}
+
+// 计算区块头的哈希值，即区块的哈希值。
+// 版本 0 的区块头沿用升级前的格式，不覆盖版本号，已有区块的哈希值保持不变。
+func (h *Header) hash() [32]byte {
+	fields := [][]byte{
+		utils.Int64ToBytes(h.Timestamp),
+		h.MerkleRoot,
+		h.PrevBlockHash,
+		utils.Int64ToBytes(int64(h.Nonce)),
+		h.Target,
+	}
+	if h.Version != 0 {
+		fields = append([][]byte{utils.Int64ToBytes(int64(h.Version))}, fields...)
+	}
+
+	return sha256.Sum256(bytes.Join(fields, []byte{}))
+}
+
+// 计算区块头的哈希值。
+func (h *Header) ComputeHash() []byte {
+	hash := h.hash()
+	return hash[:]
+}
+
+// 验证区块头的工作量证明：哈希值与记录的 hash 一致，且满足区块头自身承诺的目标值。
+func (h *Header) VerifyWork(hash []byte) bool {
+	if utils.BytesToBigInt(h.Target).Cmp(maxTarget) == 1 {
+		return false
+	}
+	computed := h.hash()
+	return bytes.Equal(computed[:], hash) && isProved(computed, h.Target)
+}
+
+// 序列化区块头。
+func (h *Header) Serialize() []byte {
+	var seq bytes.Buffer
+
+	encoder := gob.NewEncoder(&seq)
+	err := encoder.Encode(h)
+	if err != nil {
+		panic(err)
+	}
+
+	return seq.Bytes()
+}
+
+// 反序列化区块头。
+func DeserializeHeader(seq []byte) *Header {
+	var header Header
+
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	err := decoder.Decode(&header)
+	if err != nil {
+		panic(err)
+	}
+
+	return &header
+}
@@ -0,0 +1,107 @@
+package block
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 挖矿统计信息。
+type MiningStats struct {
+	Workers  int           // 参与挖矿的协程数。
+	Hashes   uint64        // 尝试过的哈希次数。
+	Duration time.Duration // 挖矿耗时。
+}
+
+// 计算每秒哈希次数。
+func (s MiningStats) HashRate() float64 {
+	seconds := s.Duration.Seconds()
+	if seconds == 0 {
+		return 0
+	}
+	return float64(s.Hashes) / seconds
+}
+
+// 使用所有 CPU 核心证明工作量。
+// 随机数空间按协程数交错划分，每个协程只尝试属于自己的随机数；
+// 若整个 64 位随机数空间都不满足目标值，就推进时间戳后重新开始。
+// ctx 被取消时立即停止，并返回 ctx 的错误。
+func (b *Block) Mine(ctx context.Context) (MiningStats, error) {
+	stats := MiningStats{Workers: runtime.NumCPU()}
+	start := time.Now()
+
+	// Merkle 树根在挖矿前计算一次，之后每次尝试只计算区块头的哈希值。
+	b.MerkleRoot = b.MerkleTree().Root.Data
+
+	for {
+		nonce, hash, found := b.mineRound(ctx, stats.Workers, &stats.Hashes)
+		if found {
+			b.Nonce = nonce
+			b.Hash = hash[:]
+			stats.Duration = time.Since(start)
+			return stats, nil
+		}
+		if err := ctx.Err(); err != nil {
+			stats.Duration = time.Since(start)
+			return stats, err
+		}
+
+		// 随机数空间已耗尽，推进时间戳以获得新的搜索空间。
+		now := time.Now().Unix()
+		if now <= b.Timestamp {
+			now = b.Timestamp + 1
+		}
+		b.Timestamp = now
+	}
+}
+
+// 在当前时间戳下，用多个协程搜索一轮随机数空间。
+func (b *Block) mineRound(ctx context.Context, workers int, hashes *uint64) (int, [32]byte, bool) {
+	roundCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once      sync.Once
+		wg        sync.WaitGroup
+		foundHash [32]byte
+		foundNon  int
+		found     bool
+	)
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+
+			// 每个协程使用区块头的副本，只修改其中的随机数。
+			header := b.Header
+			var local uint64
+			defer func() {
+				atomic.AddUint64(hashes, local)
+			}()
+
+			for nonce := start; nonce >= 0 && nonce < maxNonce; nonce += workers {
+				// 每隔一段时间检查一次是否需要停止。
+				if local%1024 == 0 && roundCtx.Err() != nil {
+					return
+				}
+
+				header.Nonce = nonce
+				hash := header.hash()
+				local++
+				if isProved(hash, b.Target) {
+					once.Do(func() {
+						foundNon, foundHash, found = nonce, hash, true
+						cancel()
+					})
+					return
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	return foundNon, foundHash, found
+}
@@ -4,58 +4,80 @@ import (
 	"blockchain/core/merkle"
 	"blockchain/utils"
 	"bytes"
-	"crypto/sha256"
 	"math"
 	"math/big"
 )
 
 // 难度系数。
-const difficulty = 8
+const initialDifficulty = 8 // 创世块的难度系数。
+const minDifficulty = 4     // 允许的最低难度系数。
 const maxNonce = math.MaxInt64
 
+// 难度调整。
+const RetargetInterval = 10 // 每隔多少个区块调整一次难度。
+const TargetSpacing = 10    // 期望的出块间隔（秒）。
+const maxAdjustFactor = 4   // 单次调整的最大倍数。
+
 // 工作量证明的目标。
-var target = big.NewInt(0).Lsh(big.NewInt(1), uint(256-difficulty))
+var initialTarget = big.NewInt(0).Lsh(big.NewInt(1), uint(256-initialDifficulty))
+var maxTarget = big.NewInt(0).Lsh(big.NewInt(1), uint(256-minDifficulty))
+
+// 获取创世块的目标值。
+func InitialTarget() []byte {
+	return initialTarget.Bytes()
+}
 
-// 获取区块内交易的 Merkle 树根结点值。
-func (b *Block) hashTx() []byte {
+// 根据上一个调整窗口的实际耗时，计算新的目标值。
+// 算法：新目标值 = 旧目标值 × 实际耗时 / 期望耗时，
+// 实际耗时被限制在期望耗时的 1/4 到 4 倍之间，新目标值不超过最低难度对应的目标值。
+func NextTarget(prevTarget []byte, firstTimestamp int64, lastTimestamp int64) []byte {
+	expected := int64((RetargetInterval - 1) * TargetSpacing)
+	actual := lastTimestamp - firstTimestamp
+	if actual < expected/maxAdjustFactor {
+		actual = expected / maxAdjustFactor
+	}
+	if actual > expected*maxAdjustFactor {
+		actual = expected * maxAdjustFactor
+	}
+
+	target := utils.BytesToBigInt(prevTarget)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(maxTarget) == 1 {
+		target.Set(maxTarget)
+	}
+	if target.Sign() == 0 {
+		target.SetInt64(1)
+	}
+
+	return target.Bytes()
+}
+
+// 创建区块内交易的 Merkle 树，树的种类由区块头版本决定。
+func (b *Block) MerkleTree() *merkle.Tree {
 	var txs [][]byte
 	for _, tx := range b.Transactions {
-		txs = append(txs, tx.Serialize())
+		txs = append(txs, tx.Encode())
+	}
+	if b.Version >= TaggedMerkleVersion {
+		return merkle.NewTaggedMerkleTree(txs)
 	}
-	tree := merkle.NewMerkleTree(txs)
-	return tree.Root.Data
+	return merkle.NewMerkleTree(txs)
 }
 
-// 获取区块的哈希值。
-func (b *Block) hash(nonce int) [32]byte {
-	blockBytes := bytes.Join(
-		[][]byte{
-			utils.Int64ToBytes(b.Timestamp),
-			b.hashTx(),
-			b.PrevBlockHash,
-			utils.Int64ToBytes(int64(nonce)),
-			utils.Int64ToBytes(int64(difficulty)),
-		},
-		[]byte{},
-	)
-
-	return sha256.Sum256(blockBytes)
+// 判断区块的交易列表是否被篡改：复制交易后 Merkle 树根不变，区块哈希值也不变，
+// 这样的区块必须拒绝，且不能因此把同一哈希值的有效区块视为无效。
+func (b *Block) HasMutatedMerkle() bool {
+	return b.MerkleTree().Mutated()
 }
 
 // 判断工作量是否被证明。
-func isProved(hash [32]byte) bool {
-	return utils.BytesToBigInt(hash[:]).Cmp(target) == -1
+func isProved(hash [32]byte, target []byte) bool {
+	return utils.BytesToBigInt(hash[:]).Cmp(utils.BytesToBigInt(target)) == -1
 }
 
-// 开始证明工作量。
-func (b *Block) proofWork() {
-	for nonce := 0; nonce < maxNonce; {
-		hash := b.hash(nonce)
-		if isProved(hash) {
-			b.Hash = hash[:]
-			b.Nonce = nonce
-			break
-		}
-		nonce++
-	}
+// 验证区块的工作量证明。
+// 即：区块头满足工作量证明，且区块头记录的 Merkle 树根与区块内的交易相符。
+func (b *Block) VerifyWork() bool {
+	return b.Header.VerifyWork(b.Hash) && bytes.Equal(b.MerkleRoot, b.MerkleTree().Root.Data)
 }
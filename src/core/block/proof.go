@@ -0,0 +1,89 @@
+package block
+
+import (
+	"blockchain/core/merkle"
+	"blockchain/core/transaction"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 交易包含证明的格式版本。
+const txProofVersion = 2
+
+// 交易包含证明：交易、它在区块 Merkle 树中的路径，以及区块头。
+// 以 JSON 格式传递，验证时只需要区块头，无需整个区块。（简单支付验证，SPV）
+type TxProof struct {
+	Version int                      // 格式版本。
+	Tx      *transaction.Transaction // 被证明的交易。
+	Proof   *merkle.Proof            // 交易在 Merkle 树中的路径。
+	Height  int                      // 区块高度，不参与哈希计算，仅供核对。
+	Hash    []byte                   // 区块哈希值。
+	Header  *Header                  // 区块头。
+}
+
+// 为区块内第 index 笔交易创建包含证明。
+func (b *Block) ProveTx(index int) (*TxProof, error) {
+	proof, err := b.MerkleTree().Proof(index)
+	if err != nil {
+		return nil, err
+	}
+	header := b.Header
+	return &TxProof{
+		Version: txProofVersion,
+		Tx:      b.Transactions[index],
+		Proof:   proof,
+		Height:  b.Height,
+		Hash:    b.Hash,
+		Header:  &header,
+	}, nil
+}
+
+// 解析 JSON 格式的交易包含证明。
+func ParseTxProof(data []byte) (*TxProof, error) {
+	var p TxProof
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Version != txProofVersion {
+		return nil, fmt.Errorf("unsupported transaction proof version %d", p.Version)
+	}
+	if p.Tx == nil || p.Proof == nil || p.Header == nil {
+		return nil, errors.New("transaction proof has no transaction, merkle path or block header")
+	}
+	return &p, nil
+}
+
+// 编码为 JSON 格式。
+func (p *TxProof) Marshal() []byte {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// 验证交易包含证明：证明中的区块就是可信的区块 trusted，区块头的哈希值与之一致并满足其目标值，
+// 交易的编码（含交易 ID）沿路径可以得到区块头中的 Merkle 树根。
+// 证明中的区块头由证明的提供方给出，只有与可信来源（如本地主链）的区块哈希值核对后才能说明交易已上链。
+func (p *TxProof) Verify(trusted []byte) error {
+	if len(trusted) == 0 {
+		return errors.New("no trusted block hash to check the proof against")
+	}
+	if !bytes.Equal(p.Hash, trusted) {
+		return fmt.Errorf("proof is for block %x, not the trusted block %x", p.Hash, trusted)
+	}
+	if !p.Header.VerifyWork(p.Hash) {
+		return errors.New("block header fails proof of work")
+	}
+	verify := merkle.VerifyProof
+	if p.Header.Version >= TaggedMerkleVersion {
+		verify = merkle.VerifyTaggedProof
+	}
+	if !verify(p.Header.MerkleRoot, p.Tx.Encode(), p.Proof) {
+		return errors.New("transaction is not included under the merkle root")
+	}
+	return nil
+}
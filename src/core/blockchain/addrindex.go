@@ -0,0 +1,206 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"blockchain/utils"
+	"bytes"
+	"encoding/gob"
+
+	"github.com/boltdb/bolt"
+)
+
+// 数据库。每个公钥哈希对应一个子 bucket：区块高度 + 交易 ID - 交易对该地址的收支。
+const addrIndexBucket = "addrindex"
+
+// 地址的一条交易记录。
+type HistoryEntry struct {
+	TxID     []byte // 交易 ID。
+	Height   int    // 交易所在区块的高度。
+	Received int    // 该地址未出资时收到的金额。
+	Sent     int    // 该地址出资的金额，即被消费的该地址的输出总额。
+	Change   int    // 该地址出资时找零给自己的金额。
+}
+
+// 获取交易使该地址余额发生的变化。
+func (e *HistoryEntry) Net() int {
+	return e.Received + e.Change - e.Sent
+}
+
+// 序列化交易记录。
+func (e *HistoryEntry) serialize() []byte {
+	var seq bytes.Buffer
+
+	encoder := gob.NewEncoder(&seq)
+	err := encoder.Encode(e)
+	if err != nil {
+		panic(err)
+	}
+
+	return seq.Bytes()
+}
+
+// 反序列化交易记录。
+func deserializeHistoryEntry(seq []byte) *HistoryEntry {
+	var entry HistoryEntry
+
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	err := decoder.Decode(&entry)
+	if err != nil {
+		panic(err)
+	}
+
+	return &entry
+}
+
+// 生成交易记录在地址子 bucket 中的键。按高度排列，便于从新到旧分页。
+func historyKey(height int, txID []byte) []byte {
+	return append(heightKey(height), txID...)
+}
+
+// 交易记录及其所属的公钥哈希。
+type addressEntry struct {
+	pubkeyHash []byte
+	entry      *HistoryEntry
+}
+
+// 计算区块内每笔交易对所涉及地址的收支。锁定到自定义脚本的输出没有地址，不计入。
+// spent 为区块内非 coinbase 交易依次消费的输出，与回滚数据的顺序相同。
+func addressEntries(b *block.Block, height int, spent []spentOutput) []addressEntry {
+	var entries []addressEntry
+	pos := 0
+	for _, tx := range b.Transactions {
+		byAddress := make(map[string]*HistoryEntry)
+		var order [][]byte
+		get := func(pubkeyHash []byte) *HistoryEntry {
+			if len(pubkeyHash) == 0 {
+				return &HistoryEntry{}
+			}
+			entry, ok := byAddress[string(pubkeyHash)]
+			if !ok {
+				entry = &HistoryEntry{TxID: tx.ID, Height: height}
+				byAddress[string(pubkeyHash)] = entry
+				order = append(order, pubkeyHash)
+			}
+			return entry
+		}
+
+		if !tx.IsCoinbase() {
+			for range tx.Inputs {
+				utxo := spent[pos].Utxo
+				pos++
+				get(utxo.PubkeyHash).Sent += utxo.Value
+			}
+		}
+		for _, txo := range tx.Outputs {
+			entry := get(txo.PubkeyHash)
+			if entry.Sent > 0 {
+				entry.Change += txo.Value
+			} else {
+				entry.Received += txo.Value
+			}
+		}
+
+		for _, pubkeyHash := range order {
+			entries = append(entries, addressEntry{pubkeyHash, byAddress[string(pubkeyHash)]})
+		}
+	}
+	return entries
+}
+
+// 将区块内的交易加入地址索引。
+func indexBlockAddresses(t *bolt.Tx, b *block.Block, height int, spent []spentOutput) error {
+	bucket := t.Bucket([]byte(addrIndexBucket))
+	for _, ae := range addressEntries(b, height, spent) {
+		addrBucket, err := bucket.CreateBucketIfNotExists(ae.pubkeyHash)
+		if err != nil {
+			return err
+		}
+		err = addrBucket.Put(historyKey(height, ae.entry.TxID), ae.entry.serialize())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从地址索引中移除区块内的交易。
+func unindexBlockAddresses(t *bolt.Tx, b *block.Block, height int, spent []spentOutput) error {
+	bucket := t.Bucket([]byte(addrIndexBucket))
+	for _, ae := range addressEntries(b, height, spent) {
+		addrBucket := bucket.Bucket(ae.pubkeyHash)
+		if addrBucket == nil {
+			continue
+		}
+		err := addrBucket.Delete(historyKey(height, ae.entry.TxID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从创世块开始重放区块链，重建地址索引。
+func rebuildAddressIndex(t *bolt.Tx, tip []byte) error {
+	err := t.DeleteBucket([]byte(addrIndexBucket))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	_, err = t.CreateBucket([]byte(addrIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	view := newReplayView()
+	for height, b := range mainChainBlocks(t, tip) {
+		var spent []spentOutput
+		for _, tx := range b.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, txi := range tx.Inputs {
+				spent = append(spent, spentOutput{txi.RefID, txi.RefIndex, view.unspent(txi.RefID, txi.RefIndex)})
+			}
+		}
+
+		err = indexBlockAddresses(t, b, height, spent)
+		if err != nil {
+			return err
+		}
+		view.apply(b, height)
+	}
+	return nil
+}
+
+// 获取地址的交易记录，按从新到旧排列，跳过最新的 offset 条，最多返回 limit 条。
+// 同时返回该地址的交易记录总数。
+func (c *Chain) History(address string, offset int, limit int) ([]*HistoryEntry, int) {
+	pubkeyHash := utils.Base58Decode([]byte(address))
+	pubkeyHash = pubkeyHash[1 : len(pubkeyHash)-utils.ChecksumLen]
+
+	var (
+		entries []*HistoryEntry
+		total   int
+	)
+	err := c.db.View(func(t *bolt.Tx) error {
+		addrBucket := t.Bucket([]byte(addrIndexBucket)).Bucket(pubkeyHash)
+		if addrBucket == nil {
+			return nil
+		}
+		total = addrBucket.Stats().KeyN
+
+		cursor := addrBucket.Cursor()
+		skipped := 0
+		for key, value := cursor.Last(); key != nil && len(entries) < limit; key, value = cursor.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			entries = append(entries, deserializeHistoryEntry(value))
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return entries, total
+}
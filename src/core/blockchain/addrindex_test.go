@@ -0,0 +1,45 @@
+package blockchain
+
+import (
+	"blockchain/core/wallet"
+	"blockchain/utils"
+	"testing"
+)
+
+// 地址索引记录所有地址的交易，包括不在钱包中的地址，导入私钥后无需重新扫描。
+func TestHistoryOfAddressOutsideWallet(t *testing.T) {
+	chain, alice, _ := newFundedChain(t)
+
+	outside := wallet.AddressOf(utils.GetPubkeyHash([]byte("a public key outside the wallet")))
+	if err := chain.SubmitTx(chain.NewUtxoTx(alice, outside, 3, 1, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, alice, 1)
+
+	entries, total := chain.History(outside, 0, 10)
+	if total != 1 || len(entries) != 1 {
+		t.Fatalf("%d transactions, %d entries, want 1", total, len(entries))
+	}
+	entry := entries[0]
+	if entry.Height != chain.Height() || entry.Received != 3 || entry.Sent != 0 || entry.Net() != 3 {
+		t.Errorf("entry %+v, want 3 received at height %d", entry, chain.Height())
+	}
+	if balance := chain.GetBalance(outside); balance != 3 {
+		t.Errorf("balance %d, want 3", balance)
+	}
+
+	// 出资方的记录：消费的输出减去找零，等于转出金额加手续费。
+	aliceEntries, _ := chain.History(alice, 0, 2)
+	var paid *HistoryEntry
+	for _, e := range aliceEntries {
+		if string(e.TxID) == string(entry.TxID) {
+			paid = e
+		}
+	}
+	if paid == nil {
+		t.Fatal("payment is missing from the payer's history")
+	}
+	if paid.Net() != -4 || paid.Received != 0 {
+		t.Errorf("payer entry %+v, want net -4", paid)
+	}
+}
@@ -3,17 +3,29 @@ package blockchain
 import (
 	"blockchain/core/block"
 	"blockchain/core/transaction"
-	"blockchain/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
 
 // 数据库。
-const chainDbPath = "blockchain.db"
 const blocksBucket = "blocks"
 const lastHashKey = "l"
 
+// 打开数据库时等待文件锁的最长时间。
+const dbOpenTimeout = 3 * time.Second
+
+// 区块尚未被本地区块链收录的父区块。
+var ErrOrphanBlock = errors.New("previous block is unknown")
+
+// 区块已经被本地区块链收录。
+var ErrKnownBlock = errors.New("block is already known")
+
 // 创世块 coinbase 内含数据。
 const genesisCoinbase = "Genesis Coinbase"
 
@@ -23,32 +35,34 @@ type Chain struct {
 	db   *bolt.DB // 数据库连接。
 }
 
-// 创建区块链。
-func NewChain(address string) *Chain {
+// 在 path 处创建区块链数据库，创世块奖励给 address。
+func NewChain(path string, address string) *Chain {
 	// 如果数据库已经存在，就报错退出。
-	if !chainDbNotExists() {
+	if !chainDbNotExists(path) {
 		panic("blockchain already exists")
 	}
 
 	// 打开数据库。
-	db, err := bolt.Open(chainDbPath, 0600, nil)
-	if err != nil {
-		panic(err)
-	}
+	db := openChainDb(path)
 
 	// 创建 coinbase 交易和相应的创世块。
-	coinbaseTx := NewCoinbaseTx(address, genesisCoinbase)
+	coinbaseTx := NewCoinbaseTx(address, genesisCoinbase, 0)
 	genesisBlock := block.NewGenesisBlock(coinbaseTx)
 	rear := genesisBlock.Hash
 
 	// 将创世块录入新 bucket。
-	err = db.Update(func(t *bolt.Tx) error {
+	err := db.Update(func(t *bolt.Tx) error {
 		bucket, err := t.CreateBucket([]byte(blocksBucket))
 		if err != nil {
 			return err
 		}
 
-		err = bucket.Put(genesisBlock.Hash, genesisBlock.Serialize())
+		_, err = t.CreateBucket([]byte(headersBucket))
+		if err != nil {
+			return err
+		}
+
+		err = putBlock(t, genesisBlock)
 		if err != nil {
 			return err
 		}
@@ -58,7 +72,51 @@ func NewChain(address string) *Chain {
 			return err
 		}
 
-		return nil
+		_, err = t.CreateBucket([]byte(mempoolBucket))
+		if err != nil {
+			return err
+		}
+
+		utxoBucket, err := t.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+
+		err = addTxOutputs(utxoBucket, coinbaseTx, 0)
+		if err != nil {
+			return err
+		}
+
+		_, err = t.CreateBucket([]byte(undoBucket))
+		if err != nil {
+			return err
+		}
+
+		heightsBucket, err := t.CreateBucket([]byte(heightsBucket))
+		if err != nil {
+			return err
+		}
+
+		err = heightsBucket.Put(heightKey(0), genesisBlock.Hash)
+		if err != nil {
+			return err
+		}
+
+		_, err = t.CreateBucket([]byte(addrIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		err = indexBlockAddresses(t, genesisBlock, 0, nil)
+		if err != nil {
+			return err
+		}
+
+		workBucket, err := t.CreateBucket([]byte(workBucket))
+		if err != nil {
+			return err
+		}
+		return workBucket.Put(genesisBlock.Hash, blockWork(genesisBlock.Target).Bytes())
 	})
 	if err != nil {
 		panic(err)
@@ -66,78 +124,270 @@ func NewChain(address string) *Chain {
 	return &Chain{rear, db}
 }
 
-// 读取区块链。
-func LoadChain() *Chain {
+// 读取 path 处的区块链数据库。
+func LoadChain(path string) *Chain {
 	// 如果数据库不存在，就报错退出。
-	if chainDbNotExists() {
+	if chainDbNotExists(path) {
 		panic("blockchain not found")
 	}
 
 	// 打开数据库。
-	db, err := bolt.Open(chainDbPath, 0600, nil)
-	if err != nil {
-		panic(err)
-	}
+	db := openChainDb(path)
 
-	// 从数据库读取目前的区块链信息。
-	var rear []byte
-	err = db.Update(func(t *bolt.Tx) error {
+	// 从数据库读取目前的区块链信息，并补建升级前的数据库缺少的 bucket。
+	var (
+		rear    []byte
+		migrate bool
+	)
+	err := db.Update(func(t *bolt.Tx) error {
 		bucket := t.Bucket([]byte(blocksBucket))
-		rear = bucket.Get([]byte(lastHashKey))
-		return nil
+		// bolt 返回的值只在事务内有效，需要复制出来。
+		rear = append([]byte{}, bucket.Get([]byte(lastHashKey))...)
+
+		for _, name := range []string{mempoolBucket, workBucket, undoBucket, heightsBucket} {
+			_, err := t.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		migrate = t.Bucket([]byte(utxoBucket)) == nil
+		if t.Bucket([]byte(headersBucket)) == nil {
+			err := indexHeaders(t)
+			if err != nil {
+				return err
+			}
+		}
+		chainWork(t, getBlock(t, rear))
+		if t.Bucket([]byte(addrIndexBucket)) == nil {
+			err := rebuildAddressIndex(t, rear)
+			if err != nil {
+				return err
+			}
+		}
+		return indexHeights(t, rear)
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	return &Chain{rear, db}
+	// 未消费输出集的格式已经改变，需要重放区块链重建。
+	chain := &Chain{rear, db}
+	if migrate {
+		chain.Reindex()
+	}
+	return chain
 }
 
 // 向区块链添加区块。
 func (c *Chain) AddBlock(txs []*transaction.Transaction) {
-	// 验证每笔交易。
-	for _, tx := range txs {
-		if !c.VerifyTx(tx) {
-			panic("invalid transaction")
+	err := c.AddBlockWithContext(context.Background(), txs)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// 向区块链添加区块，挖矿过程可通过 ctx 中止。
+// 挖矿被中止时区块链保持不变，并返回 ctx 的错误。
+func (c *Chain) AddBlockWithContext(ctx context.Context, txs []*transaction.Transaction) error {
+	newBlock, err := c.NewBlockTemplate(txs)
+	if err != nil {
+		return err
+	}
+
+	// 证明新区块的工作量。
+	err = newBlock.MineAndReport(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.AcceptBlock(newBlock)
+}
+
+// 创建接在区块链尾部、尚未挖矿的区块模板。
+// 挖矿期间无需访问区块链；挖出后用 AcceptBlock 收录，期间尾部若已变化，区块会成为分支。
+func (c *Chain) NewBlockTemplate(txs []*transaction.Transaction) (*block.Block, error) {
+	// 按共识规则验证区块内的交易。
+	if txID, reason := checkBlockTxs(txs, &chainView{c}); reason != "" {
+		return nil, fmt.Errorf("invalid transaction %x: %s", txID, reason)
+	}
+
+	// 计算新区块应当满足的目标值。
+	target := c.nextTarget()
+	template := block.NewBlockTemplate(txs, c.rear, c.Height()+1, target)
+
+	// 时间戳须晚于尾部区块的过去中位时间，同一秒内连续出块时向后推。
+	if median := (&chainView{c}).medianTimePast(c.Height()); template.Timestamp <= median {
+		template.Timestamp = median + 1
+	}
+	return template, nil
+}
+
+// 接收区块，校验通过后录入数据库。
+// 区块可以接在任意已知区块之后：接在尾部时直接上链；位于分支上时先保存，
+// 一旦分支的累计工作量超过当前区块链，就回滚到分叉点并切换到该分支。
+// 父区块未知时返回 ErrOrphanBlock，区块已收录时返回 ErrKnownBlock。
+func (c *Chain) AcceptBlock(b *block.Block) error {
+	var detached, attached []*block.Block
+	err := c.db.Update(func(t *bolt.Tx) error {
+		bucket := t.Bucket([]byte(blocksBucket))
+		if getBlock(t, b.Hash) != nil {
+			return ErrKnownBlock
+		}
+		parent := getBlock(t, b.PrevBlockHash)
+		if parent == nil {
+			return ErrOrphanBlock
+		}
+
+		// 检查高度、目标值、工作量证明和 Merkle 树。
+		if height := heightOf(t, parent) + 1; b.Height != height {
+			return fmt.Errorf("block %x has height %d, expected %d", b.Hash, b.Height, height)
+		}
+		if target := targetAfter(t, parent); !bytes.Equal(b.Target, target) {
+			return fmt.Errorf("block %x has target %x, expected %x", b.Hash, b.Target, target)
+		}
+		if reason := checkHeaderRules(&b.Header, parent.Version, blockMedianTime(t, parent.Hash), time.Now().Unix()); reason != "" {
+			return fmt.Errorf("block %x: %s", b.Hash, reason)
+		}
+		if !b.VerifyWork() {
+			return fmt.Errorf("block %x has invalid proof of work", b.Hash)
+		}
+		if b.HasMutatedMerkle() {
+			return fmt.Errorf("block %x has duplicated transactions in its merkle tree", b.Hash)
+		}
+
+		// 录入区块及其累计工作量。
+		err := putBlock(t, b)
+		if err != nil {
+			return err
+		}
+		work := chainWork(t, b)
+
+		// 区块接在尾部时直接连接，否则比较两条分支的累计工作量。
+		tip := getBlock(t, bucket.Get([]byte(lastHashKey)))
+		if bytes.Equal(b.PrevBlockHash, tip.Hash) {
+			err = connectBlock(t, b)
+			if err != nil {
+				return err
+			}
+			attached = []*block.Block{b}
+		} else if work.Cmp(chainWork(t, tip)) == 1 {
+			detached, attached, err = reorganize(t, tip, b)
+			if err != nil {
+				return err
+			}
+		} else {
+			return nil
+		}
+
+		return bucket.Put([]byte(lastHashKey), b.Hash)
+	})
+	if err != nil {
+		return err
+	}
+	if attached == nil {
+		return nil
+	}
+	c.rear = b.Hash
+
+	// 更新交易池：移出已被新分支打包的交易，再把被回滚的交易放回交易池。
+	for _, attachedBlock := range attached {
+		c.pruneMempool(attachedBlock.Transactions)
+	}
+	for _, detachedBlock := range detached {
+		for _, tx := range detachedBlock.Transactions {
+			if !tx.IsCoinbase() {
+				c.SubmitTx(tx)
+			}
 		}
 	}
+	return nil
+}
+
+// 获取区块链尾部区块的哈希值。
+func (c *Chain) Tip() []byte {
+	return c.rear
+}
 
-	// 从数据库获取最后一个区块的哈希值。
-	var lastHash []byte
+// 判断区块是否已经被收录。
+func (c *Chain) HasBlock(hash []byte) bool {
+	found := false
 	err := c.db.View(func(t *bolt.Tx) error {
-		bucket := t.Bucket([]byte(blocksBucket))
-		lastHash = bucket.Get([]byte(lastHashKey))
+		found = len(hash) > 0 && t.Bucket([]byte(blocksBucket)).Get(hash) != nil
 		return nil
 	})
 	if err != nil {
 		panic(err)
 	}
+	return found
+}
 
-	// 创建新区块。
-	newBlock := block.NewBlock(txs, lastHash)
-	c.rear = newBlock.Hash
-
-	// 将区块录入 bucket。
-	err = c.db.Update(func(t *bolt.Tx) error {
-		bucket := t.Bucket([]byte(blocksBucket))
+// 凭哈希值获取区块。
+func (c *Chain) BlockByHash(hash []byte) (*block.Block, error) {
+	var b *block.Block
+	err := c.db.View(func(t *bolt.Tx) error {
+		b = getBlock(t, hash)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if b == nil {
+		return nil, fmt.Errorf("block %x not found", hash)
+	}
+	return b, nil
+}
 
-		err := bucket.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			return err
+// 生成区块定位器：从尾部开始的一组区块哈希值，越往前间隔越大，最后一个是创世块。只读取区块头。
+// 对方据此找到双方区块链的分叉点。
+func (c *Chain) Locator() [][]byte {
+	var hashes [][]byte
+	err := c.db.View(func(t *bolt.Tx) error {
+		for hash := c.rear; len(hash) != 0; hash = getHeader(t, hash).PrevBlockHash {
+			hashes = append(hashes, hash)
 		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
 
-		err = bucket.Put([]byte(lastHashKey), newBlock.Hash)
-		if err != nil {
-			return err
+	var locator [][]byte
+	step := 1
+	for i := 0; i < len(hashes)-1; i += step {
+		locator = append(locator, hashes[i])
+		if len(locator) >= 10 {
+			step *= 2
 		}
+	}
+	return append(locator, hashes[len(hashes)-1])
+}
 
+// 获取区块链上位于区块定位器中第一个已知区块之后的区块哈希值，按从旧到新排列，最多 limit 个。
+// 定位器中的区块都不在区块链上时，从创世块开始返回。
+func (c *Chain) HashesAfter(locator [][]byte, limit int) [][]byte {
+	known := make(map[string]bool)
+	for _, hash := range locator {
+		known[string(hash)] = true
+	}
+
+	var hashes [][]byte
+	err := c.db.View(func(t *bolt.Tx) error {
+		for hash := c.rear; len(hash) != 0 && !known[string(hash)]; hash = getHeader(t, hash).PrevBlockHash {
+			hashes = append(hashes, hash)
+		}
 		return nil
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	c.Update(newBlock)
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	if len(hashes) > limit {
+		hashes = hashes[:limit]
+	}
+	return hashes
 }
 
 // 关闭区块链的数据库连接。
@@ -160,11 +410,10 @@ func (c *Chain) Print() {
 // 获得区块链属于某地址的余额。
 func (c *Chain) GetBalance(address string) int {
 	// 获得地址内蕴含的公钥哈希。
-	pubkeyHash := utils.Base58Decode([]byte(address))
-	pubkeyHash = pubkeyHash[1 : len(pubkeyHash)-4]
+	pubkeyHash, scriptHash := transaction.ParseAddress(address)
 
 	// 使用该公钥哈希，寻找每一笔未消费的交易输出。
-	UTXOs := c.FindPayableUtxos(pubkeyHash)
+	UTXOs := c.FindPayableUtxos(pubkeyHash, scriptHash)
 
 	// 累加这些输出内的余额。
 	balance := 0
@@ -175,8 +424,35 @@ func (c *Chain) GetBalance(address string) int {
 	return balance
 }
 
-// 判断区块链数据库是否存在。
-func chainDbNotExists() bool {
-	_, err := os.Stat(chainDbPath)
+// 获取区块链数据库的默认路径。
+// 设置了环境变量 NODE_ID 时，每个节点使用各自的数据库，便于在同一台机器上运行多个节点。
+func DbPath() string {
+	nodeID := os.Getenv("NODE_ID")
+	if nodeID == "" {
+		return "blockchain.db"
+	}
+	return fmt.Sprintf("blockchain_%s.db", nodeID)
+}
+
+// 打开区块链数据库。数据库被其他进程占用时报错退出。
+func openChainDb(path string) *bolt.DB {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: dbOpenTimeout})
+	if err == bolt.ErrTimeout {
+		panic("blockchain database is in use by another process")
+	}
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// 判断 path 处的区块链数据库是否存在。
+func ChainExists(path string) bool {
+	return !chainDbNotExists(path)
+}
+
+// 判断区块链数据库是否不存在。
+func chainDbNotExists(path string) bool {
+	_, err := os.Stat(path)
 	return os.IsNotExist(err)
 }
@@ -0,0 +1,48 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"blockchain/core/transaction"
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// 在区块链上查找最早一笔携带指定数据的交易，返回交易、它的位置及所在区块。
+// 数据输出不进入任何索引，因此从尾部遍历整条区块链。
+func (c *Chain) FindData(data []byte) (*transaction.Transaction, *TxLocation, *block.Block, error) {
+	var (
+		tx    *transaction.Transaction
+		loc   *TxLocation
+		found *block.Block
+	)
+	err := c.db.View(func(t *bolt.Tx) error {
+		for b := getBlock(t, c.rear); b != nil; b = getBlock(t, b.PrevBlockHash) {
+			for pos, curTx := range b.Transactions {
+				if carriesData(curTx, data) {
+					tx, loc, found = curTx, &TxLocation{b.Hash, heightOf(t, b), pos}, b
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if tx == nil {
+		return nil, nil, nil, fmt.Errorf("no transaction carries data %x", data)
+	}
+	return tx, loc, found, nil
+}
+
+// 判断交易是否有携带指定数据的数据输出。
+func carriesData(tx *transaction.Transaction, data []byte) bool {
+	for _, txo := range tx.Outputs {
+		if carried, ok := txo.Data(); ok && bytes.Equal(carried, data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// 区块时间戳最多超前本地时间的秒数。
+const maxFutureBlockTime = 2 * 60 * 60
+
+// 从该区块头版本起，时间戳须晚于父区块的过去中位时间。
+// 之前版本的区块常在同一秒内连续挖出，已有的区块链仍然有效。
+const medianTimeVersion = 2
+
+// 计算下一个区块应当满足的目标值。
+func (c *Chain) nextTarget() []byte {
+	var target []byte
+	err := c.db.View(func(t *bolt.Tx) error {
+		target = targetAfter(t, getBlock(t, c.rear))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return target
+}
+
+// 判断指定高度的区块是否需要调整难度。
+func isRetargetHeight(height int) bool {
+	return height%block.RetargetInterval == 0
+}
+
+// 校验区块头的版本与时间戳，返回出错原因；有效时为空。
+// 版本不低于父区块，也不高于 block.HeaderVersion；时间戳不超过本地时间 now 之后 maxFutureBlockTime 秒，
+// 并且晚于父区块的过去中位时间 parentMedianTime，否则矿工可以把时间戳往回拨，压低难度或提前满足锁定时间。
+// 版本低于 medianTimeVersion 的区块不检查过去中位时间；版本不能降低，新区块因此无法绕过这一检查。
+func checkHeaderRules(h *block.Header, parentVersion int, parentMedianTime int64, now int64) string {
+	if h.Version < parentVersion || h.Version > block.HeaderVersion {
+		return fmt.Sprintf("header version %d is outside [%d, %d]", h.Version, parentVersion, block.HeaderVersion)
+	}
+	if h.Timestamp > now+maxFutureBlockTime {
+		return fmt.Sprintf("timestamp %d is more than %d seconds ahead of local time %d", h.Timestamp, maxFutureBlockTime, now)
+	}
+	if h.Version >= medianTimeVersion && h.Timestamp <= parentMedianTime {
+		return fmt.Sprintf("timestamp %d is not after median time past %d", h.Timestamp, parentMedianTime)
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"fmt"
+	"sort"
+)
+
+// 计算交易的交易费，即输入总额与输出总额之差。
+// 交易的输入必须引用尚未消费的输出。
+func (c *Chain) TxFee(tx *transaction.Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	view := &chainView{c}
+	inputSum := 0
+	for _, txi := range tx.Inputs {
+		utxo := view.unspent(txi.RefID, txi.RefIndex)
+		if utxo == nil {
+			return 0, fmt.Errorf("output %s does not exist or is already spent", outpointKey(txi.RefID, txi.RefIndex))
+		}
+		var err error
+		inputSum, err = transaction.AddMoney(inputSum, utxo.Value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	outputSum, err := sumOutputs(tx)
+	if err != nil {
+		return 0, err
+	}
+	return inputSum - outputSum, nil
+}
+
+// 组装新区块的交易列表。
+// 待打包交易按费率（交易费 / 字节数）从高到低排列，并在最前面加入支付给矿工的 coinbase 交易，
+// 其奖励为出块奖励加上全部交易费。
+func (c *Chain) AssembleBlock(miner string, pending []*transaction.Transaction) []*transaction.Transaction {
+	type candidate struct {
+		tx   *transaction.Transaction
+		fee  int
+		size int
+	}
+
+	candidates := make([]candidate, 0, len(pending))
+	totalFee := 0
+	for _, tx := range pending {
+		fee, err := c.TxFee(tx)
+		if err != nil {
+			panic(err)
+		}
+		candidates = append(candidates, candidate{tx, fee, tx.Size()})
+		totalFee, err = transaction.AddMoney(totalFee, fee)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// 交叉相乘比较费率，避免浮点误差。
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].fee*candidates[j].size > candidates[j].fee*candidates[i].size
+	})
+
+	txs := []*transaction.Transaction{NewCoinbaseTx(miner, "", totalFee)}
+	for _, candidate := range candidates {
+		txs = append(txs, candidate.tx)
+	}
+	return txs
+}
@@ -0,0 +1,355 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"blockchain/core/transaction"
+	"blockchain/utils"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// 数据库。
+const workBucket = "work" // 区块哈希值 - 从创世块到该区块的累计工作量。
+const undoBucket = "undo" // 区块哈希值 - 区块消费的输出，用于回滚。
+
+// 被消费的交易输出，回滚区块时据此恢复未消费输出集。
+type spentOutput struct {
+	RefID    []byte     // 输出所在交易的 ID。
+	RefIndex int        // 输出在交易中的索引。
+	Utxo     *utxoEntry // 输出被消费前的记录。
+}
+
+// 序列化区块的回滚数据。
+func serializeUndo(spent []spentOutput) []byte {
+	var seq bytes.Buffer
+
+	encoder := gob.NewEncoder(&seq)
+	err := encoder.Encode(spent)
+	if err != nil {
+		panic(err)
+	}
+
+	return seq.Bytes()
+}
+
+// 反序列化区块的回滚数据。
+func deserializeUndo(seq []byte) []spentOutput {
+	var spent []spentOutput
+
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	err := decoder.Decode(&spent)
+	if err != nil {
+		panic(err)
+	}
+
+	return spent
+}
+
+// 计算满足目标值的区块代表的工作量，即 2^256 / (目标值 + 1)。
+func blockWork(target []byte) *big.Int {
+	denominator := utils.BytesToBigInt(target)
+	denominator.Add(denominator, big.NewInt(1))
+	work := big.NewInt(0).Lsh(big.NewInt(1), 256)
+	return work.Div(work, denominator)
+}
+
+// 在数据库事务内凭哈希值获取区块，不存在时返回 nil。
+func getBlock(t *bolt.Tx, hash []byte) *block.Block {
+	if len(hash) == 0 {
+		return nil
+	}
+	seq := t.Bucket([]byte(blocksBucket)).Get(hash)
+	if seq == nil {
+		return nil
+	}
+	return block.DeserializeBlock(seq)
+}
+
+// 计算父区块之后的区块应当满足的目标值。
+// 每隔 block.RetargetInterval 个区块，根据上一窗口首尾区块的时间戳调整一次；
+// 其余时候沿用父区块的目标值。
+func targetAfter(t *bolt.Tx, parent *block.Block) []byte {
+	if !isRetargetHeight(heightOf(t, parent) + 1) {
+		return parent.Target
+	}
+
+	// 回溯到窗口内的第一个区块，只读取区块头。
+	first := &parent.Header
+	for i := 1; i < block.RetargetInterval; i++ {
+		first = getHeader(t, first.PrevBlockHash)
+	}
+
+	return block.NextTarget(parent.Target, first.Timestamp, parent.Timestamp)
+}
+
+// 获取从创世块到指定区块的累计工作量。
+// 升级前录入的区块没有记录，此时沿父区块回溯计算，并在可写事务中补录。
+func chainWork(t *bolt.Tx, b *block.Block) *big.Int {
+	bucket := t.Bucket([]byte(workBucket))
+	if value := bucket.Get(b.Hash); value != nil {
+		return utils.BytesToBigInt(value)
+	}
+
+	work := blockWork(b.Target)
+	if parent := getBlock(t, b.PrevBlockHash); parent != nil {
+		work.Add(work, chainWork(t, parent))
+	}
+	if t.Writable() {
+		err := bucket.Put(b.Hash, work.Bytes())
+		if err != nil {
+			panic(err)
+		}
+	}
+	return work
+}
+
+// 基于数据库事务的交易输出视图，用于在同一事务内校验分支上的区块。
+type boltView struct {
+	t    *bolt.Tx
+	prev []byte // 被校验区块的父区块哈希值，被引用的交易从它开始向前查找。
+}
+
+// 获取未消费的交易输出。
+func (v *boltView) unspent(refID []byte, refIndex int) *utxoEntry {
+	return getUtxo(v.t.Bucket([]byte(utxoBucket)), refID, refIndex)
+}
+
+// 判断交易是否还有未消费的输出。
+func (v *boltView) hasUnspent(txID []byte) bool {
+	return hasUtxos(v.t.Bucket([]byte(utxoBucket)), txID)
+}
+
+// 获取被引用的交易。
+// 被校验区块接在未消费输出集对应的尾部之后，被引用的交易有未消费输出时就在这条链上，
+// 直接定位所在区块；定位不到时才沿父区块向前逐个查找。
+func (v *boltView) refTx(refID []byte) *transaction.Transaction {
+	if tx := unspentTx(v.t, refID); tx != nil {
+		return tx
+	}
+	tx, _ := findAncestorTx(v.t, v.prev, refID)
+	return tx
+}
+
+// 获取被校验区块的高度。
+func (v *boltView) spendHeight() int {
+	return heightOf(v.t, getBlock(v.t, v.prev)) + 1
+}
+
+// 获取被校验区块所在分支上指定高度处区块的过去中位时间。
+func (v *boltView) medianTimePast(height int) int64 {
+	return ancestorMedianTime(v.t, v.prev, v.spendHeight()-1-height)
+}
+
+// 获取还有未消费输出的交易，找不到时返回 nil。
+// 启用了交易索引时直接查找；否则凭任一未消费输出记录的高度，在高度索引中找到所在区块。
+func unspentTx(t *bolt.Tx, txID []byte) *transaction.Transaction {
+	if bucket := t.Bucket([]byte(txIndexBucket)); bucket != nil {
+		if seq := bucket.Get(txID); seq != nil {
+			loc := deserializeTxLocation(seq)
+			if b := getBlock(t, loc.BlockHash); b != nil && loc.Position < len(b.Transactions) {
+				return b.Transactions[loc.Position]
+			}
+		}
+	}
+
+	key, value := t.Bucket([]byte(utxoBucket)).Cursor().Seek(txID)
+	if key == nil || len(key) != len(txID)+8 || !bytes.HasPrefix(key, txID) {
+		return nil
+	}
+	entry := deserializeUtxoEntry(value)
+	b := getBlock(t, t.Bucket([]byte(heightsBucket)).Get(heightKey(entry.Height)))
+	if b == nil {
+		return nil
+	}
+	for _, tx := range b.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			return tx
+		}
+	}
+	return nil
+}
+
+// 从指定区块开始向前查找交易，返回交易及其所在区块；不存在时均为 nil。
+func findAncestorTx(t *bolt.Tx, from []byte, txID []byte) (*transaction.Transaction, *block.Block) {
+	for b := getBlock(t, from); b != nil; b = getBlock(t, b.PrevBlockHash) {
+		for _, tx := range b.Transactions {
+			if bytes.Equal(tx.ID, txID) {
+				return tx, b
+			}
+		}
+	}
+	return nil, nil
+}
+
+// 将区块连接到未消费输出集上：校验区块内的交易，消费输入、加入输出，并记录回滚数据。
+// 区块的父区块必须是未消费输出集当前对应的区块链尾部。
+func connectBlock(t *bolt.Tx, b *block.Block) error {
+	if txID, reason := checkBlockTxs(b.Transactions, &boltView{t, b.PrevBlockHash}); reason != "" {
+		return fmt.Errorf("invalid transaction %x: %s", txID, reason)
+	}
+
+	height := heightOf(t, b)
+	bucket := t.Bucket([]byte(utxoBucket))
+	var spent []spentOutput
+	for _, tx := range b.Transactions {
+		if !tx.IsCoinbase() {
+			for _, txi := range tx.Inputs {
+				entry, err := spendUtxo(bucket, txi.RefID, txi.RefIndex)
+				if err != nil {
+					return err
+				}
+				spent = append(spent, spentOutput{txi.RefID, txi.RefIndex, entry})
+			}
+		}
+
+		err := addTxOutputs(bucket, tx, height)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := t.Bucket([]byte(heightsBucket)).Put(heightKey(height), b.Hash)
+	if err != nil {
+		return err
+	}
+	err = indexBlockTxs(t, b, height)
+	if err != nil {
+		return err
+	}
+	err = indexBlockAddresses(t, b, height, spent)
+	if err != nil {
+		return err
+	}
+	return t.Bucket([]byte(undoBucket)).Put(b.Hash, serializeUndo(spent))
+}
+
+// 将区块从未消费输出集上断开：删除区块内交易的输出，并恢复它们消费的输出。
+// 区块必须是未消费输出集当前对应的区块链尾部。
+func disconnectBlock(t *bolt.Tx, b *block.Block) error {
+	bucket := t.Bucket([]byte(utxoBucket))
+	undo := t.Bucket([]byte(undoBucket))
+
+	spent, err := blockUndo(t, b)
+	if err != nil {
+		return err
+	}
+
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		err = removeTxOutputs(bucket, b.Transactions[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(spent) - 1; i >= 0; i-- {
+		key := utxoKey(spent[i].RefID, spent[i].RefIndex)
+		if bucket.Get(key) != nil {
+			return fmt.Errorf("output %s is already unspent", outpointKey(spent[i].RefID, spent[i].RefIndex))
+		}
+		err = bucket.Put(key, spent[i].Utxo.serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	height := heightOf(t, b)
+	err = t.Bucket([]byte(heightsBucket)).Delete(heightKey(height))
+	if err != nil {
+		return err
+	}
+	err = unindexBlockTxs(t, b)
+	if err != nil {
+		return err
+	}
+	err = unindexBlockAddresses(t, b, height, spent)
+	if err != nil {
+		return err
+	}
+	return undo.Delete(b.Hash)
+}
+
+// 获取区块的回滚数据。
+// 升级前录入的区块没有回滚数据，或记录中缺少输出的高度等信息，此时从祖先区块中找回被消费的输出。
+func blockUndo(t *bolt.Tx, b *block.Block) ([]spentOutput, error) {
+	if seq := t.Bucket([]byte(undoBucket)).Get(b.Hash); seq != nil {
+		spent := deserializeUndo(seq)
+		complete := true
+		for _, so := range spent {
+			complete = complete && so.Utxo != nil
+		}
+		if complete {
+			return spent, nil
+		}
+	}
+
+	var spent []spentOutput
+	for _, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, txi := range tx.Inputs {
+			refTx, refBlock := findAncestorTx(t, b.PrevBlockHash, txi.RefID)
+			if refTx == nil || txi.RefIndex < 0 || txi.RefIndex >= len(refTx.Outputs) {
+				return nil, fmt.Errorf("cannot find output %s spent by block %x", outpointKey(txi.RefID, txi.RefIndex), b.Hash)
+			}
+			entry := newUtxoEntry(refTx.Outputs[txi.RefIndex], heightOf(t, refBlock), refTx.IsCoinbase())
+			spent = append(spent, spentOutput{txi.RefID, txi.RefIndex, entry})
+		}
+	}
+	return spent, nil
+}
+
+// 将区块链尾部切换到指定区块：从当前尾部断开区块直到分叉点，再依次连接新分支上的区块。
+// 返回被断开和被连接的区块，均按从旧到新排列。任一区块连接失败时返回错误，由调用方回滚事务。
+func reorganize(t *bolt.Tx, oldTip *block.Block, newTip *block.Block) ([]*block.Block, []*block.Block, error) {
+	// 两个分支回溯到相同高度后同步后退，找到分叉点。
+	oldHeight, newHeight := heightOf(t, oldTip), heightOf(t, newTip)
+	var detached, attached []*block.Block
+	for oldHeight > newHeight {
+		detached = append(detached, oldTip)
+		oldTip = getBlock(t, oldTip.PrevBlockHash)
+		oldHeight--
+	}
+	for newHeight > oldHeight {
+		attached = append(attached, newTip)
+		newTip = getBlock(t, newTip.PrevBlockHash)
+		newHeight--
+	}
+	for oldTip != nil && newTip != nil && !bytes.Equal(oldTip.Hash, newTip.Hash) {
+		detached = append(detached, oldTip)
+		attached = append(attached, newTip)
+		oldTip = getBlock(t, oldTip.PrevBlockHash)
+		newTip = getBlock(t, newTip.PrevBlockHash)
+	}
+	if oldTip == nil || newTip == nil {
+		return nil, nil, errors.New("branches do not share a common ancestor")
+	}
+
+	for _, b := range detached {
+		err := disconnectBlock(t, b)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	reverseBlocks(detached)
+	reverseBlocks(attached)
+	for _, b := range attached {
+		err := connectBlock(t, b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("block %x: %s", b.Hash, err)
+		}
+	}
+
+	return detached, attached, nil
+}
+
+// 将区块列表倒序。
+func reverseBlocks(blocks []*block.Block) {
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+}
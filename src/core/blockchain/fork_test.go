@@ -0,0 +1,192 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"blockchain/core/transaction"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 在指定的父区块之后挖出一个只有 coinbase 的区块并交给区块链，返回该区块。
+func mineOn(t *testing.T, c *Chain, parent []byte, miner string) *block.Block {
+	t.Helper()
+	var b *block.Block
+	err := c.db.View(func(t *bolt.Tx) error {
+		p := getBlock(t, parent)
+		txs := []*transaction.Transaction{NewCoinbaseTx(miner, "", 0)}
+		b = block.NewBlockTemplate(txs, parent, heightOf(t, p)+1, targetAfter(t, p))
+		if median := blockMedianTime(t, parent); b.Timestamp <= median {
+			b.Timestamp = median + 1
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Mine(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AcceptBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// 读出 bucket 及其子 bucket 内的全部键值对。
+func dumpBucket(t *testing.T, c *Chain, name string) map[string]string {
+	t.Helper()
+	dump := make(map[string]string)
+	var walk func(prefix string, bucket *bolt.Bucket) error
+	walk = func(prefix string, bucket *bolt.Bucket) error {
+		return bucket.ForEach(func(key, value []byte) error {
+			if value == nil {
+				return walk(prefix+string(key)+"/", bucket.Bucket(key))
+			}
+			dump[prefix+string(key)] = string(value)
+			return nil
+		})
+	}
+	err := c.db.View(func(t *bolt.Tx) error {
+		return walk("", t.Bucket([]byte(name)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dump
+}
+
+// 回滚和连接区块后的未消费输出集与地址索引，应当与从创世块重放得到的结果完全相同。
+func checkIndexesMatchReplay(t *testing.T, c *Chain) {
+	t.Helper()
+	utxos, history := dumpBucket(t, c, utxoBucket), dumpBucket(t, c, addrIndexBucket)
+	c.Reindex()
+	if replayed := dumpBucket(t, c, utxoBucket); !equalDumps(utxos, replayed) {
+		t.Errorf("utxo set has %d entries, %d after replaying the chain", len(utxos), len(replayed))
+	}
+	if replayed := dumpBucket(t, c, addrIndexBucket); !equalDumps(history, replayed) {
+		t.Errorf("address index has %d entries, %d after replaying the chain", len(history), len(replayed))
+	}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+// 比较两份键值对是否相同。
+func equalDumps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// 累计工作量更大的分支取代当前区块链：被断开区块内的交易回到交易池，被消费的输出按回滚数据恢复；
+// 原分支再次胜出时切换回来。
+func TestReorganizeByCumulativeWork(t *testing.T) {
+	chain, alice, bob := newFundedChain(t)
+
+	fork := chain.Tip()
+	payment := chain.NewUtxoTx(alice, bob, 3, 1, 0, 0)
+	if err := chain.SubmitTx(payment); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, alice, 1)
+	mainTip := chain.Tip()
+	if got := chain.GetBalance(bob); got != 3 {
+		t.Fatalf("bob has %d after the payment, want 3", got)
+	}
+
+	// 工作量相同的分支只被保存，不切换。
+	side1 := mineOn(t, chain, fork, bob)
+	if !bytes.Equal(chain.Tip(), mainTip) {
+		t.Fatal("switched to a branch with equal work")
+	}
+
+	// 分支更长时切换：付款交易所在的区块被断开，交易回到交易池。
+	side2 := mineOn(t, chain, side1.Hash, bob)
+	if !bytes.Equal(chain.Tip(), side2.Hash) {
+		t.Fatal("did not switch to the branch with more work")
+	}
+	if chain.Height() != side2.Height {
+		t.Errorf("height %d, want %d", chain.Height(), side2.Height)
+	}
+	if chain.PendingTx(payment.ID) == nil {
+		t.Error("payment from the detached block is not back in the mempool")
+	}
+	if _, err := chain.FindTx(payment.ID); err == nil {
+		t.Error("payment from the detached block is still found on the chain")
+	}
+	if got, want := chain.GetBalance(bob), 2*subsidy; got != want {
+		t.Errorf("bob has %d on the new branch, want %d", got, want)
+	}
+	checkIndexesMatchReplay(t, chain)
+
+	// 原分支重新变长时切换回来，付款交易再次上链并离开交易池。
+	main2 := mineOn(t, chain, mainTip, alice)
+	if !bytes.Equal(chain.Tip(), side2.Hash) {
+		t.Fatal("switched back to a branch with equal work")
+	}
+	main3 := mineOn(t, chain, main2.Hash, alice)
+	if !bytes.Equal(chain.Tip(), main3.Hash) {
+		t.Fatal("did not switch back to the original branch")
+	}
+	if chain.PendingTx(payment.ID) != nil {
+		t.Error("payment is still pending after its block was reconnected")
+	}
+	if _, err := chain.FindTx(payment.ID); err != nil {
+		t.Error(err)
+	}
+	if got := chain.GetBalance(bob); got != 3 {
+		t.Errorf("bob has %d back on the original branch, want 3", got)
+	}
+	checkIndexesMatchReplay(t, chain)
+}
+
+// 分支上的区块消费了无效输出时，切换失败，区块链保持在原尾部。
+func TestFailedReorganizeKeepsTip(t *testing.T) {
+	chain, alice, bob := newFundedChain(t)
+
+	fork := chain.Tip()
+	mineBlocks(t, chain, alice, 1)
+	mainTip := chain.Tip()
+	utxos := dumpBucket(t, chain, utxoBucket)
+
+	// 分支的第一个区块有效；第二个区块的交易输出超过输入，只有在切换时才被完整校验。
+	side1 := mineOn(t, chain, fork, bob)
+	tx := chain.NewUtxoTx(alice, bob, 3, 1, 0, 0)
+	tx.Outputs[0].Value = 1000
+	tx.ID = tx.ComputeID()
+	var b *block.Block
+	err := chain.db.View(func(t *bolt.Tx) error {
+		b = block.NewBlockTemplate([]*transaction.Transaction{NewCoinbaseTx(bob, "", 0), tx}, side1.Hash, side1.Height+1, targetAfter(t, side1))
+		b.Timestamp = blockMedianTime(t, side1.Hash) + 1
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Mine(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AcceptBlock(b); err == nil || !strings.Contains(err.Error(), "exceed") {
+		t.Fatalf("switching to a branch with an invalid block: %v", err)
+	}
+
+	if !bytes.Equal(chain.Tip(), mainTip) {
+		t.Error("tip moved after a failed reorganization")
+	}
+	if !equalDumps(utxos, dumpBucket(t, chain, utxoBucket)) {
+		t.Error("utxo set changed after a failed reorganization")
+	}
+	if err := chain.Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// 数据库。
+const headersBucket = "headers" // 区块哈希值 - 区块头。只需要区块头时无需解码整个区块。
+
+// 在数据库事务内凭哈希值获取区块头，不存在时返回 nil。
+func getHeader(t *bolt.Tx, hash []byte) *block.Header {
+	if len(hash) == 0 {
+		return nil
+	}
+	seq := t.Bucket([]byte(headersBucket)).Get(hash)
+	if seq == nil {
+		return nil
+	}
+	return block.DeserializeHeader(seq)
+}
+
+// 录入区块及其区块头。
+func putBlock(t *bolt.Tx, b *block.Block) error {
+	err := t.Bucket([]byte(blocksBucket)).Put(b.Hash, b.Serialize())
+	if err != nil {
+		return err
+	}
+	return t.Bucket([]byte(headersBucket)).Put(b.Hash, b.Header.Serialize())
+}
+
+// 为升级前的数据库补建区块头：逐一解码已录入的区块，包括分支上的区块。
+func indexHeaders(t *bolt.Tx) error {
+	bucket, err := t.CreateBucket([]byte(headersBucket))
+	if err != nil {
+		return err
+	}
+	return t.Bucket([]byte(blocksBucket)).ForEach(func(key, value []byte) error {
+		if string(key) == lastHashKey {
+			return nil
+		}
+		return bucket.Put(key, block.DeserializeBlock(value).Header.Serialize())
+	})
+}
+
+// 凭哈希值获取区块头，包括分支上的区块。
+func (c *Chain) HeaderByHash(hash []byte) (*block.Header, error) {
+	var header *block.Header
+	err := c.db.View(func(t *bolt.Tx) error {
+		header = getHeader(t, hash)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if header == nil {
+		return nil, fmt.Errorf("block header %x not found", hash)
+	}
+	return header, nil
+}
+
+// 凭高度获取区块链上的区块头。
+func (c *Chain) HeaderByHeight(height int) (*block.Header, error) {
+	headers := c.Headers(height, 1)
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	return headers[0], nil
+}
+
+// 获取区块链上从高度 from 开始的区块头，按高度排列，最多 limit 个。
+func (c *Chain) Headers(from int, limit int) []*block.Header {
+	var headers []*block.Header
+	err := c.db.View(func(t *bolt.Tx) error {
+		heights := t.Bucket([]byte(heightsBucket))
+		for height := from; height >= 0 && len(headers) < limit; height++ {
+			hash := heights.Get(heightKey(height))
+			if hash == nil {
+				break
+			}
+			headers = append(headers, getHeader(t, hash))
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return headers
+}
+
+// 获取区块链上位于区块定位器中第一个已知区块之后的区块头，按从旧到新排列，最多 limit 个。
+// 供对方先同步区块头、核对工作量证明后再下载区块。
+func (c *Chain) HeadersAfter(locator [][]byte, limit int) []*block.Header {
+	hashes := c.HashesAfter(locator, limit)
+	headers := make([]*block.Header, 0, len(hashes))
+	err := c.db.View(func(t *bolt.Tx) error {
+		for _, hash := range hashes {
+			headers = append(headers, getHeader(t, hash))
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return headers
+}
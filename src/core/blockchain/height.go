@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"blockchain/utils"
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// 数据库。
+const heightsBucket = "heights" // 区块高度 - 区块链上该高度处区块的哈希值。
+
+// 生成区块高度在数据库中的键。大端序保证键按高度排列。
+func heightKey(height int) []byte {
+	return utils.Int64ToBytes(int64(height))
+}
+
+// 获取区块的高度。（创世块高度为 0）
+// 升级前录入的区块没有记录高度，此时沿父区块回溯计算。
+func heightOf(t *bolt.Tx, b *block.Block) int {
+	height := 0
+	for b.Height == 0 && len(b.PrevBlockHash) != 0 {
+		b = getBlock(t, b.PrevBlockHash)
+		height++
+	}
+	return height + b.Height
+}
+
+// 从尾部开始补建高度索引，直到遇到已正确索引的区块。
+func indexHeights(t *bolt.Tx, tip []byte) error {
+	bucket := t.Bucket([]byte(heightsBucket))
+	curBlock := getBlock(t, tip)
+	for height := heightOf(t, curBlock); ; height-- {
+		if bytes.Equal(bucket.Get(heightKey(height)), curBlock.Hash) {
+			return nil
+		}
+		err := bucket.Put(heightKey(height), curBlock.Hash)
+		if err != nil {
+			return err
+		}
+		if len(curBlock.PrevBlockHash) == 0 {
+			return nil
+		}
+		curBlock = getBlock(t, curBlock.PrevBlockHash)
+	}
+}
+
+// 获取从创世块到指定区块的全部区块，按高度排列。
+func mainChainBlocks(t *bolt.Tx, tip []byte) []*block.Block {
+	var blocks []*block.Block
+	for b := getBlock(t, tip); b != nil; b = getBlock(t, b.PrevBlockHash) {
+		blocks = append(blocks, b)
+	}
+	reverseBlocks(blocks)
+	return blocks
+}
+
+// 获取区块链当前的高度。（创世块高度为 0）
+func (c *Chain) Height() int {
+	height := 0
+	err := c.db.View(func(t *bolt.Tx) error {
+		height = heightOf(t, getBlock(t, c.rear))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return height
+}
+
+// 凭高度获取区块链上的区块。
+func (c *Chain) BlockByHeight(height int) (*block.Block, error) {
+	var b *block.Block
+	err := c.db.View(func(t *bolt.Tx) error {
+		if hash := t.Bucket([]byte(heightsBucket)).Get(heightKey(height)); hash != nil {
+			b = getBlock(t, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if b == nil {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	return b, nil
+}
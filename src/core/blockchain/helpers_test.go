@@ -0,0 +1,48 @@
+package blockchain
+
+import (
+	"blockchain/core/wallet"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 切换到临时目录并返回其路径，钱包文件随工作目录隔离。
+func chdirTemp(t *testing.T) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+// 挖出 n 个只包含交易池内交易的区块。
+func mineBlocks(t *testing.T, c *Chain, miner string, n int) {
+	for i := 0; i < n; i++ {
+		if _, err := c.MinePending(context.Background(), miner); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 在临时目录中创建两个钱包和一条区块链，挖出足够的区块使 alice 的 coinbase 输出成熟。
+// 返回区块链及 alice、bob 的地址，区块链在测试结束时关闭。
+func newFundedChain(t *testing.T) (*Chain, string, string) {
+	dir := chdirTemp(t)
+
+	ws := wallet.LoadWallets()
+	alice := ws.AddWallet()
+	bob := ws.AddWallet()
+	ws.Persist()
+
+	chain := NewChain(filepath.Join(dir, "chain.db"), alice)
+	t.Cleanup(chain.Close)
+	mineBlocks(t, chain, alice, coinbaseMaturity)
+	return chain, alice, bob
+}
@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"blockchain/core/wallet"
+	"crypto/ecdsa"
+	"encoding/hex"
+)
+
+// 创建哈希时间锁合约，并向合约地址付款：to 出示 secretHash 的原像即可领取，
+// 到达 lockTime 后 from 可以取回。交易费的计算方式同 NewUtxoTx。
+func (c *Chain) NewHTLCTx(from string, to string, amount int, fee int, feeRate int, secretHash []byte, lockTime int64) (*transaction.HTLCScript, *transaction.Transaction) {
+	senderHash, senderScript := transaction.ParseAddress(from)
+	recipientHash, recipientScript := transaction.ParseAddress(to)
+	if senderScript || recipientScript {
+		panic("hash time lock contracts need ordinary addresses")
+	}
+
+	contract, err := transaction.NewHTLCScript(secretHash, recipientHash, senderHash, lockTime)
+	if err != nil {
+		panic(err)
+	}
+	tx := c.NewUtxoTx(from, wallet.ScriptAddressOf(contract.Hash()), amount, fee, feeRate, 0)
+	return contract, tx
+}
+
+// 创建领取哈希时间锁合约的交易：收款方出示原像，领取合约地址上的全部余额，扣除交易费。
+func (c *Chain) NewHTLCClaimTx(contract *transaction.HTLCScript, preimage []byte, fee int) *transaction.Transaction {
+	if !contract.Matches(preimage) {
+		panic("preimage does not match the secret hash of the contract")
+	}
+	recipient := wallet.LoadWallets().GetWallet(wallet.AddressOf(contract.Recipient))
+	return c.spendHTLC(contract, recipient.Privkey, contract.Recipient, 0, fee, func(signature []byte) []byte {
+		return contract.ClaimScript(signature, recipient.Pubkey, preimage)
+	})
+}
+
+// 创建取回哈希时间锁合约的交易：交易的锁定时间为合约的锁定时间，在此之前无法上链。
+func (c *Chain) NewHTLCRefundTx(contract *transaction.HTLCScript, fee int) *transaction.Transaction {
+	sender := wallet.LoadWallets().GetWallet(wallet.AddressOf(contract.Sender))
+	return c.spendHTLC(contract, sender.Privkey, contract.Sender, contract.LockTime, fee, func(signature []byte) []byte {
+		return contract.RefundScript(signature, sender.Pubkey)
+	})
+}
+
+// 消费合约地址上的全部未消费输出，支付给 to，并用 unlock 由签名生成各输入的解锁脚本。
+func (c *Chain) spendHTLC(contract *transaction.HTLCScript, privkey ecdsa.PrivateKey, to []byte, lockTime int64, fee int, unlock func([]byte) []byte) *transaction.Transaction {
+	if fee < 0 {
+		panic("negative fee")
+	}
+	deposit, UTXOToPay := c.FindUtxosToPay(contract.Hash(), true, 0)
+	if deposit == 0 {
+		panic("no coins are locked in the contract")
+	}
+	if deposit <= fee {
+		panic("not enough money")
+	}
+
+	tx := &transaction.Transaction{
+		Outputs:  []*transaction.TxOutput{transaction.NewTxo(deposit-fee, wallet.AddressOf(to))},
+		Version:  transaction.AmountVersion,
+		LockTime: lockTime,
+	}
+	refTxs := make(map[string]*transaction.Transaction)
+	for txIDString, indexes := range UTXOToPay {
+		txID, err := hex.DecodeString(txIDString)
+		if err != nil {
+			panic(err)
+		}
+		refTx, err := c.FindTx(txID)
+		if err != nil {
+			panic(err)
+		}
+		refTxs[txIDString] = refTx
+		for _, index := range indexes {
+			tx.Inputs = append(tx.Inputs, &transaction.TxInput{RefID: txID, RefIndex: index})
+		}
+	}
+	tx.ID = tx.ComputeID()
+
+	for txiIndex, txi := range tx.Inputs {
+		txi.Script = unlock(tx.SignInput(txiIndex, privkey, refTxs))
+	}
+	return tx
+}
@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"blockchain/core/wallet"
+	"crypto/sha256"
+	"path/filepath"
+	"testing"
+)
+
+// 两条链上的原子交换：Alice 在链一锁定给 Bob，Bob 在链二用同一哈希锁定给 Alice。
+// Alice 在链二出示原像领取，Bob 从她的领取交易中读出原像，领取链一上的钱。
+func TestAtomicSwapAcrossChains(t *testing.T) {
+	dir := chdirTemp(t)
+
+	ws := wallet.LoadWallets()
+	alice := ws.AddWallet()
+	bob := ws.AddWallet()
+	ws.Persist()
+
+	chain1 := NewChain(filepath.Join(dir, "chain1.db"), alice)
+	defer chain1.Close()
+	chain2 := NewChain(filepath.Join(dir, "chain2.db"), bob)
+	defer chain2.Close()
+	mineBlocks(t, chain1, alice, coinbaseMaturity)
+	mineBlocks(t, chain2, bob, coinbaseMaturity)
+
+	secret := []byte("atomic swap secret")
+	secretHash := sha256.Sum256(secret)
+	const amount, fee = 5, 1
+
+	// 链一：Alice 锁定给 Bob；链二：Bob 用同一哈希锁定给 Alice，锁定时间更短。
+	contract1, lockTx1 := chain1.NewHTLCTx(alice, bob, amount, fee, 0, secretHash[:], int64(chain1.Height()+8))
+	if err := chain1.SubmitTx(lockTx1); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain1, alice, 1)
+	contract2, lockTx2 := chain2.NewHTLCTx(bob, alice, amount, fee, 0, secretHash[:], int64(chain2.Height()+4))
+	if err := chain2.SubmitTx(lockTx2); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain2, bob, 1)
+
+	// 错误的原像无法领取。
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("claim with a wrong preimage did not panic")
+			}
+		}()
+		chain2.NewHTLCClaimTx(contract2, []byte("wrong secret"), fee)
+	}()
+
+	// 链二：Alice 出示原像领取。
+	aliceBefore := chain2.GetBalance(alice)
+	claimTx2 := chain2.NewHTLCClaimTx(contract2, secret, fee)
+	if err := chain2.SubmitTx(claimTx2); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain2, bob, 1)
+	if got, want := chain2.GetBalance(alice), aliceBefore+amount-fee; got != want {
+		t.Fatalf("alice has %d on chain 2 after claiming, want %d", got, want)
+	}
+
+	// 链一：Bob 从链二上 Alice 的领取交易中读出原像，领取 Alice 锁定的钱。
+	claimed, err := chain2.FindTx(claimTx2.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preimage, err := contract2.ExtractPreimage(claimed.Inputs[0].UnlockingScript())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := contract1.ExtractPreimage(claimed.Inputs[0].UnlockingScript()); err == nil {
+		t.Error("extracted a preimage from a claim of another contract")
+	}
+	if _, err := contract2.ExtractPreimage(lockTx2.Inputs[0].UnlockingScript()); err == nil {
+		t.Error("extracted a preimage from a transaction that does not claim the contract")
+	}
+	bobBefore := chain1.GetBalance(bob)
+	claimTx1 := chain1.NewHTLCClaimTx(contract1, preimage, fee)
+	if err := chain1.SubmitTx(claimTx1); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain1, alice, 1)
+	if got, want := chain1.GetBalance(bob), bobBefore+amount-fee; got != want {
+		t.Fatalf("bob has %d on chain 1 after claiming, want %d", got, want)
+	}
+
+	// 两份合约都已领取，付款方无法再取回。
+	for _, test := range []struct {
+		chain    *Chain
+		contract *transaction.HTLCScript
+	}{{chain1, contract1}, {chain2, contract2}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("refund of a claimed contract did not panic")
+				}
+			}()
+			test.chain.NewHTLCRefundTx(test.contract, fee)
+		}()
+	}
+
+	if err := chain1.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain2.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 收款方没有领取时，付款方的取回交易在锁定时间之前留在交易池中，到达锁定时间后上链。
+func TestHTLCRefundAfterLockTime(t *testing.T) {
+	chain, alice, bob := newFundedChain(t)
+
+	secretHash := sha256.Sum256([]byte("secret"))
+	const amount, fee = 5, 1
+
+	lockTime := int64(chain.Height() + 4)
+	contract, lockTx := chain.NewHTLCTx(alice, bob, amount, fee, 0, secretHash[:], lockTime)
+	if err := chain.SubmitTx(lockTx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, bob, 1)
+	aliceAfterLock := chain.GetBalance(alice)
+
+	refundTx := chain.NewHTLCRefundTx(contract, fee)
+	if err := chain.SubmitTx(refundTx); err != nil {
+		t.Fatal(err)
+	}
+	for int64(chain.Height()+1) < lockTime {
+		mineBlocks(t, chain, bob, 1)
+		if chain.PendingTx(refundTx.ID) == nil {
+			t.Fatalf("refund was mined at height %d, before lock time %d", chain.Height(), lockTime)
+		}
+	}
+	if got := chain.GetBalance(alice); got != aliceAfterLock {
+		t.Fatalf("alice has %d before the refund, want %d", got, aliceAfterLock)
+	}
+
+	mineBlocks(t, chain, bob, 1)
+	if chain.PendingTx(refundTx.ID) != nil {
+		t.Fatalf("refund is still pending at height %d, lock time %d", chain.Height(), lockTime)
+	}
+	if got, want := chain.GetBalance(alice), aliceAfterLock+amount-fee; got != want {
+		t.Fatalf("alice has %d after the refund, want %d", got, want)
+	}
+
+	if err := chain.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
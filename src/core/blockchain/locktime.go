@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// 计算过去中位时间所用的区块数。
+const medianTimeSpan = 11
+
+// 获取时间戳的中位数。
+func medianTime(timestamps []int64) int64 {
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, timestamps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// 获取指定区块的过去中位时间：该区块及之前共 medianTimeSpan 个区块时间戳的中位数。
+// 区块的时间戳由矿工填写，中位数不会因个别区块的时间戳而大幅变化。只读取区块头。
+func blockMedianTime(t *bolt.Tx, hash []byte) int64 {
+	var timestamps []int64
+	for h := getHeader(t, hash); h != nil && len(timestamps) < medianTimeSpan; h = getHeader(t, h.PrevBlockHash) {
+		timestamps = append(timestamps, h.Timestamp)
+	}
+	return medianTime(timestamps)
+}
+
+// 获取从指定区块向前第 steps 个祖先区块的过去中位时间。
+func ancestorMedianTime(t *bolt.Tx, hash []byte, steps int) int64 {
+	for ; steps > 0 && len(hash) != 0; steps-- {
+		h := getHeader(t, hash)
+		if h == nil {
+			return 0
+		}
+		hash = h.PrevBlockHash
+	}
+	return blockMedianTime(t, hash)
+}
+
+// 校验交易的锁定时间：交易本身的锁定时间已经到达，且每一笔输入的相对锁定时间已经到达。
+// 相对锁定时间从被引用的输出所在区块算起：以区块计时比较高度之差，
+// 以秒计时比较前一个区块与输出所在区块的前一个区块的过去中位时间之差。
+// 以秒计时只看过去中位时间而不看区块自己的时间戳：checkHeaderRules 要求时间戳晚于过去中位时间、
+// 且不超前本地时间两小时以上，个别矿工填写的时间戳无法让锁定提前到达。
+// 只用于非 coinbase 交易。返回出错原因；锁定时间均已到达时为空。
+func checkLockTimes(tx *transaction.Transaction, view txoView) string {
+	height := view.spendHeight()
+	if !tx.IsFinal(height, view.medianTimePast(height-1)) {
+		return fmt.Sprintf("transaction is locked until %d", tx.LockTime)
+	}
+
+	for _, txi := range tx.Inputs {
+		lock, isTime := txi.RelativeLock()
+		if lock == 0 {
+			continue
+		}
+		// 被引用的输出不存在时由 checkTx 报错。
+		utxo := view.unspent(txi.RefID, txi.RefIndex)
+		if utxo == nil {
+			continue
+		}
+		if !isTime && int64(height-utxo.Height) < lock {
+			return fmt.Sprintf("output %s is locked for %d blocks", outpointKey(txi.RefID, txi.RefIndex), lock)
+		}
+		if isTime && view.medianTimePast(height-1)-view.medianTimePast(utxo.Height-1) < lock {
+			return fmt.Sprintf("output %s is locked for %d seconds", outpointKey(txi.RefID, txi.RefIndex), lock)
+		}
+	}
+	return ""
+}
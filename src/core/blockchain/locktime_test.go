@@ -0,0 +1,52 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"context"
+	"testing"
+	"time"
+)
+
+// 以秒计的锁定时间无法被个别区块的时间戳提前满足。
+func TestTimeLockIgnoresForgedTimestamps(t *testing.T) {
+	chain, alice, bob := newFundedChain(t)
+
+	lockTime := time.Now().Unix() + 60*60
+	locked := chain.NewUtxoTx(alice, bob, 3, 1, 0, lockTime)
+	if err := chain.SubmitTx(locked); err != nil {
+		t.Fatal(err)
+	}
+
+	// 时间戳超前本地时间两小时以上的区块被拒绝。
+	mineAt := func(timestamp int64) error {
+		b, err := chain.NewBlockTemplate(chain.AssembleBlock(alice, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Timestamp = timestamp
+		if _, err := b.Mine(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return chain.AcceptBlock(b)
+	}
+	if err := mineAt(time.Now().Unix() + maxFutureBlockTime + 60); err == nil {
+		t.Fatal("accepted a block more than two hours in the future")
+	}
+
+	// 时间戳超过锁定时间、但仍在允许范围内的区块可以上链，过去中位时间却不受影响。
+	if err := mineAt(lockTime + 60); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.NewBlockTemplate(chain.AssembleBlock(alice, []*transaction.Transaction{locked})); err == nil {
+		t.Fatal("time locked transaction fits into a block after one forged timestamp")
+	}
+	mineBlocks(t, chain, alice, 1)
+	if chain.PendingTx(locked.ID) == nil {
+		t.Fatal("time locked transaction was mined before its lock time")
+	}
+
+	// 时间戳不晚于过去中位时间的区块被拒绝。
+	if err := mineAt(0); err == nil {
+		t.Fatal("accepted a block with a timestamp before median time past")
+	}
+}
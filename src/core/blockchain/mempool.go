@@ -0,0 +1,335 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 数据库。
+const mempoolBucket = "mempool"
+
+// 交易池限制。
+const maxTxSize = 100 * 1024           // 单笔交易的最大字节数。
+const maxMempoolSize = 4 * 1024 * 1024 // 交易池内交易的最大总字节数。
+const maxBlockSize = 1024 * 1024       // 区块内交易的最大总字节数。
+const mempoolExpiry = 72 * time.Hour   // 交易在交易池内的最长停留时间。
+
+// 交易池条目结构。
+type mempoolEntry struct {
+	Tx    *transaction.Transaction // 待确认的交易。
+	Fee   int                      // 交易费。
+	Added int64                    // 加入交易池的时间戳。
+}
+
+// 判断条目是否已经过期。
+func (e *mempoolEntry) expired(now time.Time) bool {
+	return now.Sub(time.Unix(e.Added, 0)) > mempoolExpiry
+}
+
+// 判断条目 e 的费率是否高于条目 other。交叉相乘比较，避免浮点误差。
+func (e *mempoolEntry) paysMoreThan(other *mempoolEntry) bool {
+	return e.Fee*other.Tx.Size() > other.Fee*e.Tx.Size()
+}
+
+// 序列化交易池条目。
+func (e *mempoolEntry) serialize() []byte {
+	var seq bytes.Buffer
+
+	encoder := gob.NewEncoder(&seq)
+	err := encoder.Encode(e)
+	if err != nil {
+		panic(err)
+	}
+
+	return seq.Bytes()
+}
+
+// 反序列化交易池条目。
+func deserializeMempoolEntry(seq []byte) *mempoolEntry {
+	var entry mempoolEntry
+
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	err := decoder.Decode(&entry)
+	if err != nil {
+		panic(err)
+	}
+
+	return &entry
+}
+
+// 读取交易池内的全部条目。交易池 bucket 不存在时返回空列表。
+func mempoolEntries(t *bolt.Tx) []*mempoolEntry {
+	var entries []*mempoolEntry
+
+	bucket := t.Bucket([]byte(mempoolBucket))
+	if bucket == nil {
+		return entries
+	}
+
+	cursor := bucket.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		entries = append(entries, deserializeMempoolEntry(value))
+	}
+	return entries
+}
+
+// 获取交易池内的交易已经消费的输出：定位键 - 消费它的交易 ID。
+func pendingSpends(t *bolt.Tx) map[string][]byte {
+	spends := make(map[string][]byte)
+	for _, entry := range mempoolEntries(t) {
+		for _, txi := range entry.Tx.Inputs {
+			spends[outpointKey(txi.RefID, txi.RefIndex)] = entry.Tx.ID
+		}
+	}
+	return spends
+}
+
+// 将交易提交到交易池，等待矿工打包。
+// 交易需满足大小限制、不与交易池内的其他交易消费同一输出，并通过共识规则校验。
+// 输入只能引用已经上链的输出，消费交易池内交易输出的交易需要等父交易上链后再提交。
+// 锁定时间尚未到达的交易也会被接受，在交易池中等待到可以上链时再被打包。
+func (c *Chain) SubmitTx(tx *transaction.Transaction) error {
+	if tx.IsCoinbase() {
+		return errors.New("coinbase transaction cannot be submitted")
+	}
+	size := tx.Size()
+	if size > maxTxSize {
+		return fmt.Errorf("transaction is %d bytes, larger than %d", size, maxTxSize)
+	}
+
+	c.expireMempool()
+
+	// 检查交易池内的重复交易和冲突。
+	var (
+		poolSize int
+		exists   bool
+		spends   map[string][]byte
+	)
+	err := c.db.View(func(t *bolt.Tx) error {
+		for _, entry := range mempoolEntries(t) {
+			poolSize += entry.Tx.Size()
+			exists = exists || bytes.Equal(entry.Tx.ID, tx.ID)
+		}
+		spends = pendingSpends(t)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if exists {
+		return fmt.Errorf("transaction %x is already pending", tx.ID)
+	}
+	for _, txi := range tx.Inputs {
+		if pendingID, ok := spends[outpointKey(txi.RefID, txi.RefIndex)]; ok {
+			return fmt.Errorf("transaction conflicts with pending transaction %x", pendingID)
+		}
+	}
+	if poolSize+size > maxMempoolSize {
+		return errors.New("mempool is full")
+	}
+
+	// 按共识规则校验交易。
+	fee, reason := checkTx(tx, &chainView{c}, make(map[string]bool))
+	if reason != "" {
+		return errors.New(reason)
+	}
+
+	entry := &mempoolEntry{tx, fee, time.Now().Unix()}
+	return c.db.Update(func(t *bolt.Tx) error {
+		bucket, err := t.CreateBucketIfNotExists([]byte(mempoolBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(tx.ID, entry.serialize())
+	})
+}
+
+// 获取交易池内的全部交易，按费率从高到低排列。
+func (c *Chain) PendingTxs() []*transaction.Transaction {
+	var entries []*mempoolEntry
+	err := c.db.View(func(t *bolt.Tx) error {
+		entries = mempoolEntries(t)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].paysMoreThan(entries[j])
+	})
+
+	txs := make([]*transaction.Transaction, 0, len(entries))
+	for _, entry := range entries {
+		txs = append(txs, entry.Tx)
+	}
+	return txs
+}
+
+// 从交易池中挑选交易，挖出一个新区块，挖矿过程可通过 ctx 中止。
+// 返回打包进区块的交易数（不含 coinbase）。
+func (c *Chain) MinePending(ctx context.Context, miner string) (int, error) {
+	selected := c.SelectPending()
+	err := c.AddBlockWithContext(ctx, c.AssembleBlock(miner, selected))
+	if err != nil {
+		return 0, err
+	}
+	return len(selected), nil
+}
+
+// 从交易池中挑选可以打包进下一个区块的交易。
+// 交易按费率从高到低挑选，直到达到区块大小上限；锁定时间尚未到达的交易留在交易池中，
+// 已经失效的交易会被移出交易池。
+// 区块内的交易只能消费区块之前已经上链的输出，消费交易池内其他交易输出的交易同样留在交易池中，
+// 等父交易上链后再被打包；父交易被移出交易池时，它们随后过期。
+func (c *Chain) SelectPending() []*transaction.Transaction {
+	c.expireMempool()
+
+	var (
+		selected []*transaction.Transaction
+		invalid  [][]byte
+	)
+	pending := c.PendingTxs()
+	pendingIDs := make(map[string]bool)
+	for _, tx := range pending {
+		pendingIDs[hex.EncodeToString(tx.ID)] = true
+	}
+
+	blockSize := 0
+	spent := make(map[string]bool)
+	view := &chainView{c}
+	for _, tx := range pending {
+		if blockSize+tx.Size() > maxBlockSize {
+			continue
+		}
+		if checkLockTimes(tx, view) != "" || spendsPending(tx, pendingIDs) {
+			continue
+		}
+		if _, reason := checkTx(tx, view, spent); reason != "" {
+			invalid = append(invalid, tx.ID)
+			continue
+		}
+		selected = append(selected, tx)
+		blockSize += tx.Size()
+	}
+
+	if len(invalid) > 0 {
+		c.removeFromMempool(invalid)
+	}
+	return selected
+}
+
+// 判断交易是否消费了 pendingIDs 中尚未上链的交易的输出。
+func spendsPending(tx *transaction.Transaction, pendingIDs map[string]bool) bool {
+	for _, txi := range tx.Inputs {
+		if pendingIDs[hex.EncodeToString(txi.RefID)] {
+			return true
+		}
+	}
+	return false
+}
+
+// 移出交易池内已过期的交易。
+func (c *Chain) expireMempool() {
+	var expired [][]byte
+	now := time.Now()
+	err := c.db.View(func(t *bolt.Tx) error {
+		for _, entry := range mempoolEntries(t) {
+			if entry.expired(now) {
+				expired = append(expired, entry.Tx.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if len(expired) > 0 {
+		c.removeFromMempool(expired)
+	}
+}
+
+// 区块上链后，移出交易池内已被打包的交易，以及与区块内交易消费同一输出的交易。
+func (c *Chain) pruneMempool(txs []*transaction.Transaction) {
+	confirmed := make(map[string]bool)
+	spent := make(map[string]bool)
+	for _, tx := range txs {
+		confirmed[hex.EncodeToString(tx.ID)] = true
+		for _, txi := range tx.Inputs {
+			spent[outpointKey(txi.RefID, txi.RefIndex)] = true
+		}
+	}
+
+	var stale [][]byte
+	err := c.db.View(func(t *bolt.Tx) error {
+		for _, entry := range mempoolEntries(t) {
+			if confirmed[hex.EncodeToString(entry.Tx.ID)] {
+				stale = append(stale, entry.Tx.ID)
+				continue
+			}
+			for _, txi := range entry.Tx.Inputs {
+				if spent[outpointKey(txi.RefID, txi.RefIndex)] {
+					stale = append(stale, entry.Tx.ID)
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if len(stale) > 0 {
+		c.removeFromMempool(stale)
+	}
+}
+
+// 从交易池中删除指定交易。
+func (c *Chain) removeFromMempool(txIDs [][]byte) {
+	err := c.db.Update(func(t *bolt.Tx) error {
+		bucket := t.Bucket([]byte(mempoolBucket))
+		if bucket == nil {
+			return nil
+		}
+		for _, txID := range txIDs {
+			err := bucket.Delete(txID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// 凭 ID 获取交易池内的交易，不存在时返回 nil。
+func (c *Chain) PendingTx(ID []byte) *transaction.Transaction {
+	var tx *transaction.Transaction
+	err := c.db.View(func(t *bolt.Tx) error {
+		bucket := t.Bucket([]byte(mempoolBucket))
+		if bucket == nil {
+			return nil
+		}
+		if value := bucket.Get(ID); value != nil {
+			tx = deserializeMempoolEntry(value).Tx
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
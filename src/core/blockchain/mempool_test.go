@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 不经校验直接把交易放入交易池。
+func putPending(t *testing.T, c *Chain, tx *transaction.Transaction, fee int) {
+	t.Helper()
+	entry := &mempoolEntry{tx, fee, time.Now().Unix()}
+	err := c.db.Update(func(t *bolt.Tx) error {
+		bucket, err := t.CreateBucketIfNotExists([]byte(mempoolBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(tx.ID, entry.serialize())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 消费交易池内父交易输出的交易不与父交易打包进同一区块，留在交易池中，等父交易上链后再被打包。
+func TestSelectPendingWaitsForParents(t *testing.T) {
+	chain, alice, bob := newFundedChain(t)
+
+	// 父交易上链后才能创建子交易；再用更长的分支断开父交易所在的区块，父交易回到交易池。
+	fork := chain.Tip()
+	parent := chain.NewUtxoTx(alice, bob, 3, 1, 0, 0)
+	if err := chain.SubmitTx(parent); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, alice, 1)
+	child := chain.NewUtxoTx(bob, alice, 1, 1, 0, 0)
+	side := mineOn(t, chain, fork, bob)
+	mineOn(t, chain, side.Hash, bob)
+	if chain.PendingTx(parent.ID) == nil {
+		t.Fatal("parent is not back in the mempool")
+	}
+
+	// 子交易无法通过正常途径提交，直接放入交易池。
+	if err := chain.SubmitTx(child); err == nil {
+		t.Fatal("submitted a transaction spending a pending output")
+	}
+	putPending(t, chain, child, 1)
+
+	if _, reason := checkBlockTxs([]*transaction.Transaction{NewCoinbaseTx(alice, "", 2), parent, child}, &chainView{chain}); !strings.Contains(reason, "does not exist") {
+		t.Fatalf("block with a parent and its child: %q", reason)
+	}
+
+	selected := chain.SelectPending()
+	if len(selected) != 1 || !bytes.Equal(selected[0].ID, parent.ID) {
+		t.Fatalf("selected %d transactions, want only the parent", len(selected))
+	}
+	if chain.PendingTx(child.ID) == nil {
+		t.Fatal("child was removed from the mempool")
+	}
+
+	mineBlocks(t, chain, alice, 1)
+	if chain.PendingTx(parent.ID) != nil {
+		t.Fatal("parent was not mined")
+	}
+	selected = chain.SelectPending()
+	if len(selected) != 1 || !bytes.Equal(selected[0].ID, child.ID) {
+		t.Fatalf("selected %d transactions after the parent was mined, want the child", len(selected))
+	}
+	mineBlocks(t, chain, alice, 1)
+	if chain.PendingTx(child.ID) != nil {
+		t.Fatal("child was not mined after its parent")
+	}
+}
@@ -1,22 +1,29 @@
 package blockchain
 
 import (
+	"blockchain/core/script"
 	"blockchain/core/transaction"
 	"blockchain/core/wallet"
-	"blockchain/utils"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 )
 
-// 创建一笔 coinbase 交易。
-func NewCoinbaseTx(to string, data string) *transaction.Transaction {
+// 创建一笔 coinbase 交易，奖励为出块奖励加上区块内的交易费。
+// 未给出数据时附加随机数，避免同一地址的多笔 coinbase 交易 ID 相同。
+func NewCoinbaseTx(to string, data string, fees int) *transaction.Transaction {
 	if data == "" {
-		data = fmt.Sprintf("Reward to '%s'", to)
+		random := make([]byte, 8)
+		_, err := rand.Read(random)
+		if err != nil {
+			panic(err)
+		}
+		data = fmt.Sprintf("Reward to '%s' %x", to, random)
 	}
 
 	// 创建交易的输入和输出。
 	txi := transaction.NewTxi([]byte{}, -1, nil, []byte(data))
-	txo := transaction.NewTxo(subsidy, to)
+	txo := transaction.NewTxo(subsidy+fees, to)
 	tx := transaction.Transaction{
 		ID:      nil,
 		Inputs:  []*transaction.TxInput{txi},
@@ -26,23 +33,121 @@ func NewCoinbaseTx(to string, data string) *transaction.Transaction {
 	return &tx
 }
 
+// 按费率计算交易费时，最多重新构建交易的次数。
+const maxFeeRounds = 10
+
+// 签名和公钥的字节数，用于在签名前估算交易大小。
+const signatureLen = 64
+const pubkeyLen = 64
+
 // 创建一笔 UTXO 交易。
-func (c *Chain) NewUtxoTx(from string, to string, amount int) *transaction.Transaction {
-	var (
-		newInputs  []*transaction.TxInput
-		newOutputs []*transaction.TxOutput
-	)
+// fee 为固定交易费；feeRate 不为零时改为按交易字节数计算交易费，fee 被忽略。
+// lockTime 不为零时，交易在该区块高度或 Unix 时间之前不能上链。
+func (c *Chain) NewUtxoTx(from string, to string, amount int, fee int, feeRate int, lockTime int64) *transaction.Transaction {
+	return c.newSignedTx(from, []*transaction.TxOutput{transaction.NewTxo(amount, to)}, fee, feeRate, lockTime)
+}
 
-	// 获取发起方的钱包。
+// 创建一笔携带数据的交易：一个金额为 0 的数据输出，交易费由发起方支付，其余找零。交易费的计算方式同 NewUtxoTx。
+func (c *Chain) NewDataTx(from string, data []byte, fee int, feeRate int) *transaction.Transaction {
+	if len(data) > script.MaxNullDataSize {
+		panic(fmt.Sprintf("data is %d bytes, larger than %d", len(data), script.MaxNullDataSize))
+	}
+	return c.newSignedTx(from, []*transaction.TxOutput{transaction.NewDataTxo(data)}, fee, feeRate, 0)
+}
+
+// 由钱包集中的发起方创建并签名一笔支付 outputs 的交易。
+func (c *Chain) newSignedTx(from string, outputs []*transaction.TxOutput, fee int, feeRate int, lockTime int64) *transaction.Transaction {
+	// 获取发起方的钱包。只观察的地址没有私钥，无法签名。
 	wallets := wallet.LoadWallets()
+	if wallets.IsWatchOnly(from) {
+		panic(wallet.ErrWatchOnly)
+	}
+	if wallets.Multisig(from) != nil {
+		panic(wallet.ErrMultisig)
+	}
 	wallet := wallets.GetWallet(from)
-	pubkeyHash := utils.GetPubkeyHash(wallet.Pubkey)
 
-	// 从钱包里找出足够多的钱。
-	deposit, UTXOToPay := c.FindUtxosToPay(pubkeyHash, amount)
+	// 发起方对交易签名。
+	tx := c.newUnsignedTx(wallet.Pubkey, nil, from, outputs, fee, feeRate, lockTime)
+	err := c.SignTx(tx, wallet.Privkey)
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+// 创建一笔未签名的 UTXO 交易，连同被引用的交易一起交给持有私钥的机器离线签名。
+// 发起方不需要在钱包集中，但多重签名地址的赎回脚本需要在钱包集中。交易费和锁定时间同 NewUtxoTx。
+func (c *Chain) NewPartialTx(from string, to string, amount int, fee int, feeRate int, lockTime int64) *transaction.PartialTx {
+	var script *transaction.MultisigScript
+	if _, scriptHash := transaction.ParseAddress(from); scriptHash {
+		script = wallet.LoadWallets().Multisig(from)
+		if script == nil {
+			panic("redeem script of the multisig address not found in wallet")
+		}
+	}
+	tx := c.newUnsignedTx(nil, script, from, []*transaction.TxOutput{transaction.NewTxo(amount, to)}, fee, feeRate, lockTime)
+
+	refTxs := make(map[string]*transaction.Transaction)
+	for _, txi := range tx.Inputs {
+		refTx, err := c.FindTx(txi.RefID)
+		if err != nil {
+			panic(err)
+		}
+		refTxs[hex.EncodeToString(refTx.ID)] = refTx
+	}
+	return transaction.NewPartialTx(tx, refTxs)
+}
+
+// 创建一笔支付 outputs 的未签名 UTXO 交易。pubkey 为发起方的公钥，未知时为 nil，由签名方填入；
+// 发起方为多重签名地址时，redeem 为其赎回脚本。
+func (c *Chain) newUnsignedTx(pubkey []byte, redeem *transaction.MultisigScript, from string, outputs []*transaction.TxOutput, fee int, feeRate int, lockTime int64) *transaction.Transaction {
+	if fee < 0 || feeRate < 0 {
+		panic("negative fee")
+	}
+	if lockTime < 0 {
+		panic("negative lock time")
+	}
+
+	// 从发起方的地址里找出足够多的钱。
+	amount := 0
+	for _, txo := range outputs {
+		var err error
+		amount, err = transaction.AddMoney(amount, txo.Value)
+		if err != nil {
+			panic(err)
+		}
+	}
+	pubkeyHash, scriptHash := transaction.ParseAddress(from)
+	deposit, UTXOToPay := c.FindUtxosToPay(pubkeyHash, scriptHash, amount)
+
+	if feeRate == 0 {
+		return buildUtxoTx(pubkey, redeem, from, outputs, amount, fee, lockTime, deposit, UTXOToPay)
+	}
+
+	// 交易费取决于交易大小，而交易大小又取决于是否需要找零，因此反复构建直到交易费足够。
+	fee = 0
+	for round := 0; round < maxFeeRounds; round++ {
+		tx := buildUtxoTx(pubkey, redeem, from, outputs, amount, fee, lockTime, deposit, UTXOToPay)
+		required := signedSize(tx) * feeRate
+		if fee >= required {
+			return tx
+		}
+		fee = required
+	}
+	panic("unable to settle transaction fee")
+}
+
+// 用选定的未消费输出构建一笔未签名的交易，amount 为 outputs 的总额。
+// 交易使用 AmountVersion 版本，签名包含被引用输出的金额。
+func buildUtxoTx(pubkey []byte, redeem *transaction.MultisigScript, from string, outputs []*transaction.TxOutput, amount int, fee int, lockTime int64, deposit int, UTXOToPay map[string][]int) *transaction.Transaction {
+	var (
+		newInputs  []*transaction.TxInput
+		newOutputs []*transaction.TxOutput
+	)
 
 	// 如果发起方的钱不够了，就报错退出。
-	if deposit < amount {
+	if deposit < amount+fee || len(UTXOToPay) == 0 {
 		panic("not enough money")
 	}
 
@@ -53,28 +158,51 @@ func (c *Chain) NewUtxoTx(from string, to string, amount int) *transaction.Trans
 			panic(err)
 		}
 		for _, index := range indexes {
-			newInputs = append(newInputs, transaction.NewTxi(txID, index, nil, wallet.Pubkey))
+			if redeem != nil {
+				newInputs = append(newInputs, transaction.NewMultisigTxi(txID, index, redeem))
+			} else {
+				newInputs = append(newInputs, transaction.NewTxi(txID, index, nil, pubkey))
+			}
 		}
 	}
 
 	// 创建交易输出。
-	newOutputs = append(newOutputs, transaction.NewTxo(amount, to))
+	newOutputs = append(newOutputs, outputs...)
 
-	// 如果需要找零，就多加一笔记录。
-	if deposit > amount {
-		newOutputs = append(newOutputs, transaction.NewTxo(deposit-amount, from))
+	// 如果需要找零，就多加一笔记录。扣除的交易费留给矿工。
+	if deposit > amount+fee {
+		newOutputs = append(newOutputs, transaction.NewTxo(deposit-amount-fee, from))
 	}
 
 	// 将输入、输出存储进该次交易内。
 	newTX := transaction.Transaction{
-		ID:      nil,
-		Inputs:  newInputs,
-		Outputs: newOutputs,
+		ID:       nil,
+		Inputs:   newInputs,
+		Outputs:  newOutputs,
+		Version:  transaction.AmountVersion,
+		LockTime: lockTime,
 	}
-	newTX.ID = newTX.Hash()
-
-	// 发起方对该次交易签名。
-	c.SignTx(&newTX, wallet.Privkey)
+	newTX.ID = newTX.ComputeID()
 
 	return &newTX
 }
+
+// 估算交易签名后的字节数：尚未签名的输入按签名和公钥的长度补足，多重签名输入按 M 个签名补足。
+func signedSize(tx *transaction.Transaction) int {
+	size := tx.Size()
+	for _, txi := range tx.Inputs {
+		if txi.IsMultisig() {
+			if script, err := transaction.ParseMultisigScript(txi.Pubkey); err == nil {
+				size += script.M * signatureLen
+			}
+			continue
+		}
+		if len(txi.Signature) == 0 {
+			size += signatureLen
+		}
+		if len(txi.Pubkey) == 0 {
+			size += pubkeyLen
+		}
+	}
+	return size
+}
@@ -1,7 +1,6 @@
 package blockchain
 
 import (
-	"blockchain/core/block"
 	"blockchain/core/transaction"
 	"bytes"
 	"crypto/ecdsa"
@@ -13,71 +12,14 @@ import (
 // 挖出新块的奖励。
 const subsidy = 10
 
-// 数据库。
-const utxoBucket = "utxo"
-
-// 凭 ID 查找交易。
-func (c *Chain) FindTx(ID []byte) *transaction.Transaction {
-	iter := c.Iterator()
-	for {
-		block := iter.Next()
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.ID, ID) {
-				return tx
-			}
-		}
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
-	}
-	panic("transaction not found")
-}
-
-// 找到所有未消费的交易输出。
-func (c *Chain) FindUtxos() map[string]transaction.TxOutputs {
-	utxos := make(map[string]transaction.TxOutputs)
-	stxoIndexes := make(map[string][]int)
-
-	// 遍历区块链中的每一个区块。
-	iter := c.Iterator()
-	for {
-		// 遍历区块中的每一笔交易。
-		curBlock := iter.Next()
-		for _, tx := range curBlock.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-			// 遍历交易的输出。
-			for txoIndex, txo := range tx.Outputs {
-				// 如果这笔输出已经被消费了，就检查下一笔。
-				if stxoIndexes[txID] != nil {
-					for _, stxoIndex := range stxoIndexes[txID] {
-						if stxoIndex == txoIndex {
-							break
-						}
-					}
-				} else {
-					utxo := utxos[txID]
-					utxo.List = append(utxo.List, txo)
-					utxos[txID] = utxo
-				}
-			}
-			// 如果这笔交易不是 coinbase 交易。
-			if !tx.IsCoinbase() {
-				// 遍历这笔交易的输入。
-				for _, txi := range tx.Inputs {
-					refID := hex.EncodeToString(txi.RefID)
-					stxoIndexes[refID] = append(stxoIndexes[refID], txi.RefIndex)
-				}
-			}
-		}
-		if len(curBlock.PrevBlockHash) == 0 {
-			break
-		}
-	}
-	return utxos
+// 凭 ID 查找区块链上的交易。
+func (c *Chain) FindTx(ID []byte) (*transaction.Transaction, error) {
+	tx, _, err := c.LocateTx(ID)
+	return tx, err
 }
 
-// 找到指定公钥可解锁的未消费交易输出。
-func (c *Chain) FindPayableUtxos(pubkeyHash []byte) []*transaction.TxOutput {
+// 找到指定公钥可解锁的未消费交易输出。scriptHash 为 true 时 pubkeyHash 是多重签名赎回脚本的哈希值。
+func (c *Chain) FindPayableUtxos(pubkeyHash []byte, scriptHash bool) []*transaction.TxOutput {
 	var utxos []*transaction.TxOutput
 
 	err := c.db.View(func(t *bolt.Tx) error {
@@ -85,12 +27,9 @@ func (c *Chain) FindPayableUtxos(pubkeyHash []byte) []*transaction.TxOutput {
 		cursor := bucket.Cursor()
 
 		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-			txos := transaction.DeserializeTxOutputs(value)
-
-			for _, txo := range txos.List {
-				if txo.IsUnlockableWith(pubkeyHash) {
-					utxos = append(utxos, txo)
-				}
+			entry := deserializeUtxoEntry(value)
+			if entry.isLockedTo(pubkeyHash, scriptHash) {
+				utxos = append(utxos, entry.output())
 			}
 		}
 		return nil
@@ -103,23 +42,28 @@ func (c *Chain) FindPayableUtxos(pubkeyHash []byte) []*transaction.TxOutput {
 }
 
 // 找到指定公钥可解锁的、用于当次支付的未消费交易输出。
-func (c *Chain) FindUtxosToPay(pubkeyHash []byte, amount int) (int, map[string][]int) {
+// 已被交易池内的交易消费的输出，以及尚未成熟的 coinbase 输出不会被选中。
+// scriptHash 为 true 时 pubkeyHash 是多重签名赎回脚本的哈希值。
+func (c *Chain) FindUtxosToPay(pubkeyHash []byte, scriptHash bool, amount int) (int, map[string][]int) {
 	utxoToPay := make(map[string][]int)
 	atHand := 0
 
 	err := c.db.View(func(t *bolt.Tx) error {
 		bucket := t.Bucket([]byte(utxoBucket))
 		cursor := bucket.Cursor()
+		spends := pendingSpends(t)
+		spendHeight := heightOf(t, getBlock(t, c.rear)) + 1
 
 		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-			txID := hex.EncodeToString(key)
-			txos := transaction.DeserializeTxOutputs(value)
-
-			for txoIndex, txo := range txos.List {
-				if txo.IsUnlockableWith(pubkeyHash) {
-					atHand += txo.Value
-					utxoToPay[txID] = append(utxoToPay[txID], txoIndex)
-				}
+			txID, index := parseUtxoKey(key)
+			if _, pending := spends[outpointKey(txID, index)]; pending {
+				continue
+			}
+			entry := deserializeUtxoEntry(value)
+			if entry.isLockedTo(pubkeyHash, scriptHash) && entry.isMature(spendHeight) {
+				atHand += entry.Value
+				txIDString := hex.EncodeToString(txID)
+				utxoToPay[txIDString] = append(utxoToPay[txIDString], index)
 			}
 		}
 		return nil
@@ -132,18 +76,22 @@ func (c *Chain) FindUtxosToPay(pubkeyHash []byte, amount int) (int, map[string][
 }
 
 // 对交易进行数字签名。
-func (c *Chain) SignTx(tx *transaction.Transaction, privkey ecdsa.PrivateKey) {
+func (c *Chain) SignTx(tx *transaction.Transaction, privkey ecdsa.PrivateKey) error {
 	refTxs := make(map[string]*transaction.Transaction)
 
 	// 找到交易每一笔输入引用的交易。
 	for _, txi := range tx.Inputs {
-		refTx := c.FindTx(txi.RefID)
+		refTx, err := c.FindTx(txi.RefID)
+		if err != nil {
+			return err
+		}
 		refTxs[hex.EncodeToString(refTx.ID)] = refTx
 	}
 	tx.Sign(privkey, refTxs)
+	return nil
 }
 
-// 验证交易的数字签名。
+// 验证交易的脚本。引用的交易不存在时视为无效。
 func (c *Chain) VerifyTx(tx *transaction.Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
@@ -151,13 +99,16 @@ func (c *Chain) VerifyTx(tx *transaction.Transaction) bool {
 
 	refTxs := make(map[string]*transaction.Transaction)
 	for _, txi := range tx.Inputs {
-		refTx := c.FindTx(txi.RefID)
+		refTx, err := c.FindTx(txi.RefID)
+		if err != nil {
+			return false
+		}
 		refTxs[hex.EncodeToString(refTx.ID)] = refTx
 	}
-	return tx.Verify(refTxs)
+	return tx.Verify(refTxs) == nil
 }
 
-// 获取区块链内的交易数量。
+// 获取区块链内尚有未消费输出的交易数量。
 func (c *Chain) CountTx() int {
 	cnt := 0
 
@@ -165,8 +116,14 @@ func (c *Chain) CountTx() int {
 		bucket := t.Bucket([]byte(utxoBucket))
 		cursor := bucket.Cursor()
 
-		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
-			cnt++
+		// 同一交易的输出在键的顺序上相邻。
+		var lastTxID []byte
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+			txID, _ := parseUtxoKey(key)
+			if !bytes.Equal(txID, lastTxID) {
+				cnt++
+				lastTxID = append(lastTxID[:0], txID...)
+			}
 		}
 
 		return nil
@@ -178,96 +135,40 @@ func (c *Chain) CountTx() int {
 	return cnt
 }
 
-// 重新索引区块链内的交易。
+// 重新索引区块链内的交易：从创世块开始重放区块链，重建未消费输出集和地址索引。
 func (c *Chain) Reindex() {
-	bucketName := []byte(utxoBucket)
-
-	// 删除之前的 bucket。
 	err := c.db.Update(func(t *bolt.Tx) error {
-		err := t.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			return err
+		// 删除之前的 bucket，包括升级前格式的 bucket。
+		for _, name := range []string{utxoBucket, legacyUtxoBucket} {
+			err := t.DeleteBucket([]byte(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
 		}
 
-		_, err = t.CreateBucket(bucketName)
+		bucket, err := t.CreateBucket([]byte(utxoBucket))
 		if err != nil {
 			return err
 		}
 
-		return nil
-	})
-	if err != nil {
-		panic(err)
-	}
-
-	// 找到所有未花费的交易输出。
-	utxos := c.FindUtxos()
-
-	// 重新构建 bucket。
-	err = c.db.Update(func(t *bolt.Tx) error {
-		b := t.Bucket(bucketName)
-
-		for txIDString, utxo := range utxos {
-			txID, err := hex.DecodeString(txIDString)
-			if err != nil {
-				panic(err)
-			}
-
-			err = b.Put(txID, utxo.Serialize())
-			if err != nil {
-				panic(err)
-			}
-		}
-
-		return nil
-	})
-	if err != nil {
-		panic(err)
-	}
-}
-
-// 更新数据库。
-func (c *Chain) Update(block *block.Block) {
-	err := c.db.Update(func(t *bolt.Tx) error {
-		bucket := t.Bucket([]byte(utxoBucket))
-
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, txi := range tx.Inputs {
-					updatedTxos := transaction.TxOutputs{}
-					txosBytes := bucket.Get(txi.RefID)
-					txos := transaction.DeserializeTxOutputs(txosBytes)
-
-					for txoIndex, txo := range txos.List {
-						if txoIndex != txi.RefIndex {
-							updatedTxos.List = append(updatedTxos.List, txo)
-						}
-					}
-
-					if len(updatedTxos.List) == 0 {
-						err := bucket.Delete(txi.RefID)
-						if err != nil {
-							return err
-						}
-					} else {
-						err := bucket.Put(txi.RefID, updatedTxos.Serialize())
+		for height, b := range mainChainBlocks(t, c.rear) {
+			for _, tx := range b.Transactions {
+				if !tx.IsCoinbase() {
+					for _, txi := range tx.Inputs {
+						_, err = spendUtxo(bucket, txi.RefID, txi.RefIndex)
 						if err != nil {
 							return err
 						}
 					}
 				}
-			}
-
-			newTxos := transaction.TxOutputs{}
-			newTxos.List = append(newTxos.List, tx.Outputs...)
-
-			err := bucket.Put(tx.ID, newTxos.Serialize())
-			if err != nil {
-				return err
+				err = addTxOutputs(bucket, tx, height)
+				if err != nil {
+					return err
+				}
 			}
 		}
 
-		return nil
+		return rebuildAddressIndex(t, c.rear)
 	})
 	if err != nil {
 		panic(err)
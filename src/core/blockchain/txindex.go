@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"blockchain/core/transaction"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// 数据库。bucket 存在时即启用交易索引。
+const txIndexBucket = "txindex" // 交易 ID - 交易在区块链上的位置。
+
+// 交易在区块链上的位置。
+type TxLocation struct {
+	BlockHash []byte // 所在区块的哈希值。
+	Height    int    // 所在区块的高度。
+	Position  int    // 在区块交易列表中的位置。
+}
+
+// 序列化交易位置。
+func (loc *TxLocation) serialize() []byte {
+	var seq bytes.Buffer
+
+	encoder := gob.NewEncoder(&seq)
+	err := encoder.Encode(loc)
+	if err != nil {
+		panic(err)
+	}
+
+	return seq.Bytes()
+}
+
+// 反序列化交易位置。
+func deserializeTxLocation(seq []byte) *TxLocation {
+	var loc TxLocation
+
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	err := decoder.Decode(&loc)
+	if err != nil {
+		panic(err)
+	}
+
+	return &loc
+}
+
+// 将区块内的交易加入交易索引。未启用交易索引时什么也不做。
+func indexBlockTxs(t *bolt.Tx, b *block.Block, height int) error {
+	bucket := t.Bucket([]byte(txIndexBucket))
+	if bucket == nil {
+		return nil
+	}
+	for pos, tx := range b.Transactions {
+		err := bucket.Put(tx.ID, (&TxLocation{b.Hash, height, pos}).serialize())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从交易索引中移除区块内的交易。未启用交易索引时什么也不做。
+func unindexBlockTxs(t *bolt.Tx, b *block.Block) error {
+	bucket := t.Bucket([]byte(txIndexBucket))
+	if bucket == nil {
+		return nil
+	}
+	for _, tx := range b.Transactions {
+		err := bucket.Delete(tx.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 判断是否启用了交易索引。
+func (c *Chain) HasTxIndex() bool {
+	enabled := false
+	err := c.db.View(func(t *bolt.Tx) error {
+		enabled = t.Bucket([]byte(txIndexBucket)) != nil
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return enabled
+}
+
+// 启用交易索引，并为区块链上的全部交易重建索引。
+func (c *Chain) IndexTxs() {
+	err := c.db.Update(func(t *bolt.Tx) error {
+		err := t.DeleteBucket([]byte(txIndexBucket))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		_, err = t.CreateBucket([]byte(txIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		for b := getBlock(t, c.rear); b != nil; b = getBlock(t, b.PrevBlockHash) {
+			err = indexBlockTxs(t, b, heightOf(t, b))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// 凭 ID 查找区块链上的交易及其位置。
+// 启用了交易索引时直接查找，否则从尾部开始逐个区块扫描。
+func (c *Chain) LocateTx(ID []byte) (*transaction.Transaction, *TxLocation, error) {
+	var (
+		tx  *transaction.Transaction
+		loc *TxLocation
+	)
+	err := c.db.View(func(t *bolt.Tx) error {
+		if bucket := t.Bucket([]byte(txIndexBucket)); bucket != nil {
+			if seq := bucket.Get(ID); seq != nil {
+				loc = deserializeTxLocation(seq)
+				tx = getBlock(t, loc.BlockHash).Transactions[loc.Position]
+			}
+			return nil
+		}
+
+		for b := getBlock(t, c.rear); b != nil; b = getBlock(t, b.PrevBlockHash) {
+			for pos, curTx := range b.Transactions {
+				if bytes.Equal(curTx.ID, ID) {
+					tx, loc = curTx, &TxLocation{b.Hash, heightOf(t, b), pos}
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if tx == nil {
+		return nil, nil, fmt.Errorf("transaction %x not found", ID)
+	}
+	return tx, loc, nil
+}
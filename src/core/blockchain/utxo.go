@@ -0,0 +1,138 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"blockchain/utils"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// 数据库。键为交易 ID 加输出索引（8 字节大端序），每笔未消费输出一条记录。
+const utxoBucket = "utxoset"
+
+// 升级前按交易 ID 存储输出列表的 bucket，读取区块链时被重建后删除。
+const legacyUtxoBucket = "utxo"
+
+// coinbase 交易的输出需要等待的区块数，之后才能被消费。
+const coinbaseMaturity = 3
+
+// 未消费输出记录。
+type utxoEntry struct {
+	Value      int    // 输出的金额。
+	PubkeyHash []byte // 输出的公钥哈希值。
+	Height     int    // 输出所在区块的高度。
+	Coinbase   bool   // 输出是否来自 coinbase 交易。
+	ScriptHash bool   // 输出是否锁定到多重签名赎回脚本，此时 PubkeyHash 为脚本的哈希值。
+	Script     []byte // 输出的自定义锁定脚本，此时 PubkeyHash 为空。
+}
+
+// 由交易输出创建未消费输出记录。
+func newUtxoEntry(txo *transaction.TxOutput, height int, coinbase bool) *utxoEntry {
+	return &utxoEntry{txo.Value, txo.PubkeyHash, height, coinbase, txo.ScriptHash, txo.Script}
+}
+
+// 还原为交易输出。
+func (e *utxoEntry) output() *transaction.TxOutput {
+	return &transaction.TxOutput{Value: e.Value, PubkeyHash: e.PubkeyHash, ScriptHash: e.ScriptHash, Script: e.Script}
+}
+
+// 判断输出是否锁定到指定地址，即哈希值和地址类型都相同。
+func (e *utxoEntry) isLockedTo(pubkeyHash []byte, scriptHash bool) bool {
+	return len(e.Script) == 0 && e.ScriptHash == scriptHash && bytes.Equal(e.PubkeyHash, pubkeyHash)
+}
+
+// 判断输出能否被高度为 spendHeight 的区块内的交易消费。
+// 普通输出总是可以；coinbase 输出需要等待 coinbaseMaturity 个区块。
+func (e *utxoEntry) isMature(spendHeight int) bool {
+	return !e.Coinbase || spendHeight-e.Height >= coinbaseMaturity
+}
+
+// 序列化未消费输出记录。
+func (e *utxoEntry) serialize() []byte {
+	var seq bytes.Buffer
+
+	encoder := gob.NewEncoder(&seq)
+	err := encoder.Encode(e)
+	if err != nil {
+		panic(err)
+	}
+
+	return seq.Bytes()
+}
+
+// 反序列化未消费输出记录。
+func deserializeUtxoEntry(seq []byte) *utxoEntry {
+	var entry utxoEntry
+
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	err := decoder.Decode(&entry)
+	if err != nil {
+		panic(err)
+	}
+
+	return &entry
+}
+
+// 生成未消费输出在数据库中的键。
+func utxoKey(txID []byte, index int) []byte {
+	return append(append([]byte{}, txID...), utils.Int64ToBytes(int64(index))...)
+}
+
+// 从数据库的键中解析出交易 ID 和输出索引。
+func parseUtxoKey(key []byte) ([]byte, int) {
+	split := len(key) - 8
+	return key[:split], int(binary.BigEndian.Uint64(key[split:]))
+}
+
+// 判断交易是否还有未消费输出，无论索引。键以交易 ID 开头，找到第一个不小于它的键即可。
+func hasUtxos(bucket *bolt.Bucket, txID []byte) bool {
+	key, _ := bucket.Cursor().Seek(txID)
+	return key != nil && len(key) == len(txID)+8 && bytes.HasPrefix(key, txID)
+}
+
+// 获取未消费输出记录，不存在时返回 nil。
+func getUtxo(bucket *bolt.Bucket, txID []byte, index int) *utxoEntry {
+	seq := bucket.Get(utxoKey(txID, index))
+	if seq == nil {
+		return nil
+	}
+	return deserializeUtxoEntry(seq)
+}
+
+// 消费一笔未消费输出：从集合中删除，并返回它的记录。
+func spendUtxo(bucket *bolt.Bucket, txID []byte, index int) (*utxoEntry, error) {
+	entry := getUtxo(bucket, txID, index)
+	if entry == nil {
+		return nil, fmt.Errorf("output %s is not unspent", outpointKey(txID, index))
+	}
+	return entry, bucket.Delete(utxoKey(txID, index))
+}
+
+// 将交易的输出加入未消费输出集。无法消费的数据输出不加入。
+func addTxOutputs(bucket *bolt.Bucket, tx *transaction.Transaction, height int) error {
+	for txoIndex, txo := range tx.Outputs {
+		if txo.IsUnspendable() {
+			continue
+		}
+		err := bucket.Put(utxoKey(tx.ID, txoIndex), newUtxoEntry(txo, height, tx.IsCoinbase()).serialize())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从未消费输出集中删除交易的全部输出。
+func removeTxOutputs(bucket *bolt.Bucket, tx *transaction.Transaction) error {
+	for txoIndex := range tx.Outputs {
+		err := bucket.Delete(utxoKey(tx.ID, txoIndex))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 读出未消费输出记录，不存在时为 nil。
+func utxoOf(t *testing.T, c *Chain, txID []byte, index int) *utxoEntry {
+	t.Helper()
+	var entry *utxoEntry
+	err := c.db.View(func(t *bolt.Tx) error {
+		entry = getUtxo(t.Bucket([]byte(utxoBucket)), txID, index)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+// 交易的一个输出被消费后，其余输出按原索引保留；回滚区块时被消费的输出连同高度等信息一并恢复。
+func TestUtxoPartialSpendAndRollback(t *testing.T) {
+	chain, alice, bob := newFundedChain(t)
+
+	payment := chain.NewUtxoTx(alice, bob, 3, 1, 0, 0)
+	if err := chain.SubmitTx(payment); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, alice, 1)
+	paymentHeight := chain.Height()
+
+	bobHash := transaction.NewTxo(0, bob).PubkeyHash
+	toBob, change := 0, 1
+	if !bytes.Equal(payment.Outputs[0].PubkeyHash, bobHash) {
+		toBob, change = 1, 0
+	}
+	aliceHash := payment.Outputs[change].PubkeyHash
+
+	// 每个输出一条记录，带有所在区块的高度和 coinbase 标记。
+	for _, index := range []int{toBob, change} {
+		entry := utxoOf(t, chain, payment.ID, index)
+		if entry == nil {
+			t.Fatalf("output %d of the payment is missing", index)
+		}
+		if entry.Value != payment.Outputs[index].Value || entry.Height != paymentHeight || entry.Coinbase {
+			t.Errorf("output %d: %+v, want value %d at height %d", index, entry, payment.Outputs[index].Value, paymentHeight)
+		}
+	}
+	for _, txi := range payment.Inputs {
+		if utxoOf(t, chain, txi.RefID, txi.RefIndex) != nil {
+			t.Errorf("input %s is still unspent", outpointKey(txi.RefID, txi.RefIndex))
+		}
+	}
+	tip, err := chain.BlockByHash(chain.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := utxoOf(t, chain, tip.Transactions[0].ID, 0)
+	if coinbase == nil || !coinbase.Coinbase || coinbase.Height != paymentHeight {
+		t.Fatalf("coinbase output: %+v", coinbase)
+	}
+	if coinbase.isMature(paymentHeight+coinbaseMaturity-1) || !coinbase.isMature(paymentHeight+coinbaseMaturity) {
+		t.Errorf("coinbase at height %d matures at the wrong height", paymentHeight)
+	}
+
+	// Bob 只消费付款交易中属于他的输出，找零输出按原索引留给 Alice。
+	spentEntry := utxoOf(t, chain, payment.ID, toBob).serialize()
+	refund := chain.NewUtxoTx(bob, alice, 1, 1, 0, 0)
+	if err := chain.SubmitTx(refund); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, alice, 1)
+
+	if utxoOf(t, chain, payment.ID, toBob) != nil {
+		t.Fatal("spent output is still unspent")
+	}
+	if utxoOf(t, chain, payment.ID, change) == nil {
+		t.Fatal("unspent change was removed with the spent output")
+	}
+	_, toPay := chain.FindUtxosToPay(aliceHash, false, 1)
+	if indexes := toPay[hex.EncodeToString(payment.ID)]; len(indexes) != 1 || indexes[0] != change {
+		t.Errorf("payable outputs of the payment: %v, want [%d]", indexes, change)
+	}
+
+	// 在事务内回滚尾部区块，检查后丢弃事务。
+	errDiscard := errors.New("discard")
+	err = chain.db.Update(func(t *bolt.Tx) error {
+		bucket := t.Bucket([]byte(utxoBucket))
+		if err := disconnectBlock(t, getBlock(t, chain.Tip())); err != nil {
+			return err
+		}
+		if restored := getUtxo(bucket, payment.ID, toBob); restored == nil || !bytes.Equal(restored.serialize(), spentEntry) {
+			return errors.New("spent output was not restored with its height and value")
+		}
+		if getUtxo(bucket, payment.ID, change) == nil {
+			return errors.New("rollback removed the change output")
+		}
+		if hasUtxos(bucket, refund.ID) {
+			return errors.New("outputs of the rolled back transaction are still unspent")
+		}
+		return errDiscard
+	})
+	if err != errDiscard {
+		t.Fatal(err)
+	}
+	if utxoOf(t, chain, payment.ID, toBob) != nil {
+		t.Fatal("discarded rollback changed the utxo set")
+	}
+}
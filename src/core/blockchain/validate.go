@@ -0,0 +1,396 @@
+package blockchain
+
+import (
+	"blockchain/core/block"
+	"blockchain/core/transaction"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 区块链校验错误。
+type ValidationError struct {
+	Height    int    // 出错区块的高度。
+	BlockHash []byte // 出错区块的哈希值。
+	TxID      []byte // 出错交易的 ID，区块层面的错误为空。
+	Reason    string // 出错原因。
+}
+
+// 格式化校验错误。
+func (e *ValidationError) Error() string {
+	if e.TxID == nil {
+		return fmt.Sprintf("block %d (%x): %s", e.Height, e.BlockHash, e.Reason)
+	}
+	return fmt.Sprintf("block %d (%x), transaction %x: %s", e.Height, e.BlockHash, e.TxID, e.Reason)
+}
+
+// 交易输出的视图，用于在校验时查询被引用的输出。
+type txoView interface {
+	// 获取未消费的交易输出，不存在或已被消费时返回 nil。
+	unspent(refID []byte, refIndex int) *utxoEntry
+	// 判断交易是否还有未消费的输出，无论索引。
+	hasUnspent(txID []byte) bool
+	// 获取被引用的交易。
+	refTx(refID []byte) *transaction.Transaction
+	// 获取被校验的交易所在区块的高度，用于判断 coinbase 输出是否成熟。
+	spendHeight() int
+	// 获取被校验的分支上指定高度处区块的过去中位时间，高度小于 0 时为 0。
+	medianTimePast(height int) int64
+}
+
+// 内存中的交易输出视图，在重放区块链时独立构建。
+type replayView struct {
+	utxos  map[string]*utxoEntry               // "交易ID:索引" - 未消费输出。
+	counts map[string]int                      // 交易ID - 未消费输出的数量。
+	txs    map[string]*transaction.Transaction // 交易ID - 交易。
+	times  []int64                             // 已应用的区块的时间戳，按高度排列。
+	next   int                                 // 下一个区块的高度。
+}
+
+// 创建空的内存视图。
+func newReplayView() *replayView {
+	return &replayView{
+		utxos:  make(map[string]*utxoEntry),
+		counts: make(map[string]int),
+		txs:    make(map[string]*transaction.Transaction),
+	}
+}
+
+// 获取未消费的交易输出。
+func (v *replayView) unspent(refID []byte, refIndex int) *utxoEntry {
+	return v.utxos[outpointKey(refID, refIndex)]
+}
+
+// 判断交易是否还有未消费的输出。
+func (v *replayView) hasUnspent(txID []byte) bool {
+	return v.counts[hex.EncodeToString(txID)] > 0
+}
+
+// 获取被引用的交易。
+func (v *replayView) refTx(refID []byte) *transaction.Transaction {
+	return v.txs[hex.EncodeToString(refID)]
+}
+
+// 获取下一个区块的高度。
+func (v *replayView) spendHeight() int {
+	return v.next
+}
+
+// 获取指定高度处区块的过去中位时间。
+func (v *replayView) medianTimePast(height int) int64 {
+	if height < 0 {
+		return 0
+	}
+	start := height + 1 - medianTimeSpan
+	if start < 0 {
+		start = 0
+	}
+	return medianTime(v.times[start : height+1])
+}
+
+// 将指定高度的区块应用到视图上：消费输入，加入可以消费的输出，记录时间戳。
+func (v *replayView) apply(b *block.Block, height int) {
+	for _, tx := range b.Transactions {
+		if !tx.IsCoinbase() {
+			for _, txi := range tx.Inputs {
+				delete(v.utxos, outpointKey(txi.RefID, txi.RefIndex))
+				v.counts[hex.EncodeToString(txi.RefID)]--
+			}
+		}
+		for txoIndex, txo := range tx.Outputs {
+			if !txo.IsUnspendable() {
+				v.utxos[outpointKey(tx.ID, txoIndex)] = newUtxoEntry(txo, height, tx.IsCoinbase())
+				v.counts[hex.EncodeToString(tx.ID)]++
+			}
+		}
+		v.txs[hex.EncodeToString(tx.ID)] = tx
+	}
+	v.times = append(v.times, b.Timestamp)
+	v.next = height + 1
+}
+
+// 基于数据库 utxo bucket 的交易输出视图，用于校验即将上链的交易。
+type chainView struct {
+	chain *Chain
+}
+
+// 获取未消费的交易输出。
+func (v *chainView) unspent(refID []byte, refIndex int) *utxoEntry {
+	var entry *utxoEntry
+	err := v.chain.db.View(func(t *bolt.Tx) error {
+		entry = getUtxo(t.Bucket([]byte(utxoBucket)), refID, refIndex)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return entry
+}
+
+// 判断交易是否还有未消费的输出。
+func (v *chainView) hasUnspent(txID []byte) bool {
+	found := false
+	err := v.chain.db.View(func(t *bolt.Tx) error {
+		found = hasUtxos(t.Bucket([]byte(utxoBucket)), txID)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return found
+}
+
+// 获取被引用的交易，不存在时返回 nil。只有还有未消费输出的交易才能被引用，直接定位所在区块。
+func (v *chainView) refTx(refID []byte) *transaction.Transaction {
+	var tx *transaction.Transaction
+	err := v.chain.db.View(func(t *bolt.Tx) error {
+		tx = unspentTx(t, refID)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+// 获取下一个区块的高度。
+func (v *chainView) spendHeight() int {
+	return v.chain.Height() + 1
+}
+
+// 获取主链上指定高度处区块的过去中位时间。
+func (v *chainView) medianTimePast(height int) int64 {
+	var median int64
+	err := v.chain.db.View(func(t *bolt.Tx) error {
+		tip := getBlock(t, v.chain.rear)
+		median = ancestorMedianTime(t, tip.Hash, heightOf(t, tip)-height)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return median
+}
+
+// 生成交易输出的定位键。
+func outpointKey(txID []byte, index int) string {
+	return fmt.Sprintf("%x:%d", txID, index)
+}
+
+// 从创世块开始重放整条区块链，逐一校验每个区块和每笔交易。
+// 返回遇到的第一个错误；区块链有效时返回 nil。
+func (c *Chain) Validate() error {
+	// 迭代器从尾部开始遍历，因此先收集全部区块再倒序。
+	var blocks []*block.Block
+	iter := c.Iterator()
+	for {
+		curBlock := iter.Next()
+		blocks = append(blocks, curBlock)
+		if len(curBlock.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+
+	view := newReplayView()
+	now := time.Now().Unix()
+	for height, curBlock := range blocks {
+		fail := func(txID []byte, reason string) error {
+			return &ValidationError{height, curBlock.Hash, txID, reason}
+		}
+
+		// 检查区块之间的链接与目标值。
+		var (
+			expectedTarget []byte
+			parentVersion  int
+		)
+		if height == 0 {
+			if len(curBlock.PrevBlockHash) != 0 {
+				return fail(nil, "genesis block references a previous block")
+			}
+			expectedTarget = block.InitialTarget()
+		} else {
+			prevBlock := blocks[height-1]
+			if !bytes.Equal(curBlock.PrevBlockHash, prevBlock.Hash) {
+				return fail(nil, fmt.Sprintf("previous hash %x does not match block %d", curBlock.PrevBlockHash, height-1))
+			}
+			expectedTarget = prevBlock.Target
+			parentVersion = prevBlock.Version
+			if isRetargetHeight(height) {
+				firstBlock := blocks[height-block.RetargetInterval]
+				expectedTarget = block.NextTarget(prevBlock.Target, firstBlock.Timestamp, prevBlock.Timestamp)
+			}
+		}
+		// 升级前录入的区块没有记录高度，高度为 0。
+		if curBlock.Height != height && curBlock.Height != 0 {
+			return fail(nil, fmt.Sprintf("height %d differs from expected %d", curBlock.Height, height))
+		}
+		if !bytes.Equal(curBlock.Target, expectedTarget) {
+			return fail(nil, fmt.Sprintf("target %x differs from expected %x", curBlock.Target, expectedTarget))
+		}
+		if reason := checkHeaderRules(&curBlock.Header, parentVersion, view.medianTimePast(height-1), now); reason != "" {
+			return fail(nil, reason)
+		}
+
+		// 检查工作量证明。哈希值覆盖了交易的 Merkle 树根，交易被篡改时同样无法通过。
+		if !curBlock.VerifyWork() {
+			return fail(nil, "hash does not match header and merkle root, or does not meet target")
+		}
+		if curBlock.HasMutatedMerkle() {
+			return fail(nil, "duplicated transactions in merkle tree")
+		}
+
+		// 检查区块内的交易。
+		if txID, reason := checkBlockTxs(curBlock.Transactions, view); reason != "" {
+			return fail(txID, reason)
+		}
+		view.apply(curBlock, height)
+	}
+
+	return nil
+}
+
+// 校验区块内的交易，即共识规则：
+// 1. 第一笔交易是 coinbase，且只有这一笔是 coinbase；
+// 2. 输出金额非负，单个金额与累计金额都不超过 transaction.MaxMoney；
+// 3. 交易 ID 与交易内容相符，且不与区块内或链上尚有任何未消费输出的交易重复；
+// 4. 输入引用的输出在区块之前已经上链且未被消费，不能是同一区块内其他交易的输出；区块内不重复消费同一输出，coinbase 输出已经成熟；
+// 5. 输入总额不小于输出总额，差额为交易费；
+// 6. 交易格式有效，每一笔输入的脚本执行成功；
+// 7. 交易的锁定时间和输入的相对锁定时间已经到达；
+// 8. coinbase 的输出总额不超过出块奖励与区块内交易费之和。
+// 返回出错交易的 ID 和原因；全部有效时原因为空。
+func checkBlockTxs(txs []*transaction.Transaction, view txoView) ([]byte, string) {
+	if len(txs) == 0 {
+		return nil, "block has no transactions"
+	}
+
+	fees := 0
+	spent := make(map[string]bool)
+	seen := make(map[string]bool)
+	for txIndex, tx := range txs {
+		if err := tx.CheckFormat(); err != nil {
+			return tx.ID, err.Error()
+		}
+		if view.hasUnspent(tx.ID) {
+			return tx.ID, "transaction id collides with an unspent transaction"
+		}
+		if seen[hex.EncodeToString(tx.ID)] {
+			return tx.ID, "transaction appears twice in the block"
+		}
+		seen[hex.EncodeToString(tx.ID)] = true
+
+		if tx.IsCoinbase() {
+			if txIndex != 0 {
+				return tx.ID, "coinbase transaction is not the first transaction"
+			}
+			continue
+		}
+		if txIndex == 0 {
+			return tx.ID, "first transaction is not a coinbase"
+		}
+
+		fee, reason := checkTx(tx, view, spent)
+		if reason == "" {
+			reason = checkLockTimes(tx, view)
+		}
+		if reason != "" {
+			return tx.ID, reason
+		}
+		var err error
+		fees, err = transaction.AddMoney(fees, fee)
+		if err != nil {
+			return tx.ID, fmt.Sprintf("block fees: %v", err)
+		}
+	}
+
+	reward, err := sumOutputs(txs[0])
+	if err != nil {
+		return txs[0].ID, err.Error()
+	}
+	maxReward, err := transaction.AddMoney(subsidy, fees)
+	if err != nil {
+		return txs[0].ID, fmt.Sprintf("block reward: %v", err)
+	}
+	if reward > maxReward {
+		return txs[0].ID, fmt.Sprintf("coinbase pays %d, more than subsidy %d plus fees %d", reward, subsidy, fees)
+	}
+
+	return nil, ""
+}
+
+// 校验单笔非 coinbase 交易：输入引用的输出存在且未被消费、不与 spent 中已消费的输出重复、
+// 引用的 coinbase 输出已经成熟、金额在有效范围内、输入总额不小于输出总额，以及交易格式有效、脚本执行成功。
+// 整笔交易通过校验后，其输入才会被记入 spent，无效交易不影响之后的交易。返回交易费和出错原因；交易有效时原因为空。
+func checkTx(tx *transaction.Transaction, view txoView, spent map[string]bool) (int, string) {
+	if len(tx.Inputs) == 0 {
+		return 0, "transaction has no inputs"
+	}
+	if err := tx.CheckFormat(); err != nil {
+		return 0, err.Error()
+	}
+
+	inputSum := 0
+	refTxs := make(map[string]*transaction.Transaction)
+	txSpent := make(map[string]bool)
+	for _, txi := range tx.Inputs {
+		key := outpointKey(txi.RefID, txi.RefIndex)
+		if spent[key] || txSpent[key] {
+			return 0, fmt.Sprintf("output %s is spent twice", key)
+		}
+		txSpent[key] = true
+
+		utxo := view.unspent(txi.RefID, txi.RefIndex)
+		if utxo == nil {
+			return 0, fmt.Sprintf("output %s does not exist or is already spent", key)
+		}
+		if !utxo.isMature(view.spendHeight()) {
+			return 0, fmt.Sprintf("coinbase output %s is spent before %d confirmations", key, coinbaseMaturity)
+		}
+		var err error
+		inputSum, err = transaction.AddMoney(inputSum, utxo.Value)
+		if err != nil {
+			return 0, fmt.Sprintf("inputs: %v", err)
+		}
+		refTx := view.refTx(txi.RefID)
+		if refTx == nil {
+			return 0, fmt.Sprintf("transaction %x referenced by output %s not found", txi.RefID, key)
+		}
+		refTxs[hex.EncodeToString(txi.RefID)] = refTx
+	}
+
+	outputSum, err := sumOutputs(tx)
+	if err != nil {
+		return 0, err.Error()
+	}
+	if outputSum > inputSum {
+		return 0, fmt.Sprintf("outputs (%d) exceed inputs (%d)", outputSum, inputSum)
+	}
+
+	if err := tx.Verify(refTxs); err != nil {
+		return 0, err.Error()
+	}
+
+	for key := range txSpent {
+		spent[key] = true
+	}
+	return inputSum - outputSum, ""
+}
+
+// 计算交易的输出总额。金额为负数或累计超过 transaction.MaxMoney 时返回错误。
+func sumOutputs(tx *transaction.Transaction) (int, error) {
+	sum := 0
+	for txoIndex, txo := range tx.Outputs {
+		var err error
+		sum, err = transaction.AddMoney(sum, txo.Value)
+		if err != nil {
+			return 0, fmt.Errorf("output %d: %v", txoIndex, err)
+		}
+	}
+	return sum, nil
+}
@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"blockchain/core/transaction"
+	"strings"
+	"testing"
+)
+
+// 无效交易的输入不记入已消费的输出，之后消费同一输出的有效交易不受影响。
+func TestCheckTxRecordsSpendsOnlyWhenValid(t *testing.T) {
+	chain, alice, bob := newFundedChain(t)
+
+	valid := chain.NewUtxoTx(alice, bob, 3, 1, 0, 0)
+	forged := transaction.DeserializeTransaction(valid.Serialize())
+	forged.Outputs[0].Value++
+	forged.ID = forged.ComputeID()
+
+	view := &chainView{chain}
+	spent := make(map[string]bool)
+	if _, reason := checkTx(forged, view, spent); reason == "" {
+		t.Fatal("transaction with altered outputs passed")
+	}
+	if len(spent) != 0 {
+		t.Fatalf("invalid transaction recorded %d spent outputs", len(spent))
+	}
+	if _, reason := checkTx(valid, view, spent); reason != "" {
+		t.Fatalf("valid transaction after an invalid one: %s", reason)
+	}
+	if len(spent) != len(valid.Inputs) {
+		t.Fatalf("valid transaction recorded %d spent outputs, want %d", len(spent), len(valid.Inputs))
+	}
+	if _, reason := checkTx(valid, view, spent); reason == "" {
+		t.Fatal("the same outputs were spent twice")
+	}
+}
+
+// 区块内交易的共识规则：coinbase 的位置与金额、重复交易、区块内的双花、金额守恒与输出成熟度。
+func TestCheckBlockTxs(t *testing.T) {
+	chain, alice, bob := newFundedChain(t)
+
+	// 由有效交易改出的交易，重新计算 ID，使改动在 ID 校验之外的规则上暴露。
+	forge := func(tx *transaction.Transaction, change func(*transaction.Transaction)) *transaction.Transaction {
+		forged := transaction.DeserializeTransaction(tx.Serialize())
+		change(forged)
+		forged.ID = forged.ComputeID()
+		return forged
+	}
+
+	payment := chain.NewUtxoTx(alice, bob, 3, 1, 0, 0)
+	// 与 payment 消费相同的输出。
+	conflict := chain.NewUtxoTx(alice, bob, 4, 1, 0, 0)
+	tip, err := chain.BlockByHash(chain.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := func(fees int) *transaction.Transaction { return NewCoinbaseTx(alice, "", fees) }
+	txs := func(list ...*transaction.Transaction) []*transaction.Transaction { return list }
+
+	tests := []struct {
+		name string
+		txs  []*transaction.Transaction
+		err  string // 期望的原因片段，为空时期望有效。
+	}{
+		{"valid", txs(coinbase(1), payment), ""},
+		{"coinbase only", txs(coinbase(0)), ""},
+		{"coinbase keeps less than fees", txs(coinbase(0), payment), ""},
+		{"empty block", nil, "no transactions"},
+		{"no coinbase", txs(payment), "first transaction is not a coinbase"},
+		{"second coinbase", txs(coinbase(1), payment, coinbase(0)), "coinbase transaction is not the first"},
+		{"coinbase pays more than subsidy and fees", txs(coinbase(2), payment), "more than subsidy"},
+		{"duplicate transaction", txs(coinbase(2), payment, payment), "appears twice"},
+		{"double spend within the block", txs(coinbase(2), payment, conflict), "spent twice"},
+		{"outputs exceed inputs", txs(coinbase(0), forge(payment, func(tx *transaction.Transaction) {
+			tx.Outputs[0].Value += 1000
+		})), "exceed inputs"},
+		{"negative output", txs(coinbase(0), forge(payment, func(tx *transaction.Transaction) {
+			tx.Outputs[0].Value = -1
+		})), "out of range"},
+		{"missing output", txs(coinbase(0), forge(payment, func(tx *transaction.Transaction) {
+			tx.Inputs[0].RefIndex = 99
+		})), "does not exist"},
+		{"immature coinbase", txs(coinbase(0), forge(payment, func(tx *transaction.Transaction) {
+			tx.Inputs[0].RefID, tx.Inputs[0].RefIndex = tip.Transactions[0].ID, 0
+		})), "confirmations"},
+		{"altered id", txs(coinbase(0), func() *transaction.Transaction {
+			forged := forge(payment, func(*transaction.Transaction) {})
+			forged.ID = conflict.ID
+			return forged
+		}()), "does not match"},
+	}
+	for _, test := range tests {
+		_, reason := checkBlockTxs(test.txs, &chainView{chain})
+		switch {
+		case test.err == "" && reason != "":
+			t.Errorf("%s: %s", test.name, reason)
+		case test.err != "" && reason == "":
+			t.Errorf("%s: passed, want %q", test.name, test.err)
+		case test.err != "" && !strings.Contains(reason, test.err):
+			t.Errorf("%s: %q, want %q", test.name, reason, test.err)
+		}
+	}
+
+	// 已上链交易消费过的输出不能再被消费。
+	if err := chain.SubmitTx(payment); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, alice, 1)
+	if _, reason := checkBlockTxs(txs(coinbase(1), conflict), &chainView{chain}); !strings.Contains(reason, "already spent") {
+		t.Errorf("spending confirmed outputs again: %q", reason)
+	}
+}
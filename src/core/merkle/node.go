@@ -3,25 +3,28 @@ package merkle
 import "crypto/sha256"
 
 // Merkle 树结点结构。
-type merkleNode struct {
-	Left  *merkleNode // 左结点。
-	Right *merkleNode // 右结点。
-	Data  []byte      // 数据。
+type Node struct {
+	Left  *Node  // 左结点。
+	Right *Node  // 右结点。
+	Data  []byte // 数据。
 }
 
-// 创建 Merkle 树结点。
-func newMerkleNode(left, right *merkleNode, data []byte) *merkleNode {
-	node := merkleNode{}
+// 底层结点与上层结点哈希值的前缀，只在区分结点层次的树中使用。
+const (
+	leafPrefix     = 0x00
+	interiorPrefix = 0x01
+)
+
+// 创建 Merkle 树结点。tagged 为 true 时区分底层与上层结点，见 hashLeaf 和 hashPair。
+func newMerkleNode(left, right *Node, data []byte, tagged bool) *Node {
+	node := Node{}
 
 	// 生成该结点哈希值。
-	var hash [32]byte
 	if left == nil && right == nil {
-		hash = sha256.Sum256(data)
+		node.Data = hashLeaf(data, tagged)
 	} else {
-		prevHashes := append(left.Data, right.Data...)
-		hash = sha256.Sum256(prevHashes)
+		node.Data = hashPair(left.Data, right.Data, tagged)
 	}
-	node.Data = hash[:]
 
 	// 绑定左右结点。
 	node.Left = left
@@ -29,3 +32,24 @@ func newMerkleNode(left, right *merkleNode, data []byte) *merkleNode {
 
 	return &node
 }
+
+// 计算底层结点的哈希值。tagged 为 true 时在数据前附加 leafPrefix。
+func hashLeaf(data []byte, tagged bool) []byte {
+	if tagged {
+		data = append([]byte{leafPrefix}, data...)
+	}
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// 计算左右两个结点合并后的哈希值。tagged 为 true 时在两个哈希值前附加 interiorPrefix，
+// 64 字节的数据因此无法冒充两个结点的合并，证明也无法在数据与上层结点之间混用。
+func hashPair(left, right []byte, tagged bool) []byte {
+	var prevHashes []byte
+	if tagged {
+		prevHashes = append(prevHashes, interiorPrefix)
+	}
+	prevHashes = append(append(prevHashes, left...), right...)
+	hash := sha256.Sum256(prevHashes)
+	return hash[:]
+}
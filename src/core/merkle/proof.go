@@ -0,0 +1,40 @@
+package merkle
+
+import "bytes"
+
+// Merkle 包含证明：数据在底层的位置，以及自底向上路径上每一层兄弟结点的哈希值。
+// 位置的每一个二进制位依次表示该层的结点是左结点（0）还是右结点（1）。
+type Proof struct {
+	Index    int      // 数据在底层的位置。
+	Siblings [][]byte // 自底向上各层兄弟结点的哈希值。
+}
+
+// 验证数据 leaf 包含在根结点值为 root、由 NewMerkleTree 创建的 Merkle 树中。只需要证明，无需其他数据。
+func VerifyProof(root []byte, leaf []byte, proof *Proof) bool {
+	return verifyProof(root, leaf, proof, false)
+}
+
+// 验证数据 leaf 包含在根结点值为 root、由 NewTaggedMerkleTree 创建的 Merkle 树中。
+func VerifyTaggedProof(root []byte, leaf []byte, proof *Proof) bool {
+	return verifyProof(root, leaf, proof, true)
+}
+
+// 沿证明的路径计算根结点值并比较，tagged 表示是否区分底层与上层结点。
+func verifyProof(root []byte, leaf []byte, proof *Proof, tagged bool) bool {
+	// 位置超出路径长度所能表示的范围时，同一份证明可以冒充多个位置。
+	if proof == nil || proof.Index < 0 || proof.Index>>uint(len(proof.Siblings)) != 0 {
+		return false
+	}
+
+	hash := hashLeaf(leaf, tagged)
+	pos := proof.Index
+	for _, sibling := range proof.Siblings {
+		if pos%2 == 0 {
+			hash = hashPair(hash, sibling, tagged)
+		} else {
+			hash = hashPair(sibling, hash, tagged)
+		}
+		pos /= 2
+	}
+	return bytes.Equal(hash, root)
+}
@@ -1,36 +1,94 @@
 package merkle
 
+import (
+	"bytes"
+	"fmt"
+)
+
+// 空 Merkle 树的根结点值：32 个零字节。
+var emptyRoot = make([]byte, 32)
+
 // Merkle 树结构。
-type merkleTree struct {
-	Root *merkleNode // 根结点。
+// 构建规则：自底向上逐层将结点两两合并，某一层结点数为奇数时复制该层最后一个结点与自身合并；
+// 底层只有一个结点时同样与自身合并，因此根结点总是由左右两个结点合并而成。没有数据时根结点值为 emptyRoot。
+// 复制奇数结点使得 [a, b, c] 与 [a, b, c, c] 的根结点值相同，攻击者可以借此伪造同一哈希值的无效区块（CVE-2012-2459）。
+// 因此构建时记录是否有两个不同位置的相邻结点相同，由调用方拒绝这样的数据。
+type Tree struct {
+	Root    *Node     // 根结点。
+	leaves  int       // 数据的份数。
+	levels  [][]*Node // 自底向上各层补齐为偶数后的结点，用于生成包含证明。
+	mutated bool      // 是否有两个不同位置的相邻结点相同。
+	tagged  bool      // 是否区分底层与上层结点。
+}
+
+// 创建 Merkle 树。结点直接对数据或两个子结点取哈希值，与升级前的区块相同。
+func NewMerkleTree(dataList [][]byte) *Tree {
+	return newTree(dataList, false)
 }
 
-// 创建 Merkle 树。
-func NewMerkleTree(dataList [][]byte) *merkleTree {
-	var baseNodes []merkleNode
+// 创建区分底层与上层结点的 Merkle 树：底层结点的数据前附加 0x00，上层结点前附加 0x01。
+// 除哈希值外，构建规则与 NewMerkleTree 相同。
+func NewTaggedMerkleTree(dataList [][]byte) *Tree {
+	return newTree(dataList, true)
+}
 
-	// 如果有奇数份数据，就复制最后一份数据。
-	if len(dataList)%2 != 0 {
-		dataList = append(dataList, dataList[len(dataList)-1])
+// 创建 Merkle 树，tagged 表示是否区分底层与上层结点。
+func newTree(dataList [][]byte, tagged bool) *Tree {
+	tree := &Tree{leaves: len(dataList), tagged: tagged}
+	if len(dataList) == 0 {
+		tree.Root = &Node{Data: emptyRoot}
+		return tree
 	}
 
 	// 为每一份数据创建其 Merkle 树底层结点。
+	var baseNodes []*Node
 	for _, data := range dataList {
-		node := newMerkleNode(nil, nil, data)
-		baseNodes = append(baseNodes, *node)
+		baseNodes = append(baseNodes, newMerkleNode(nil, nil, data, tagged))
 	}
 
-	// 逐层创建上层结点。
-	for level := 0; level < len(dataList)/2; level++ {
-		var newLevel []merkleNode
+	// 逐层创建上层结点，直到只剩根结点。
+	for len(tree.levels) == 0 || len(baseNodes) > 1 {
+		// 检查两个不同位置的相邻结点是否相同，须在复制奇数结点之前进行。
+		for index := 0; index+1 < len(baseNodes); index += 2 {
+			if bytes.Equal(baseNodes[index].Data, baseNodes[index+1].Data) {
+				tree.mutated = true
+			}
+		}
+
+		// 如果有奇数个结点，就复制最后一个结点。
+		if len(baseNodes)%2 != 0 {
+			baseNodes = append(baseNodes, baseNodes[len(baseNodes)-1])
+		}
+		tree.levels = append(tree.levels, baseNodes)
 
 		// 将当前底层结点两两合并。
+		var newLevel []*Node
 		for index := 0; index < len(baseNodes); index += 2 {
-			node := newMerkleNode(&baseNodes[index], &baseNodes[index+1], nil)
-			newLevel = append(newLevel, *node)
+			newLevel = append(newLevel, newMerkleNode(baseNodes[index], baseNodes[index+1], nil, tagged))
 		}
 		baseNodes = newLevel
 	}
 
-	return &merkleTree{&baseNodes[0]}
+	tree.Root = baseNodes[0]
+	return tree
+}
+
+// 判断是否有两个不同位置的相邻结点相同，即数据可能被复制过以伪造同一根结点值。
+func (t *Tree) Mutated() bool {
+	return t.mutated
+}
+
+// 获取第 index 份数据的包含证明：从底层到根结点的路径上，每一层兄弟结点的哈希值。
+func (t *Tree) Proof(index int) (*Proof, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, fmt.Errorf("leaf index %d out of range [0, %d)", index, t.leaves)
+	}
+
+	proof := &Proof{Index: index}
+	pos := index
+	for _, level := range t.levels {
+		proof.Siblings = append(proof.Siblings, level[pos^1].Data)
+		pos /= 2
+	}
+	return proof, nil
 }
@@ -0,0 +1,215 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+// 两种 Merkle 树：创建函数、验证函数，以及是否区分底层与上层结点。
+var treeKinds = []struct {
+	name   string
+	build  func([][]byte) *Tree
+	verify func([]byte, []byte, *Proof) bool
+	tagged bool
+}{
+	{"legacy", NewMerkleTree, VerifyProof, false},
+	{"tagged", NewTaggedMerkleTree, VerifyTaggedProof, true},
+}
+
+// 按构建规则逐层计算根结点值，作为对照。tagged 为 true 时底层结点前缀 0x00，上层结点前缀 0x01。
+func referenceRoot(leaves [][]byte, tagged bool) []byte {
+	if len(leaves) == 0 {
+		return make([]byte, 32)
+	}
+	var leafPrefix, interiorPrefix []byte
+	if tagged {
+		leafPrefix, interiorPrefix = []byte{0}, []byte{1}
+	}
+
+	var level [][]byte
+	for _, leaf := range leaves {
+		hash := sha256.Sum256(append(append([]byte{}, leafPrefix...), leaf...))
+		level = append(level, hash[:])
+	}
+	for first := true; first || len(level) > 1; first = false {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		var next [][]byte
+		for index := 0; index < len(level); index += 2 {
+			pair := append(append(append([]byte{}, interiorPrefix...), level[index]...), level[index+1]...)
+			hash := sha256.Sum256(pair)
+			next = append(next, hash[:])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// 生成 n 份互不相同的数据。
+func makeLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for index := range leaves {
+		leaves[index] = []byte(fmt.Sprintf("leaf %d", index))
+	}
+	return leaves
+}
+
+// 复制末尾的数据，使得最低的奇数层被补齐为偶数：得到根结点值相同的另一组数据。
+// 只有一份数据时它与自身合并，同样可以复制。各层都是偶数时无法这样伪造，返回 nil。
+func duplicateTail(leaves [][]byte) [][]byte {
+	width := 1
+	count := len(leaves)
+	for count > 1 && count%2 == 0 {
+		count /= 2
+		width *= 2
+	}
+	if len(leaves) == 0 || count == 1 && width > 1 {
+		return nil
+	}
+	mutated := append([][]byte{}, leaves...)
+	return append(mutated, leaves[len(leaves)-width:]...)
+}
+
+func TestNewMerkleTree(t *testing.T) {
+	tests := []struct {
+		leaves  int
+		levels  int  // 根结点之下的层数。
+		mutable bool // 能否复制末尾的数据伪造同一根结点值。
+	}{
+		{0, 0, false},
+		{1, 1, true},
+		{2, 1, false},
+		{3, 2, true},
+		{5, 3, true},
+		{6, 3, true},
+		{7, 3, true},
+		{8, 3, false},
+		{9, 4, true},
+	}
+
+	for _, kind := range treeKinds {
+		for _, test := range tests {
+			kind, test := kind, test
+			t.Run(fmt.Sprintf("%s/%d leaves", kind.name, test.leaves), func(t *testing.T) {
+				leaves := makeLeaves(test.leaves)
+				tree := kind.build(leaves)
+
+				if want := referenceRoot(leaves, kind.tagged); !bytes.Equal(tree.Root.Data, want) {
+					t.Fatalf("root = %x, want %x", tree.Root.Data, want)
+				}
+				if len(tree.levels) != test.levels {
+					t.Errorf("tree has %d levels, want %d", len(tree.levels), test.levels)
+				}
+				if tree.Mutated() {
+					t.Error("distinct leaves reported as mutated")
+				}
+
+				// 每一份数据的包含证明都能通过验证，换成其他数据或其他根结点值则不能。
+				for index, leaf := range leaves {
+					proof, err := tree.Proof(index)
+					if err != nil {
+						t.Fatalf("proof %d: %v", index, err)
+					}
+					if !kind.verify(tree.Root.Data, leaf, proof) {
+						t.Errorf("proof %d does not verify", index)
+					}
+					if kind.verify(tree.Root.Data, []byte("other leaf"), proof) {
+						t.Errorf("proof %d verifies a different leaf", index)
+					}
+					if kind.verify(emptyRoot, leaf, proof) {
+						t.Errorf("proof %d verifies against a different root", index)
+					}
+				}
+				if _, err := tree.Proof(test.leaves); err == nil {
+					t.Errorf("proof for index %d out of range succeeded", test.leaves)
+				}
+				if _, err := tree.Proof(-1); err == nil {
+					t.Error("proof for index -1 succeeded")
+				}
+
+				duplicated := duplicateTail(leaves)
+				if (duplicated != nil) != test.mutable {
+					t.Fatalf("duplicateTail returned %d leaves, mutable = %v", len(duplicated), test.mutable)
+				}
+				if duplicated == nil {
+					return
+				}
+
+				// 复制末尾的数据得到相同的根结点值，必须被识别出来。
+				mutated := kind.build(duplicated)
+				if !bytes.Equal(mutated.Root.Data, tree.Root.Data) {
+					t.Fatalf("duplicating the tail changed the root to %x", mutated.Root.Data)
+				}
+				if !mutated.Mutated() {
+					t.Error("duplicated leaves not reported as mutated")
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyProofRejectsAliasedIndex(t *testing.T) {
+	leaves := makeLeaves(4)
+	tree := NewMerkleTree(leaves)
+	proof, err := tree.Proof(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 超出路径长度的位置与原位置走同一条路径，不能冒充其他位置。
+	aliased := &Proof{Index: proof.Index + 1<<uint(len(proof.Siblings)), Siblings: proof.Siblings}
+	if VerifyProof(tree.Root.Data, leaves[1], aliased) {
+		t.Error("proof with an aliased index verifies")
+	}
+	if VerifyProof(tree.Root.Data, leaves[1], nil) {
+		t.Error("nil proof verifies")
+	}
+}
+
+// 根结点的两个子结点拼接成的 64 字节数据，配上空路径，在不区分结点层次的树中可以冒充数据；
+// 区分结点层次后则不能。
+func TestTaggedTreeRejectsInteriorNodeAsLeaf(t *testing.T) {
+	for _, kind := range treeKinds {
+		tree := kind.build(makeLeaves(4))
+		forged := append(append([]byte{}, tree.Root.Left.Data...), tree.Root.Right.Data...)
+
+		got := kind.verify(tree.Root.Data, forged, &Proof{Index: 0})
+		if want := !kind.tagged; got != want {
+			t.Errorf("%s tree: concatenated child nodes verify as a leaf = %v, want %v", kind.name, got, want)
+		}
+	}
+}
+
+func FuzzNewMerkleTree(f *testing.F) {
+	f.Add([]byte("abcdefgh"), uint8(3))
+	f.Add([]byte{}, uint8(1))
+	f.Add([]byte("aaaa"), uint8(4))
+
+	f.Fuzz(func(t *testing.T, data []byte, count uint8) {
+		// 将 data 切分为 count 份数据，允许为空或彼此相同。
+		n := int(count) % 64
+		leaves := make([][]byte, n)
+		for index := range leaves {
+			leaves[index] = data[index*len(data)/n : (index+1)*len(data)/n]
+		}
+
+		for _, kind := range treeKinds {
+			tree := kind.build(leaves)
+			if want := referenceRoot(leaves, kind.tagged); !bytes.Equal(tree.Root.Data, want) {
+				t.Fatalf("%s root = %x, want %x", kind.name, tree.Root.Data, want)
+			}
+			for index, leaf := range leaves {
+				proof, err := tree.Proof(index)
+				if err != nil {
+					t.Fatalf("%s proof %d: %v", kind.name, index, err)
+				}
+				if !kind.verify(tree.Root.Data, leaf, proof) {
+					t.Fatalf("%s proof %d of %d leaves does not verify", kind.name, index, n)
+				}
+			}
+		}
+	})
+}
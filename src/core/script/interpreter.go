@@ -0,0 +1,388 @@
+package script
+
+import (
+	"blockchain/utils"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// 栈的最大深度。
+const maxStackSize = 1000
+
+// 锁定时间最多占用的字节数。
+const maxLockTimeSize = 5
+
+// 脚本执行结果为假。
+var ErrFalse = errors.New("script evaluated to false")
+
+// 执行脚本时用于校验签名和锁定时间的接口，由交易实现。
+type Checker interface {
+	// 判断签名对当前交易输入、用指定公钥是否有效。
+	CheckSig(signature []byte, pubkey []byte) bool
+	// 判断锁定时间是否已经到达。
+	CheckLockTime(lockTime int64) bool
+}
+
+// 校验解锁脚本能否解锁锁定脚本。
+// 先执行只压入数据的解锁脚本，再在得到的栈上执行锁定脚本，栈顶为真即成功；
+// 锁定脚本为 P2SH 时，还要在解锁脚本得到的栈上执行其最后一项，即赎回脚本。
+func Verify(unlocking []byte, locking []byte, checker Checker) error {
+	if !IsPushOnly(unlocking) {
+		return errors.New("unlocking script is not push only")
+	}
+
+	var stack stack
+	err := execute(unlocking, &stack, checker)
+	if err != nil {
+		return err
+	}
+	redeemStack := stack.copy()
+
+	err = execute(locking, &stack, checker)
+	if err != nil {
+		return err
+	}
+	if !stack.topIsTrue() {
+		return ErrFalse
+	}
+
+	if !IsPayToScriptHash(locking) {
+		return nil
+	}
+	redeem, err := redeemStack.pop()
+	if err != nil {
+		return err
+	}
+	err = execute(redeem, &redeemStack, checker)
+	if err != nil {
+		return fmt.Errorf("redeem script: %s", err)
+	}
+	if !redeemStack.topIsTrue() {
+		return ErrFalse
+	}
+	return nil
+}
+
+// 在栈上执行脚本。
+func execute(script []byte, s *stack, checker Checker) error {
+	instructions, err := parse(script)
+	if err != nil {
+		return err
+	}
+
+	// 条件分支的执行状态，每层 OP_IF 一项，全部为真时才执行当前指令。
+	var conditions []bool
+	executing := func() bool {
+		for _, condition := range conditions {
+			if !condition {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, ins := range instructions {
+		// 未执行的分支中只处理条件分支指令。
+		if !executing() && (ins.opcode < OP_IF || ins.opcode > OP_ENDIF) {
+			continue
+		}
+
+		err := step(ins, s, &conditions, executing(), checker)
+		if err != nil {
+			return err
+		}
+		if s.size() > maxStackSize {
+			return errors.New("stack overflow")
+		}
+	}
+
+	if len(conditions) != 0 {
+		return errors.New("unbalanced conditional")
+	}
+	return nil
+}
+
+// 执行一条指令。executing 表示当前分支是否被执行。
+func step(ins instruction, s *stack, conditions *[]bool, executing bool, checker Checker) error {
+	if len(ins.data) > MaxPushSize {
+		return fmt.Errorf("push of %d bytes, larger than %d", len(ins.data), MaxPushSize)
+	}
+	if n, ok := smallInt(ins); ok {
+		s.push(encodeNum(int64(n)))
+		return nil
+	}
+	if ins.opcode <= OP_PUSHDATA2 {
+		s.push(ins.data)
+		return nil
+	}
+
+	switch ins.opcode {
+	case OP_NOP:
+
+	case OP_IF, OP_NOTIF:
+		condition := false
+		if executing {
+			value, err := s.pop()
+			if err != nil {
+				return err
+			}
+			condition = isTrue(value) == (ins.opcode == OP_IF)
+		}
+		*conditions = append(*conditions, condition)
+
+	case OP_ELSE:
+		if len(*conditions) == 0 {
+			return errors.New("OP_ELSE without OP_IF")
+		}
+		last := len(*conditions) - 1
+		(*conditions)[last] = !(*conditions)[last]
+
+	case OP_ENDIF:
+		if len(*conditions) == 0 {
+			return errors.New("OP_ENDIF without OP_IF")
+		}
+		*conditions = (*conditions)[:len(*conditions)-1]
+
+	case OP_VERIFY:
+		return s.verify()
+
+	case OP_RETURN:
+		return errors.New("OP_RETURN executed")
+
+	case OP_DROP:
+		_, err := s.pop()
+		return err
+
+	case OP_DUP:
+		value, err := s.peek(0)
+		if err != nil {
+			return err
+		}
+		s.push(value)
+
+	case OP_SWAP:
+		a, err := s.pop()
+		if err != nil {
+			return err
+		}
+		b, err := s.pop()
+		if err != nil {
+			return err
+		}
+		s.push(a)
+		s.push(b)
+
+	case OP_SIZE:
+		value, err := s.peek(0)
+		if err != nil {
+			return err
+		}
+		s.push(encodeNum(int64(len(value))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := s.pop()
+		if err != nil {
+			return err
+		}
+		b, err := s.pop()
+		if err != nil {
+			return err
+		}
+		s.pushBool(bytes.Equal(a, b))
+		if ins.opcode == OP_EQUALVERIFY {
+			return s.verify()
+		}
+
+	case OP_SHA256:
+		value, err := s.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(value)
+		s.push(hash[:])
+
+	case OP_HASH160:
+		value, err := s.pop()
+		if err != nil {
+			return err
+		}
+		s.push(utils.GetPubkeyHash(value))
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubkey, err := s.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := s.pop()
+		if err != nil {
+			return err
+		}
+		s.pushBool(len(signature) != 0 && checker.CheckSig(signature, pubkey))
+		if ins.opcode == OP_CHECKSIGVERIFY {
+			return s.verify()
+		}
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		valid, err := checkMultisig(s, checker)
+		if err != nil {
+			return err
+		}
+		s.pushBool(valid)
+		if ins.opcode == OP_CHECKMULTISIGVERIFY {
+			return s.verify()
+		}
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		value, err := s.peek(0)
+		if err != nil {
+			return err
+		}
+		lockTime, err := decodeNum(value, maxLockTimeSize)
+		if err != nil {
+			return err
+		}
+		if lockTime < 0 {
+			return errors.New("negative lock time")
+		}
+		if !checker.CheckLockTime(lockTime) {
+			return fmt.Errorf("lock time %d is not reached", lockTime)
+		}
+
+	default:
+		return fmt.Errorf("unknown opcode %#x", ins.opcode)
+	}
+	return nil
+}
+
+// 执行 OP_CHECKMULTISIG：弹出 n、n 个公钥、m、m 个签名。
+// 签名须按公钥的顺序排列，每个签名与其后尚未匹配的公钥依次比对，全部匹配时有效。
+func checkMultisig(s *stack, checker Checker) (bool, error) {
+	n, err := s.popInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > 20 {
+		return false, fmt.Errorf("invalid public key count %d", n)
+	}
+	pubkeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		pubkeys[i], err = s.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	m, err := s.popInt()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, fmt.Errorf("invalid signature count %d", m)
+	}
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		signatures[i], err = s.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	next := 0
+	for _, signature := range signatures {
+		for next < len(pubkeys) && !(len(signature) != 0 && checker.CheckSig(signature, pubkeys[next])) {
+			next++
+		}
+		if next == len(pubkeys) {
+			return false, nil
+		}
+		next++
+	}
+	return true, nil
+}
+
+// 判断栈中的数据是否为真：非空，且不全为 0（最后一字节为 0x80 表示负零，同样为假）。
+func isTrue(value []byte) bool {
+	for i, b := range value {
+		if b != 0 && !(i == len(value)-1 && b == 0x80) {
+			return true
+		}
+	}
+	return false
+}
+
+// 脚本执行时的栈。
+type stack struct {
+	items [][]byte
+}
+
+// 压入数据。
+func (s *stack) push(value []byte) {
+	s.items = append(s.items, value)
+}
+
+// 压入布尔值：真为 1，假为空字节串。
+func (s *stack) pushBool(value bool) {
+	if value {
+		s.push([]byte{1})
+	} else {
+		s.push(nil)
+	}
+}
+
+// 弹出栈顶。
+func (s *stack) pop() ([]byte, error) {
+	if len(s.items) == 0 {
+		return nil, errors.New("stack is empty")
+	}
+	value := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return value, nil
+}
+
+// 弹出栈顶的数字。
+func (s *stack) popInt() (int, error) {
+	value, err := s.pop()
+	if err != nil {
+		return 0, err
+	}
+	n, err := decodeNum(value, 4)
+	return int(n), err
+}
+
+// 获取从栈顶数起第 depth 项，不弹出。
+func (s *stack) peek(depth int) ([]byte, error) {
+	if depth >= len(s.items) {
+		return nil, errors.New("stack is empty")
+	}
+	return s.items[len(s.items)-1-depth], nil
+}
+
+// 弹出栈顶，为假时返回错误。
+func (s *stack) verify() error {
+	value, err := s.pop()
+	if err != nil {
+		return err
+	}
+	if !isTrue(value) {
+		return errors.New("verify failed")
+	}
+	return nil
+}
+
+// 判断栈顶是否为真。
+func (s *stack) topIsTrue() bool {
+	value, err := s.peek(0)
+	return err == nil && isTrue(value)
+}
+
+// 获取栈大小。
+func (s *stack) size() int {
+	return len(s.items)
+}
+
+// 复制栈。
+func (s *stack) copy() stack {
+	return stack{append([][]byte{}, s.items...)}
+}
@@ -0,0 +1,177 @@
+package script
+
+import (
+	"blockchain/utils"
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+// 测试用的校验器：签名为 "sig:" 加公钥时有效，锁定时间不晚于 now 时到达。
+type testChecker struct {
+	now int64
+}
+
+func (c testChecker) CheckSig(signature []byte, pubkey []byte) bool {
+	return bytes.Equal(signature, sign(pubkey))
+}
+
+func (c testChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= c.now
+}
+
+// 计算 SHA256 哈希值。
+func sha256Sum(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// 生成 testChecker 认可的签名。
+func sign(pubkey []byte) []byte {
+	return append([]byte("sig:"), pubkey...)
+}
+
+// 依次压入数据的解锁脚本。
+func pushes(items ...[]byte) []byte {
+	b := NewBuilder()
+	for _, item := range items {
+		b.AddData(item)
+	}
+	return b.Script()
+}
+
+// 依次追加操作码的脚本。
+func ops(opcodes ...byte) []byte {
+	return append([]byte{}, opcodes...)
+}
+
+var (
+	pk1 = []byte("public key 1")
+	pk2 = []byte("public key 2")
+	pk3 = []byte("public key 3")
+)
+
+func TestVerify(t *testing.T) {
+	p2pkh := PayToPubkeyHash(utils.GetPubkeyHash(pk1))
+	multisig := Multisig(2, [][]byte{pk1, pk2, pk3})
+	p2sh := PayToScriptHash(utils.GetPubkeyHash(multisig))
+	cltv := func(lockTime int64) []byte {
+		return NewBuilder().AddInt(lockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).AddOp(OP_1).Script()
+	}
+	// 外层条件为真时执行内层条件；结果分别为 2、3、4，与 OP_3 比较。
+	nested := ops(OP_IF, OP_IF, OP_1+1, OP_ELSE, OP_1+2, OP_ENDIF, OP_ELSE, OP_1+3, OP_ENDIF, OP_1+2, OP_EQUAL)
+
+	tests := []struct {
+		name      string
+		unlocking []byte
+		locking   []byte
+		err       string // 期望的错误信息片段，为空时期望成功。
+	}{
+		// P2PKH。
+		{"p2pkh", pushes(sign(pk1), pk1), p2pkh, ""},
+		{"p2pkh wrong signature", pushes(sign(pk2), pk1), p2pkh, ErrFalse.Error()},
+		{"p2pkh wrong public key", pushes(sign(pk2), pk2), p2pkh, "verify failed"},
+		{"p2pkh empty signature", pushes(nil, pk1), p2pkh, ErrFalse.Error()},
+		{"p2pkh missing items", pushes(pk1), p2pkh, "stack is empty"},
+
+		// 多重签名：签名须按公钥的顺序排列。
+		{"multisig 1 and 2", pushes(sign(pk1), sign(pk2)), multisig, ""},
+		{"multisig 1 and 3", pushes(sign(pk1), sign(pk3)), multisig, ""},
+		{"multisig 2 and 3", pushes(sign(pk2), sign(pk3)), multisig, ""},
+		{"multisig out of order", pushes(sign(pk2), sign(pk1)), multisig, ErrFalse.Error()},
+		{"multisig same key twice", pushes(sign(pk1), sign(pk1)), multisig, ErrFalse.Error()},
+		{"multisig too few signatures", pushes(sign(pk1)), multisig, "stack is empty"},
+		{"multisig too many signatures out of order", pushes(sign(pk1), sign(pk3), sign(pk2)), multisig, ErrFalse.Error()},
+		{"multisig more signatures than keys", pushes(sign(pk1), sign(pk2)), Multisig(3, [][]byte{pk1, pk2}), "invalid signature count 3"},
+
+		// P2SH：赎回脚本的哈希值相符后，在解锁脚本得到的栈上执行赎回脚本。
+		{"p2sh multisig", pushes(sign(pk1), sign(pk3), multisig), p2sh, ""},
+		{"p2sh wrong redeem script", pushes(sign(pk1), sign(pk2), Multisig(1, [][]byte{pk1})), p2sh, ErrFalse.Error()},
+		{"p2sh redeem script fails", pushes(sign(pk3), sign(pk1), multisig), p2sh, ErrFalse.Error()},
+		{"p2sh too few signatures", pushes(sign(pk1), multisig), p2sh, "redeem script: stack is empty"},
+
+		// 条件分支。
+		{"if true", pushes([]byte{1}), ops(OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF), ""},
+		{"if false", pushes(nil), ops(OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF), ErrFalse.Error()},
+		{"notif false", pushes(nil), ops(OP_NOTIF, OP_1, OP_ELSE, OP_0, OP_ENDIF), ""},
+		{"notif true", pushes([]byte{1}), ops(OP_NOTIF, OP_1, OP_ELSE, OP_0, OP_ENDIF), ErrFalse.Error()},
+		{"negative zero is false", pushes([]byte{0x80}), ops(OP_NOTIF, OP_1, OP_ENDIF), ""},
+		{"nested if else", pushes(nil, []byte{1}), nested, ""},
+		{"nested if if", pushes([]byte{1}, []byte{1}), nested, ErrFalse.Error()},
+		{"nested else", pushes([]byte{1}, nil), nested, ErrFalse.Error()},
+		{"skipped branch ignores unknown opcodes", nil, ops(OP_0, OP_IF, 0xff, OP_RETURN, OP_ENDIF, OP_1), ""},
+		{"missing endif", nil, ops(OP_1, OP_IF, OP_1), "unbalanced conditional"},
+		{"endif without if", nil, ops(OP_1, OP_ENDIF), "OP_ENDIF without OP_IF"},
+		{"else without if", nil, ops(OP_1, OP_ELSE), "OP_ELSE without OP_IF"},
+		{"if on empty stack", nil, ops(OP_IF, OP_ENDIF, OP_1), "stack is empty"},
+
+		// 锁定时间。
+		{"cltv at lock", nil, cltv(100), ""},
+		{"cltv before lock", nil, cltv(101), "lock time 101 is not reached"},
+		{"cltv negative", nil, cltv(-1), "negative lock time"},
+		{"cltv too long", nil, NewBuilder().AddData(make([]byte, 6)).AddOp(OP_CHECKLOCKTIMEVERIFY).Script(), "longer than 5"},
+		{"cltv not minimal", nil, NewBuilder().AddData([]byte{100, 0}).AddOp(OP_CHECKLOCKTIMEVERIFY).Script(), "not minimally encoded"},
+
+		// 数据输出无法消费。
+		{"null data", pushes([]byte{1}), NullData([]byte("data")), "OP_RETURN executed"},
+
+		// 解锁脚本只能压入数据。
+		{"unlocking with opcode", ops(OP_1, OP_DUP), ops(OP_EQUAL), "not push only"},
+		{"unlocking with pushdata4", ops(0x4e, 0, 0, 0, 0), ops(OP_1), "not push only"},
+		{"unlocking with 1negate", ops(0x4f), ops(OP_1), "not push only"},
+		{"unlocking with reserved", ops(0x50), ops(OP_1), "not push only"},
+		{"unlocking truncated push", ops(5, 1, 2), ops(OP_1), "not push only"},
+
+		// 其他。
+		{"empty result", nil, nil, ErrFalse.Error()},
+		{"unknown opcode", nil, ops(OP_1, 0xff), "unknown opcode 0xff"},
+		{"oversized push", pushes(make([]byte, MaxPushSize+1)), ops(OP_SIZE), "larger than 520"},
+		{"size", pushes([]byte("abc")), NewBuilder().AddOp(OP_SIZE).AddInt(3).AddOp(OP_EQUALVERIFY).Script(), ""},
+		{"sha256 preimage", pushes([]byte("secret")), NewBuilder().AddOp(OP_SHA256).AddData(sha256Sum([]byte("secret"))).AddOp(OP_EQUAL).Script(), ""},
+	}
+
+	checker := testChecker{now: 100}
+	for _, test := range tests {
+		err := Verify(test.unlocking, test.locking, checker)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: succeeded, want error %q", test.name, test.err)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%s: error %q, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+// 哈希时间锁合约的两条分支：收款方出示原像，付款方在锁定时间之后取回。
+func TestVerifyHashTimeLock(t *testing.T) {
+	secret := []byte("secret")
+	hash := sha256Sum(secret)
+	recipient, sender := utils.GetPubkeyHash(pk1), utils.GetPubkeyHash(pk2)
+	contract := HashTimeLock(hash, recipient, sender, 100)
+
+	tests := []struct {
+		name      string
+		unlocking []byte
+		now       int64
+		valid     bool
+	}{
+		{"claim", pushes(sign(pk1), pk1, secret, []byte{1}), 0, true},
+		{"claim wrong preimage", pushes(sign(pk1), pk1, []byte("guess"), []byte{1}), 0, false},
+		{"claim by sender", pushes(sign(pk2), pk2, secret, []byte{1}), 0, false},
+		{"refund at lock time", pushes(sign(pk2), pk2, nil), 100, true},
+		{"refund before lock time", pushes(sign(pk2), pk2, nil), 99, false},
+		{"refund by recipient", pushes(sign(pk1), pk1, nil), 100, false},
+	}
+	for _, test := range tests {
+		err := Verify(test.unlocking, contract, testChecker{test.now})
+		if test.valid && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: succeeded", test.name)
+		}
+	}
+}
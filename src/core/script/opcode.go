@@ -0,0 +1,62 @@
+package script
+
+// 操作码。数值与比特币脚本相同，只实现其中的一个子集。
+const (
+	OP_0         = 0x00 // 压入空字节串，即假或 0。
+	OP_PUSHDATA1 = 0x4c // 压入数据，长度在随后的 1 字节中。
+	OP_PUSHDATA2 = 0x4d // 压入数据，长度在随后的 2 字节中（大端序）。
+	OP_1         = 0x51 // 压入数字 1，OP_2 至 OP_16 依次类推。
+	OP_16        = 0x60
+
+	OP_NOP    = 0x61 // 什么也不做。
+	OP_IF     = 0x63 // 弹出栈顶，为真时执行随后的分支。
+	OP_NOTIF  = 0x64 // 弹出栈顶，为假时执行随后的分支。
+	OP_ELSE   = 0x67 // 另一分支。
+	OP_ENDIF  = 0x68 // 结束条件分支。
+	OP_VERIFY = 0x69 // 弹出栈顶，为假时脚本失败。
+	OP_RETURN = 0x6a // 脚本立即失败，用于标记不可消费的输出。
+
+	OP_DROP = 0x75 // 弹出栈顶。
+	OP_DUP  = 0x76 // 复制栈顶。
+	OP_SWAP = 0x7c // 交换栈顶的两项。
+	OP_SIZE = 0x82 // 压入栈顶数据的字节数，不弹出栈顶。
+
+	OP_EQUAL       = 0x87 // 弹出两项，相等时压入真，否则压入假。
+	OP_EQUALVERIFY = 0x88 // 同 OP_EQUAL，随后执行 OP_VERIFY。
+
+	OP_SHA256              = 0xa8 // 将栈顶替换为其 SHA256 哈希值。
+	OP_HASH160             = 0xa9 // 将栈顶替换为其 SHA256 + RIPEMD160 哈希值。
+	OP_CHECKSIG            = 0xac // 弹出公钥和签名，签名有效时压入真。
+	OP_CHECKSIGVERIFY      = 0xad // 同 OP_CHECKSIG，随后执行 OP_VERIFY。
+	OP_CHECKMULTISIG       = 0xae // 弹出 n、n 个公钥、m、m 个签名，签名按公钥顺序全部有效时压入真。
+	OP_CHECKMULTISIGVERIFY = 0xaf // 同 OP_CHECKMULTISIG，随后执行 OP_VERIFY。
+
+	OP_CHECKLOCKTIMEVERIFY = 0xb1 // 栈顶的锁定时间尚未到达时脚本失败，不弹出栈顶。
+)
+
+// 操作码的名称，用于反汇编。
+var opcodeNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_NOP:                 "OP_NOP",
+	OP_IF:                  "OP_IF",
+	OP_NOTIF:               "OP_NOTIF",
+	OP_ELSE:                "OP_ELSE",
+	OP_ENDIF:               "OP_ENDIF",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_SWAP:                "OP_SWAP",
+	OP_SIZE:                "OP_SIZE",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_SHA256:              "OP_SHA256",
+	OP_HASH160:             "OP_HASH160",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+}
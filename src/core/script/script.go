@@ -0,0 +1,359 @@
+package script
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 脚本的最大字节数。
+const MaxScriptSize = 10000
+
+// 单次压入数据的最大字节数。
+const MaxPushSize = 520
+
+// 数据输出最多携带的字节数。
+const MaxNullDataSize = 80
+
+// 脚本格式错误。
+var ErrMalformed = errors.New("malformed script")
+
+// 脚本中的一条指令：操作码，以及压入数据的指令所压入的数据。
+type instruction struct {
+	opcode byte
+	data   []byte
+}
+
+// 判断指令是否只压入数据：OP_0、直接压入、OP_PUSHDATA1、OP_PUSHDATA2，或 OP_1 至 OP_16。
+// 比特币中的 OP_PUSHDATA4（0x4e）和 OP_1NEGATE（0x4f）在这里没有实现，执行时是未知操作码，不算压入。
+func (ins instruction) isPush() bool {
+	return ins.opcode <= OP_PUSHDATA2 || ins.opcode >= OP_1 && ins.opcode <= OP_16
+}
+
+// 将脚本解析为指令序列。
+func parse(script []byte) ([]instruction, error) {
+	if len(script) > MaxScriptSize {
+		return nil, fmt.Errorf("script is %d bytes, larger than %d", len(script), MaxScriptSize)
+	}
+
+	var instructions []instruction
+	for pos := 0; pos < len(script); {
+		opcode := script[pos]
+		pos++
+
+		size := 0
+		switch {
+		case opcode > OP_0 && opcode < OP_PUSHDATA1:
+			size = int(opcode)
+		case opcode == OP_PUSHDATA1:
+			if pos+1 > len(script) {
+				return nil, ErrMalformed
+			}
+			size = int(script[pos])
+			pos++
+		case opcode == OP_PUSHDATA2:
+			if pos+2 > len(script) {
+				return nil, ErrMalformed
+			}
+			size = int(binary.BigEndian.Uint16(script[pos:]))
+			pos += 2
+		}
+		if pos+size > len(script) {
+			return nil, ErrMalformed
+		}
+
+		var data []byte
+		if opcode <= OP_PUSHDATA2 {
+			data = script[pos : pos+size]
+		}
+		instructions = append(instructions, instruction{opcode, data})
+		pos += size
+	}
+	return instructions, nil
+}
+
+// 脚本构建器。
+type Builder struct {
+	script []byte
+}
+
+// 创建脚本构建器。
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// 追加操作码。
+func (b *Builder) AddOp(opcode byte) *Builder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// 追加压入数据的指令，按数据长度选用最短的形式。
+func (b *Builder) AddData(data []byte) *Builder {
+	switch size := len(data); {
+	case size == 0:
+		b.script = append(b.script, OP_0)
+	case size < OP_PUSHDATA1:
+		b.script = append(b.script, byte(size))
+	case size <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(size))
+	default:
+		b.script = append(b.script, OP_PUSHDATA2, byte(size>>8), byte(size))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+// 追加压入数字的指令。0 至 16 使用 OP_0 至 OP_16。
+func (b *Builder) AddInt(n int64) *Builder {
+	switch {
+	case n == 0:
+		return b.AddOp(OP_0)
+	case n >= 1 && n <= 16:
+		return b.AddOp(byte(OP_1 - 1 + n))
+	}
+	return b.AddData(encodeNum(n))
+}
+
+// 获取构建的脚本。
+func (b *Builder) Script() []byte {
+	return b.script
+}
+
+// 生成向公钥哈希支付（P2PKH）的锁定脚本：
+// OP_DUP OP_HASH160 <公钥哈希> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubkeyHash(pubkeyHash []byte) []byte {
+	return NewBuilder().AddOp(OP_DUP).AddOp(OP_HASH160).AddData(pubkeyHash).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+// 生成向脚本哈希支付（P2SH）的锁定脚本：OP_HASH160 <脚本哈希> OP_EQUAL
+// 消费时解锁脚本的最后一项是赎回脚本，其哈希值相符后再执行赎回脚本。
+func PayToScriptHash(scriptHash []byte) []byte {
+	return NewBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// 生成 m-of-n 多重签名脚本：OP_m <公钥 1> ... <公钥 n> OP_n OP_CHECKMULTISIG
+func Multisig(m int, pubkeys [][]byte) []byte {
+	b := NewBuilder().AddInt(int64(m))
+	for _, pubkey := range pubkeys {
+		b.AddData(pubkey)
+	}
+	return b.AddInt(int64(len(pubkeys))).AddOp(OP_CHECKMULTISIG).Script()
+}
+
+// 解析 m-of-n 多重签名脚本，返回 m 和各公钥。
+func ParseMultisig(script []byte) (int, [][]byte, error) {
+	instructions, err := parse(script)
+	if err != nil {
+		return 0, nil, err
+	}
+	count := len(instructions)
+	if count < 4 || instructions[count-1].opcode != OP_CHECKMULTISIG {
+		return 0, nil, errors.New("not a multisig script")
+	}
+	m, okM := smallInt(instructions[0])
+	n, okN := smallInt(instructions[count-2])
+	if !okM || !okN || n != count-3 {
+		return 0, nil, errors.New("not a multisig script")
+	}
+
+	var pubkeys [][]byte
+	for _, ins := range instructions[1 : count-2] {
+		if !ins.isPush() || len(ins.data) == 0 {
+			return 0, nil, errors.New("not a multisig script")
+		}
+		pubkeys = append(pubkeys, ins.data)
+	}
+	return m, pubkeys, nil
+}
+
+// 生成哈希时间锁合约（HTLC）脚本：
+// OP_IF OP_SHA256 <哈希> OP_EQUALVERIFY OP_DUP OP_HASH160 <收款方公钥哈希>
+// OP_ELSE <锁定时间> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <付款方公钥哈希>
+// OP_ENDIF OP_EQUALVERIFY OP_CHECKSIG
+// 收款方出示哈希的原像即可领取；锁定时间到达后，付款方可以取回。
+func HashTimeLock(hash []byte, recipient []byte, sender []byte, lockTime int64) []byte {
+	return NewBuilder().
+		AddOp(OP_IF).AddOp(OP_SHA256).AddData(hash).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(recipient).
+		AddOp(OP_ELSE).AddInt(lockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(sender).
+		AddOp(OP_ENDIF).AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+// 解析哈希时间锁合约脚本，返回哈希、收款方和付款方的公钥哈希，以及锁定时间。
+func ParseHashTimeLock(script []byte) ([]byte, []byte, []byte, int64, error) {
+	invalid := errors.New("not a hash time lock script")
+	instructions, err := parse(script)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if len(instructions) != 17 {
+		return nil, nil, nil, 0, invalid
+	}
+
+	hash := instructions[2].data
+	recipient := instructions[6].data
+	sender := instructions[13].data
+	lockTime, ok := int64(0), false
+	if n, small := smallInt(instructions[8]); small {
+		lockTime, ok = int64(n), true
+	} else if instructions[8].isPush() {
+		lockTime, err = decodeNum(instructions[8].data, maxLockTimeSize)
+		ok = err == nil
+	}
+	// 按解析出的参数重新生成脚本，与原脚本完全相同才有效。
+	if !ok || !bytes.Equal(HashTimeLock(hash, recipient, sender, lockTime), script) {
+		return nil, nil, nil, 0, invalid
+	}
+	return hash, recipient, sender, lockTime, nil
+}
+
+// 生成携带数据的锁定脚本：OP_RETURN <数据>
+// 执行到 OP_RETURN 即失败，因此锁定的输出可证明无法消费。
+func NullData(data []byte) []byte {
+	return NewBuilder().AddOp(OP_RETURN).AddData(data).Script()
+}
+
+// 获取数据输出的锁定脚本携带的数据。脚本不是 OP_RETURN 加单次压入时返回 false。
+func ParseNullData(script []byte) ([]byte, bool) {
+	instructions, err := parse(script)
+	if err != nil || len(instructions) != 2 || instructions[0].opcode != OP_RETURN || !instructions[1].isPush() {
+		return nil, false
+	}
+	return instructions[1].data, true
+}
+
+// 判断锁定脚本是否可证明无法消费，即以 OP_RETURN 开头。
+func IsUnspendable(script []byte) bool {
+	return len(script) > 0 && script[0] == OP_RETURN
+}
+
+// 判断是否为 P2SH 锁定脚本。
+func IsPayToScriptHash(script []byte) bool {
+	return len(script) == 23 && script[0] == OP_HASH160 && script[1] == 20 && script[22] == OP_EQUAL
+}
+
+// 判断脚本是否只压入数据。解锁脚本必须满足此条件。
+func IsPushOnly(script []byte) bool {
+	instructions, err := parse(script)
+	if err != nil {
+		return false
+	}
+	for _, ins := range instructions {
+		if !ins.isPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// 获取只压入数据的脚本依次压入栈中的数据，OP_0 至 OP_16 按数字编码。
+// 用于从解锁脚本中读取签名、原像等数据。
+func PushedData(script []byte) ([][]byte, error) {
+	instructions, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+	var items [][]byte
+	for _, ins := range instructions {
+		if !ins.isPush() {
+			return nil, errors.New("script is not push only")
+		}
+		if n, ok := smallInt(ins); ok {
+			items = append(items, encodeNum(int64(n)))
+		} else {
+			items = append(items, ins.data)
+		}
+	}
+	return items, nil
+}
+
+// 反汇编脚本，压入的数据以十六进制显示。
+func Disassemble(script []byte) string {
+	instructions, err := parse(script)
+	if err != nil {
+		return fmt.Sprintf("[%s] %x", err, script)
+	}
+
+	var words []string
+	for _, ins := range instructions {
+		if n, ok := smallInt(ins); ok && ins.opcode != OP_0 {
+			words = append(words, fmt.Sprintf("OP_%d", n))
+		} else if ins.opcode != OP_0 && ins.opcode <= OP_PUSHDATA2 {
+			words = append(words, hex.EncodeToString(ins.data))
+		} else if name, ok := opcodeNames[ins.opcode]; ok {
+			words = append(words, name)
+		} else {
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%#x", ins.opcode))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// 获取 OP_0 至 OP_16 表示的数字。
+func smallInt(ins instruction) (int, bool) {
+	switch {
+	case ins.opcode == OP_0:
+		return 0, true
+	case ins.opcode >= OP_1 && ins.opcode <= OP_16:
+		return int(ins.opcode - OP_1 + 1), true
+	}
+	return 0, false
+}
+
+// 编码脚本中的数字：小端序的绝对值，最高字节的最高位为符号位，0 编码为空字节串。
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+
+	var result []byte
+	for abs > 0 {
+		result = append(result, byte(abs))
+		abs >>= 8
+	}
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+// 解码脚本中的数字，最多 maxSize 字节，且必须是最短编码。
+func decodeNum(data []byte, maxSize int) (int64, error) {
+	if len(data) > maxSize {
+		return 0, fmt.Errorf("number is %d bytes, longer than %d", len(data), maxSize)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	last := data[len(data)-1]
+	if last&0x7f == 0 && (len(data) == 1 || data[len(data)-2]&0x80 == 0) {
+		return 0, errors.New("number is not minimally encoded")
+	}
+
+	var result int64
+	for i, b := range data {
+		result |= int64(b) << (8 * uint(i))
+	}
+	if last&0x80 != 0 {
+		result &= ^(int64(0x80) << (8 * uint(len(data)-1)))
+		result = -result
+	}
+	return result, nil
+}
@@ -0,0 +1,176 @@
+package script
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 数字编码：小端序的绝对值，最高字节的最高位为符号位。
+func TestNumberEncoding(t *testing.T) {
+	tests := []struct {
+		n       int64
+		encoded []byte
+	}{
+		{0, nil},
+		{1, []byte{0x01}},
+		{-1, []byte{0x81}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-128, []byte{0x80, 0x80}},
+		{255, []byte{0xff, 0x00}},
+		{256, []byte{0x00, 0x01}},
+		{-256, []byte{0x00, 0x81}},
+		{1700000000, []byte{0x00, 0xf1, 0x53, 0x65}},
+		{1 << 31, []byte{0x00, 0x00, 0x00, 0x80, 0x00}},
+	}
+	for _, test := range tests {
+		if encoded := encodeNum(test.n); !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("encodeNum(%d) = %x, want %x", test.n, encoded, test.encoded)
+		}
+		n, err := decodeNum(test.encoded, maxLockTimeSize)
+		if err != nil || n != test.n {
+			t.Errorf("decodeNum(%x) = %d, %v, want %d", test.encoded, n, err, test.n)
+		}
+	}
+}
+
+// 非最短编码与超长的数字被拒绝。
+func TestDecodeNumRejects(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		maxSize int
+	}{
+		{[]byte{0x00}, 4},             // 0 应编码为空字节串。
+		{[]byte{0x80}, 4},             // 负零。
+		{[]byte{0x01, 0x00}, 4},       // 多余的 0 字节。
+		{[]byte{0x01, 0x80}, 4},       // 多余的符号字节，-1 应编码为 0x81。
+		{[]byte{0x7f, 0x00, 0x00}, 4}, // 多余的 0 字节。
+		{[]byte{1, 2, 3, 4, 5}, 4},    // 超过 maxSize。
+	}
+	for _, test := range tests {
+		if n, err := decodeNum(test.data, test.maxSize); err == nil {
+			t.Errorf("decodeNum(%x, %d) = %d, want error", test.data, test.maxSize, n)
+		}
+	}
+}
+
+// 构建器按数据长度选用最短的压入形式，解析后得到原数据。
+func TestBuilderPushes(t *testing.T) {
+	tests := []struct {
+		size   int
+		opcode byte
+	}{
+		{0, OP_0},
+		{1, 1},
+		{75, 75},
+		{76, OP_PUSHDATA1},
+		{255, OP_PUSHDATA1},
+		{256, OP_PUSHDATA2},
+		{MaxPushSize, OP_PUSHDATA2},
+	}
+	for _, test := range tests {
+		data := bytes.Repeat([]byte{0xab}, test.size)
+		script := NewBuilder().AddData(data).Script()
+		if script[0] != test.opcode {
+			t.Errorf("push of %d bytes uses opcode %#x, want %#x", test.size, script[0], test.opcode)
+		}
+		instructions, err := parse(script)
+		if err != nil || len(instructions) != 1 || !instructions[0].isPush() || len(instructions[0].data) != test.size {
+			t.Errorf("push of %d bytes parses as %v, %v", test.size, instructions, err)
+		}
+		if !IsPushOnly(script) {
+			t.Errorf("push of %d bytes is not push only", test.size)
+		}
+	}
+
+	for _, script := range [][]byte{{OP_PUSHDATA1}, {OP_PUSHDATA2, 0x01}, {OP_PUSHDATA1, 2, 0xab}, {3, 0xab}} {
+		if _, err := parse(script); err != ErrMalformed {
+			t.Errorf("parse(%x) = %v, want ErrMalformed", script, err)
+		}
+	}
+}
+
+func TestParseMultisig(t *testing.T) {
+	pubkeys := [][]byte{pk1, pk2, pk3}
+	for m := 1; m <= len(pubkeys); m++ {
+		gotM, gotPubkeys, err := ParseMultisig(Multisig(m, pubkeys))
+		if err != nil {
+			t.Fatalf("%d-of-3: %v", m, err)
+		}
+		if gotM != m || len(gotPubkeys) != len(pubkeys) {
+			t.Fatalf("%d-of-3 parses as %d-of-%d", m, gotM, len(gotPubkeys))
+		}
+		for i := range pubkeys {
+			if !bytes.Equal(gotPubkeys[i], pubkeys[i]) {
+				t.Errorf("%d-of-3: public key %d = %x, want %x", m, i, gotPubkeys[i], pubkeys[i])
+			}
+		}
+	}
+
+	invalid := [][]byte{
+		nil,
+		PayToPubkeyHash(make([]byte, 20)),
+		NewBuilder().AddInt(1).AddData(pk1).AddInt(2).AddOp(OP_CHECKMULTISIG).Script(),  // n 与公钥数不符。
+		NewBuilder().AddInt(1).AddOp(OP_DUP).AddInt(1).AddOp(OP_CHECKMULTISIG).Script(), // 公钥不是压入的数据。
+		NewBuilder().AddInt(1).AddOp(OP_0).AddInt(1).AddOp(OP_CHECKMULTISIG).Script(),   // 空公钥。
+		append(Multisig(1, [][]byte{pk1}), OP_NOP),
+	}
+	for _, script := range invalid {
+		if _, _, err := ParseMultisig(script); err == nil {
+			t.Errorf("ParseMultisig(%s) succeeded", Disassemble(script))
+		}
+	}
+}
+
+func TestParseHashTimeLock(t *testing.T) {
+	hash := sha256Sum([]byte("secret"))
+	recipient, sender := bytes.Repeat([]byte{1}, 20), bytes.Repeat([]byte{2}, 20)
+
+	// 锁定时间分别由 OP_0、OP_n 和压入的数字表示。
+	for _, lockTime := range []int64{0, 16, 17, 500000, 1700000000} {
+		script := HashTimeLock(hash, recipient, sender, lockTime)
+		gotHash, gotRecipient, gotSender, gotLockTime, err := ParseHashTimeLock(script)
+		if err != nil {
+			t.Fatalf("lock time %d: %v", lockTime, err)
+		}
+		if !bytes.Equal(gotHash, hash) || !bytes.Equal(gotRecipient, recipient) || !bytes.Equal(gotSender, sender) || gotLockTime != lockTime {
+			t.Errorf("lock time %d parses as %x %x %x %d", lockTime, gotHash, gotRecipient, gotSender, gotLockTime)
+		}
+	}
+
+	script := HashTimeLock(hash, recipient, sender, 500000)
+	swapped := bytes.Replace(script, []byte{OP_EQUALVERIFY, OP_CHECKSIG}, []byte{OP_EQUAL, OP_CHECKSIG}, 1)
+	invalid := [][]byte{
+		nil,
+		script[:len(script)-1],
+		append(append([]byte{}, script...), OP_NOP),
+		swapped,
+		Multisig(1, [][]byte{pk1}),
+	}
+	for _, script := range invalid {
+		if _, _, _, _, err := ParseHashTimeLock(script); err == nil {
+			t.Errorf("ParseHashTimeLock(%s) succeeded", Disassemble(script))
+		}
+	}
+}
+
+// 数据输出以 OP_RETURN 开头，可证明无法消费，并能取回携带的数据。
+func TestNullData(t *testing.T) {
+	data := []byte("hello")
+	script := NullData(data)
+	if !IsUnspendable(script) {
+		t.Error("null data output is spendable")
+	}
+	if got, ok := ParseNullData(script); !ok || !bytes.Equal(got, data) {
+		t.Errorf("ParseNullData = %q, %v", got, ok)
+	}
+	if IsUnspendable(PayToPubkeyHash(make([]byte, 20))) {
+		t.Error("p2pkh output is unspendable")
+	}
+
+	for _, script := range [][]byte{{OP_RETURN}, {OP_RETURN, 0x4e}, {OP_RETURN, OP_DUP}, append(NullData(data), OP_1)} {
+		if _, ok := ParseNullData(script); ok {
+			t.Errorf("ParseNullData(%x) succeeded", script)
+		}
+	}
+}
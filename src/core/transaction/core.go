@@ -1,6 +1,7 @@
 package transaction
 
 import (
+	"blockchain/core/script"
 	"blockchain/utils"
 	"bytes"
 	"crypto/ecdsa"
@@ -12,11 +13,21 @@ import (
 	"fmt"
 )
 
+// 可以使用自定义锁定脚本、解锁脚本和锁定时间的交易版本。
+// 版本为 0 的交易只能使用由公钥哈希、签名和公钥生成的标准脚本，规范编码和签名对象与引入脚本前相同。
+const ScriptVersion = 2
+
+// 签名对象还包含每一笔输入所引用输出的金额的交易版本，其余同 ScriptVersion。
+// 离线签名时无需信任随交易提供的被引用输出：金额与链上不符时，签名在链上无效，交易费无法被虚报。
+const AmountVersion = 3
+
 // 交易结构。
 type Transaction struct {
-	ID      []byte      // 该笔交易的ID。
-	Inputs  []*TxInput  // 该笔交易的输入。
-	Outputs []*TxOutput // 该笔交易的输出。
+	ID       []byte      // 该笔交易的ID。
+	Inputs   []*TxInput  // 该笔交易的输入。
+	Outputs  []*TxOutput // 该笔交易的输出。
+	Version  int         // 交易版本，0、ScriptVersion 或 AmountVersion。
+	LockTime int64       // 锁定时间，交易不能在此区块高度或 Unix 时间之前上链；为 0 时不锁定。
 }
 
 // 判断交易是否为 coinbase 交易。
@@ -32,10 +43,57 @@ func (tx *Transaction) IsCoinbase() bool {
 func (tx *Transaction) Hash() []byte {
 	txCopy := *tx
 	txCopy.ID = []byte{}
-	hash := sha256.Sum256(txCopy.Serialize())
+	hash := sha256.Sum256(txCopy.Encode())
 	return hash[:]
 }
 
+// 获取交易的 ID：去掉签名、解锁脚本和普通输入的公钥后的哈希值。
+// 签名对象包含交易 ID，因此 ID 在签名前确定，签名和填入公钥都不改变 ID。
+// coinbase 交易的输入携带任意数据而不是签名，其 ID 即 Hash。
+func (tx *Transaction) ComputeID() []byte {
+	if tx.IsCoinbase() {
+		return tx.Hash()
+	}
+	return tx.unsignedCopy(false).Hash()
+}
+
+// 判断交易 ID 是否与交易内容相符。
+// 升级前由钱包创建的交易在 ID 中保留了普通输入的公钥，同样视为相符。
+func (tx *Transaction) HasValidID() bool {
+	if bytes.Equal(tx.ID, tx.ComputeID()) {
+		return true
+	}
+	return !tx.IsCoinbase() && bytes.Equal(tx.ID, tx.unsignedCopy(true).Hash())
+}
+
+// 创建交易在签名前的副本：去掉签名和解锁脚本，多重签名输入保留赎回脚本和签名位置的数量。
+// keepPubkeys 为 true 时保留普通输入的公钥。
+func (tx *Transaction) unsignedCopy(keepPubkeys bool) *Transaction {
+	txCopy := *tx
+	txCopy.Inputs = nil
+	for _, txi := range tx.Inputs {
+		txiCopy := &TxInput{RefID: txi.RefID, RefIndex: txi.RefIndex, Sequence: txi.Sequence}
+		if txi.IsMultisig() {
+			txiCopy.Pubkey = txi.Pubkey
+			txiCopy.Signatures = make([][]byte, len(txi.Signatures))
+		} else if keepPubkeys {
+			txiCopy.Pubkey = txi.Pubkey
+		}
+		txCopy.Inputs = append(txCopy.Inputs, txiCopy)
+	}
+	return &txCopy
+}
+
+// 判断交易版本能否使用自定义脚本和锁定时间：ScriptVersion 及其后的 AmountVersion。
+func (tx *Transaction) hasScripts() bool {
+	return tx.Version == ScriptVersion || tx.Version == AmountVersion
+}
+
+// 获取交易规范编码的字节数。
+func (tx *Transaction) Size() int {
+	return len(tx.Encode())
+}
+
 // 创建交易的无签名副本。
 func (tx *Transaction) noSigCopy() *Transaction {
 	var (
@@ -44,13 +102,70 @@ func (tx *Transaction) noSigCopy() *Transaction {
 	)
 	// 拷贝输入。
 	for _, txi := range tx.Inputs {
-		txiCopy = append(txiCopy, &TxInput{txi.RefID, txi.RefIndex, nil, nil})
+		txiCopy = append(txiCopy, &TxInput{RefID: txi.RefID, RefIndex: txi.RefIndex, Sequence: txi.Sequence})
 	}
 	// 拷贝输出。
 	for _, txo := range tx.Outputs {
-		txoCopy = append(txoCopy, &TxOutput{txo.Value, txo.PubkeyHash})
+		txoCopy = append(txoCopy, &TxOutput{Value: txo.Value, PubkeyHash: txo.PubkeyHash, ScriptHash: txo.ScriptHash, Script: txo.Script})
 	}
-	return &Transaction{tx.ID, txiCopy, txoCopy}
+	return &Transaction{ID: tx.ID, Inputs: txiCopy, Outputs: txoCopy, Version: tx.Version, LockTime: tx.LockTime}
+}
+
+// 检查交易的格式：交易 ID 与内容相符，版本号有效，版本为 0 的交易不含自定义脚本和锁定时间，
+// 带自定义脚本的输入和输出不再使用签名、公钥和公钥哈希，
+// 以 OP_RETURN 开头的输出是金额为 0、数据不超过 script.MaxNullDataSize 字节的数据输出，
+// 输出金额非负，且单个金额与累计金额都不超过 MaxMoney。
+func (tx *Transaction) CheckFormat() error {
+	if !tx.HasValidID() {
+		return fmt.Errorf("transaction id %x does not match its content", tx.ID)
+	}
+	if tx.Version != 0 && !tx.hasScripts() {
+		return fmt.Errorf("unsupported transaction version %d", tx.Version)
+	}
+	if tx.LockTime < 0 || (tx.LockTime != 0 && !tx.hasScripts()) {
+		return fmt.Errorf("lock time %d is not allowed in a version %d transaction", tx.LockTime, tx.Version)
+	}
+	for txiIndex, txi := range tx.Inputs {
+		if txi.Sequence&^(SequenceTimeFlag|SequenceMask) != 0 || (txi.Sequence != 0 && !tx.hasScripts()) {
+			return fmt.Errorf("input %d has sequence %#x not allowed in a version %d transaction", txiIndex, txi.Sequence, tx.Version)
+		}
+		if len(txi.Script) == 0 {
+			continue
+		}
+		if !tx.hasScripts() {
+			return fmt.Errorf("input %d has a script but the transaction version is %d", txiIndex, tx.Version)
+		}
+		if len(txi.Signature) != 0 || len(txi.Pubkey) != 0 || len(txi.Signatures) != 0 {
+			return fmt.Errorf("input %d has both a script and signatures", txiIndex)
+		}
+	}
+	total := 0
+	for txoIndex, txo := range tx.Outputs {
+		var err error
+		total, err = AddMoney(total, txo.Value)
+		if err != nil {
+			return fmt.Errorf("output %d: %v", txoIndex, err)
+		}
+		if len(txo.Script) == 0 {
+			continue
+		}
+		if !tx.hasScripts() {
+			return fmt.Errorf("output %d has a script but the transaction version is %d", txoIndex, tx.Version)
+		}
+		if len(txo.PubkeyHash) != 0 || txo.ScriptHash {
+			return fmt.Errorf("output %d has both a script and a public key hash", txoIndex)
+		}
+		if txo.IsUnspendable() {
+			data, ok := txo.Data()
+			if !ok || len(data) > script.MaxNullDataSize {
+				return fmt.Errorf("output %d is not a data output of at most %d bytes", txoIndex, script.MaxNullDataSize)
+			}
+			if txo.Value != 0 {
+				return fmt.Errorf("data output %d carries value %d", txoIndex, txo.Value)
+			}
+		}
+	}
+	return nil
 }
 
 // 对每笔交易输入签名。
@@ -68,29 +183,97 @@ func (tx *Transaction) Sign(privkey ecdsa.PrivateKey, refTxs map[string]*Transac
 	}
 
 	// 对交易的每一笔输入签名。
-	txCopy := tx.noSigCopy()
-	for txiIndex, txi := range txCopy.Inputs {
-		// 获取签名的对象。
-		prevTx := refTxs[hex.EncodeToString(txi.RefID)]
-		txCopy.Inputs[txiIndex].Signature = nil
-		txCopy.Inputs[txiIndex].Pubkey = prevTx.Outputs[txi.RefIndex].PubkeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[txiIndex].Pubkey = nil
+	for txiIndex := range tx.Inputs {
+		tx.Inputs[txiIndex].Signature = signDigest(privkey, tx.signatureHash(txiIndex, refTxs))
+	}
+}
 
-		// 存储 ECDSA 数字签名。
-		r, s, err := ecdsa.Sign(rand.Reader, &privkey, txCopy.ID)
-		if err != nil {
-			panic(err)
+// 只对可由指定私钥签名的交易输入签名，返回签名的输入数量：
+// 引用的输出被该私钥锁定时，填入签名和公钥；
+// 消费多重签名输出、且赎回脚本包含该公钥时，填入该公钥对应位置的签名。
+// 交易的输入属于多个私钥时，由各私钥的持有者分别签名。
+func (tx *Transaction) SignInputs(privkey ecdsa.PrivateKey, pubkey []byte, refTxs map[string]*Transaction) int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	pubkeyHash := utils.GetPubkeyHash(pubkey)
+	signed := 0
+	for txiIndex, txi := range tx.Inputs {
+		if txi.IsMultisig() {
+			script, err := ParseMultisigScript(txi.Pubkey)
+			if err != nil {
+				continue
+			}
+			index := script.indexOf(pubkey)
+			if index < 0 || index >= len(txi.Signatures) || len(txi.Signatures[index]) != 0 {
+				continue
+			}
+			txi.Signatures[index] = signDigest(privkey, tx.signatureHash(txiIndex, refTxs))
+			signed++
+			continue
+		}
+
+		prevTx := refTxs[hex.EncodeToString(txi.RefID)]
+		if !prevTx.Outputs[txi.RefIndex].IsUnlockableWith(pubkeyHash) {
+			continue
 		}
-		tx.Inputs[txiIndex].Signature = append(r.Bytes(), s.Bytes()...)
+		txi.Pubkey = pubkey
+		txi.Signature = signDigest(privkey, tx.signatureHash(txiIndex, refTxs))
+		signed++
 	}
+	return signed
+}
+
+// 获取交易输入的签名，用于填入自定义的解锁脚本。解锁脚本不参与签名，因此可以先签名再填入。
+func (tx *Transaction) SignInput(txiIndex int, privkey ecdsa.PrivateKey, refTxs map[string]*Transaction) []byte {
+	return signDigest(privkey, tx.signatureHash(txiIndex, refTxs))
+}
+
+// 获取交易输入签名的对象：在无签名副本中，将该输入的公钥替换为被引用输出的公钥哈希后取哈希值。
+// ScriptVersion 版本的交易改为将该输入的解锁脚本替换为被引用输出的锁定脚本。
+// AmountVersion 版本的交易在此基础上，再与每一笔输入所引用输出的金额一起取哈希值。
+func (tx *Transaction) signatureHash(txiIndex int, refTxs map[string]*Transaction) []byte {
+	txCopy := tx.noSigCopy()
+	txi := txCopy.Inputs[txiIndex]
+	prevTxo := refTxs[hex.EncodeToString(txi.RefID)].Outputs[txi.RefIndex]
+	if tx.hasScripts() {
+		txi.Script = prevTxo.LockingScript()
+	} else {
+		txi.Pubkey = prevTxo.PubkeyHash
+	}
+	if tx.Version != AmountVersion {
+		return txCopy.Hash()
+	}
+
+	var buffer bytes.Buffer
+	buffer.Write(txCopy.Hash())
+	for _, input := range txCopy.Inputs {
+		writeInt(&buffer, refTxs[hex.EncodeToString(input.RefID)].Outputs[input.RefIndex].Value)
+	}
+	hash := sha256.Sum256(buffer.Bytes())
+	return hash[:]
 }
 
-// 验证交易输入的签名。
-func (tx *Transaction) Verify(refTxs map[string]*Transaction) bool {
+// 生成 ECDSA 数字签名。r 和 s 补齐到相同长度，验证时才能从中间切分。
+func signDigest(privkey ecdsa.PrivateKey, digest []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privkey, digest)
+	if err != nil {
+		panic(err)
+	}
+	size := (privkey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature
+}
+
+// 验证交易输入：以引用的输出的锁定脚本执行每一笔输入的解锁脚本，全部成功时交易有效。
+// 锁定时间是否到达不在此检查，见 IsFinal。
+func (tx *Transaction) Verify(refTxs map[string]*Transaction) error {
 	// 如果当前交易是 coinbase 交易，就不用验证。
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
 	// 检查交易输入所属的交易的 ID 是否正确。
@@ -100,47 +283,92 @@ func (tx *Transaction) Verify(refTxs map[string]*Transaction) bool {
 		}
 	}
 
-	// 验证交易的每一笔输入的签名。
-	txCopy := tx.noSigCopy()
-	curve := elliptic.P256()
+	// 执行交易的每一笔输入的脚本。
 	for txiIndex, txi := range tx.Inputs {
-		// 获取与签名时相同的对象。
-		prevTX := refTxs[hex.EncodeToString(txi.RefID)]
-		txCopy.Inputs[txiIndex].Signature = nil
-		txCopy.Inputs[txiIndex].Pubkey = prevTX.Outputs[txi.RefIndex].PubkeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[txiIndex].Pubkey = nil
-
-		// 解析签名数据。
-		sigLen := len(txi.Signature)
-		r := utils.BytesToBigInt(txi.Signature[:(sigLen / 2)])
-		s := utils.BytesToBigInt(txi.Signature[(sigLen / 2):])
+		prevTxo := refTxs[hex.EncodeToString(txi.RefID)].Outputs[txi.RefIndex]
+		checker := &txChecker{tx, txiIndex, refTxs}
+		err := script.Verify(txi.UnlockingScript(), prevTxo.LockingScript(), checker)
+		if err != nil {
+			return fmt.Errorf("input %d: %s", txiIndex, err)
+		}
+	}
+	return nil
+}
 
-		// 解析公钥数据。
-		keyLen := len(txi.Pubkey)
-		x := utils.BytesToBigInt(txi.Pubkey[:(keyLen / 2)])
-		y := utils.BytesToBigInt(txi.Pubkey[(keyLen / 2):])
+// 执行交易输入的脚本时使用的签名和锁定时间校验。
+type txChecker struct {
+	tx       *Transaction
+	txiIndex int
+	refTxs   map[string]*Transaction
+}
 
-		supposedPubkey := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
-		if !ecdsa.Verify(&supposedPubkey, txCopy.ID, r, s) {
-			return false
-		}
+// 用公钥验证签名，签名对象与签名时相同。公钥必须是曲线上的点。
+func (c *txChecker) CheckSig(signature []byte, pubkey []byte) bool {
+	curve := elliptic.P256()
+	size := (curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size || len(pubkey) != 2*size {
+		return false
+	}
+	if !curve.IsOnCurve(utils.BytesToBigInt(pubkey[:size]), utils.BytesToBigInt(pubkey[size:])) {
+		return false
 	}
-	return true
+	return verifySignature(pubkey, c.tx.signatureHash(c.txiIndex, c.refTxs), signature)
+}
+
+// 脚本要求的锁定时间须被交易的锁定时间满足。
+func (c *txChecker) CheckLockTime(lockTime int64) bool {
+	return c.tx.lockTimeReached(lockTime)
+}
+
+// 用公钥验证 ECDSA 数字签名。公钥和签名都由等长的两半拼接而成。
+func verifySignature(pubkey []byte, digest []byte, signature []byte) bool {
+	// 解析签名数据。
+	sigLen := len(signature)
+	r := utils.BytesToBigInt(signature[:(sigLen / 2)])
+	s := utils.BytesToBigInt(signature[(sigLen / 2):])
+
+	// 解析公钥数据。
+	keyLen := len(pubkey)
+	x := utils.BytesToBigInt(pubkey[:(keyLen / 2)])
+	y := utils.BytesToBigInt(pubkey[(keyLen / 2):])
+
+	supposedPubkey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	return ecdsa.Verify(&supposedPubkey, digest, r, s)
 }
 
 // 打印交易信息。
 func (tx *Transaction) Print() {
 	fmt.Printf("  ID: %x\n", tx.ID)
+	if tx.Version != 0 {
+		fmt.Printf("  Version: %d\n", tx.Version)
+	}
+	if tx.LockTime != 0 {
+		fmt.Printf("  LockTime: %d\n", tx.LockTime)
+	}
 	for txiIndex, txi := range tx.Inputs {
 		fmt.Printf("  Input %d:\n", txiIndex)
 		fmt.Printf("    RefID:        %x\n", txi.RefID)
 		fmt.Printf("    RefIndex:     %d\n", txi.RefIndex)
+		if txi.IsMultisig() {
+			fmt.Printf("    Signatures:   %d slots\n", len(txi.Signatures))
+		}
+		if txi.Sequence != 0 {
+			fmt.Printf("    Sequence:     %#x\n", txi.Sequence)
+		}
+		if len(txi.Script) != 0 {
+			fmt.Printf("    Script:       %s\n", script.Disassemble(txi.Script))
+		}
 	}
 	for txoIndex, txo := range tx.Outputs {
 		fmt.Printf("  Output %d:\n", txoIndex)
 		fmt.Printf("    Value:        %d\n", txo.Value)
-		fmt.Printf("    PubkeyHash:   %x\n", txo.PubkeyHash)
+		if len(txo.Script) != 0 {
+			fmt.Printf("    Script:       %s\n", script.Disassemble(txo.Script))
+		} else if txo.ScriptHash {
+			fmt.Printf("    ScriptHash:   %x\n", txo.PubkeyHash)
+		} else {
+			fmt.Printf("    PubkeyHash:   %x\n", txo.PubkeyHash)
+		}
 	}
 }
 
@@ -156,3 +384,16 @@ func (tx *Transaction) Serialize() []byte {
 
 	return buffer.Bytes()
 }
+
+// 反序列化交易。
+func DeserializeTransaction(seq []byte) *Transaction {
+	var tx Transaction
+
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	err := decoder.Decode(&tx)
+	if err != nil {
+		panic(err)
+	}
+
+	return &tx
+}
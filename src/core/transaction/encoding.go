@@ -0,0 +1,69 @@
+package transaction
+
+import (
+	"blockchain/utils"
+	"bytes"
+)
+
+// 获取交易的规范编码，用于计算哈希值和交易大小。
+// gob 编码内含的类型编号取决于进程内各类型首次编码的顺序，
+// 同一笔交易在不同节点上可能得到不同的字节，因此不能用于哈希。
+// 格式：整数均为 8 字节大端序，字节串前附加其长度。
+// 多重签名的输入和输出只扩展签名和锁定数据的内容，普通交易的编码保持不变。
+// ScriptVersion 与 AmountVersion 版本的交易以 -1 开头，随后是版本号，输入和输出分别编码解锁脚本和锁定脚本，
+// 输入还编码相对锁定时间，最后是交易的锁定时间。
+func (tx *Transaction) Encode() []byte {
+	var buffer bytes.Buffer
+
+	if tx.hasScripts() {
+		writeInt(&buffer, -1)
+		writeInt(&buffer, tx.Version)
+		writeBytes(&buffer, tx.ID)
+
+		writeInt(&buffer, len(tx.Inputs))
+		for _, txi := range tx.Inputs {
+			writeBytes(&buffer, txi.RefID)
+			writeInt(&buffer, txi.RefIndex)
+			writeInt(&buffer, txi.Sequence)
+			writeBytes(&buffer, txi.UnlockingScript())
+		}
+
+		writeInt(&buffer, len(tx.Outputs))
+		for _, txo := range tx.Outputs {
+			writeInt(&buffer, txo.Value)
+			writeBytes(&buffer, txo.LockingScript())
+		}
+
+		writeInt(&buffer, int(tx.LockTime))
+		return buffer.Bytes()
+	}
+
+	writeBytes(&buffer, tx.ID)
+
+	writeInt(&buffer, len(tx.Inputs))
+	for _, txi := range tx.Inputs {
+		writeBytes(&buffer, txi.RefID)
+		writeInt(&buffer, txi.RefIndex)
+		writeBytes(&buffer, txi.signatureData())
+		writeBytes(&buffer, txi.Pubkey)
+	}
+
+	writeInt(&buffer, len(tx.Outputs))
+	for _, txo := range tx.Outputs {
+		writeInt(&buffer, txo.Value)
+		writeBytes(&buffer, txo.lockData())
+	}
+
+	return buffer.Bytes()
+}
+
+// 写入整数。
+func writeInt(buffer *bytes.Buffer, value int) {
+	buffer.Write(utils.Int64ToBytes(int64(value)))
+}
+
+// 写入带长度前缀的字节串。
+func writeBytes(buffer *bytes.Buffer, data []byte) {
+	writeInt(buffer, len(data))
+	buffer.Write(data)
+}
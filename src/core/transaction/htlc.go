@@ -0,0 +1,91 @@
+package transaction
+
+import (
+	"blockchain/core/script"
+	"blockchain/utils"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// 公钥哈希的字节数。（RIPEMD-160）
+const pubkeyHashLen = 20
+
+// 哈希时间锁合约（HTLC）的赎回脚本：收款方出示哈希的原像即可领取，
+// 锁定时间到达后付款方可以取回。输出只记录脚本的哈希值，与多重签名地址相同。
+// 两条链上用同一个哈希各锁定一笔钱，即可进行原子交换：
+// 一方领取时公开了原像，另一方随即可以用它领取对方链上的钱。
+type HTLCScript struct {
+	SecretHash []byte // 原像的 SHA-256 哈希值。
+	Recipient  []byte // 收款方的公钥哈希。
+	Sender     []byte // 付款方的公钥哈希。
+	LockTime   int64  // 付款方可以取回的区块高度或 Unix 时间，规则同交易的锁定时间。
+}
+
+// 创建哈希时间锁合约的赎回脚本。
+func NewHTLCScript(secretHash []byte, recipient []byte, sender []byte, lockTime int64) (*HTLCScript, error) {
+	if len(secretHash) != sha256.Size {
+		return nil, fmt.Errorf("secret hash must be %d bytes, got %d", sha256.Size, len(secretHash))
+	}
+	if len(recipient) != pubkeyHashLen || len(sender) != pubkeyHashLen {
+		return nil, fmt.Errorf("public key hashes must be %d bytes", pubkeyHashLen)
+	}
+	if lockTime <= 0 {
+		return nil, fmt.Errorf("lock time must be positive, got %d", lockTime)
+	}
+	return &HTLCScript{secretHash, recipient, sender, lockTime}, nil
+}
+
+// 解析赎回脚本的编码。
+func ParseHTLCScript(data []byte) (*HTLCScript, error) {
+	secretHash, recipient, sender, lockTime, err := script.ParseHashTimeLock(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewHTLCScript(secretHash, recipient, sender, lockTime)
+}
+
+// 获取赎回脚本的编码。
+func (s *HTLCScript) Encode() []byte {
+	return script.HashTimeLock(s.SecretHash, s.Recipient, s.Sender, s.LockTime)
+}
+
+// 获取赎回脚本的哈希值，即输出记录的脚本哈希。（SHA256 + RIPEMD）
+func (s *HTLCScript) Hash() []byte {
+	return utils.GetPubkeyHash(s.Encode())
+}
+
+// 判断原像是否与合约的哈希相符。
+func (s *HTLCScript) Matches(preimage []byte) bool {
+	hash := sha256.Sum256(preimage)
+	return bytes.Equal(hash[:], s.SecretHash)
+}
+
+// 获取收款方领取时的解锁脚本：签名、公钥、原像、OP_1，以及赎回脚本。
+func (s *HTLCScript) ClaimScript(signature []byte, pubkey []byte, preimage []byte) []byte {
+	return script.NewBuilder().AddData(signature).AddData(pubkey).AddData(preimage).
+		AddInt(1).AddData(s.Encode()).Script()
+}
+
+// 获取付款方取回时的解锁脚本：签名、公钥、OP_0，以及赎回脚本。
+func (s *HTLCScript) RefundScript(signature []byte, pubkey []byte) []byte {
+	return script.NewBuilder().AddData(signature).AddData(pubkey).
+		AddInt(0).AddData(s.Encode()).Script()
+}
+
+// 从领取合约的交易输入的解锁脚本中取出原像。
+// 原子交换中，一方在一条链上领取时公开了原像，另一方据此领取另一条链上的钱。
+func (s *HTLCScript) ExtractPreimage(unlocking []byte) ([]byte, error) {
+	items, err := script.PushedData(unlocking)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) != 5 || !bytes.Equal(items[4], s.Encode()) || !bytes.Equal(items[3], []byte{1}) {
+		return nil, errors.New("not a claim of the contract")
+	}
+	if !s.Matches(items[2]) {
+		return nil, errors.New("preimage does not match the secret hash of the contract")
+	}
+	return items[2], nil
+}
@@ -1,6 +1,7 @@
 package transaction
 
 import (
+	"blockchain/core/script"
 	"blockchain/utils"
 	"bytes"
 )
@@ -10,7 +11,11 @@ type TxInput struct {
 	RefID     []byte // 引用输出所属交易的 ID。
 	RefIndex  int    // 引用输出在上一笔交易所有输出中的索引。
 	Signature []byte // 发起者的数字签名。
-	Pubkey    []byte // 用于锁定的公钥。
+	Pubkey    []byte // 用于锁定的公钥；消费多重签名输出时为赎回脚本的编码。
+
+	Signatures [][]byte // 消费多重签名输出时的签名，与赎回脚本中的公钥一一对应，未签名的位置为空。
+	Script     []byte   // 自定义的解锁脚本，此时以上三项为空；为空时由签名和公钥生成。
+	Sequence   int      // 相对锁定时间，见 RelativeLock；为 0 时不锁定。
 }
 
 // 创建交易输入。
@@ -23,6 +28,59 @@ func NewTxi(refID []byte, refIndex int, signature []byte, pubkey []byte) *TxInpu
 	}
 }
 
+// 创建消费多重签名输出的交易输入，各签名位置为空。
+func NewMultisigTxi(refID []byte, refIndex int, script *MultisigScript) *TxInput {
+	return &TxInput{
+		RefID:      refID,
+		RefIndex:   refIndex,
+		Pubkey:     script.Encode(),
+		Signatures: make([][]byte, len(script.Pubkeys)),
+	}
+}
+
+// 判断交易输入是否消费多重签名输出。
+func (txi *TxInput) IsMultisig() bool {
+	return len(txi.Signatures) != 0
+}
+
+// 获取参与规范编码的签名数据：普通输入为签名本身，多重签名输入为签名数量及带长度前缀的各个签名。
+func (txi *TxInput) signatureData() []byte {
+	if !txi.IsMultisig() {
+		return txi.Signature
+	}
+	var buffer bytes.Buffer
+	writeInt(&buffer, len(txi.Signatures))
+	for _, signature := range txi.Signatures {
+		writeBytes(&buffer, signature)
+	}
+	return buffer.Bytes()
+}
+
+// 获取解锁脚本。未指定自定义脚本时：
+// 普通输入依次压入签名和公钥；
+// 多重签名输入按公钥顺序压入前 M 个签名，最后压入赎回脚本。
+func (txi *TxInput) UnlockingScript() []byte {
+	if len(txi.Script) != 0 {
+		return txi.Script
+	}
+
+	b := script.NewBuilder()
+	if !txi.IsMultisig() {
+		return b.AddData(txi.Signature).AddData(txi.Pubkey).Script()
+	}
+	m := 0
+	if redeem, err := ParseMultisigScript(txi.Pubkey); err == nil {
+		m = redeem.M
+	}
+	for _, signature := range txi.Signatures {
+		if len(signature) != 0 && m > 0 {
+			b.AddData(signature)
+			m--
+		}
+	}
+	return b.AddData(txi.Pubkey).Script()
+}
+
 // 检验交易输入是否被指定公钥锁定。
 // 即：判断交易输入内的公钥，与传入的指定公钥，是不是同一把。
 func (txi *TxInput) IsLockedWith(pubkeyHash []byte) bool {
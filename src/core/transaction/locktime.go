@@ -0,0 +1,45 @@
+package transaction
+
+// 锁定时间的分界：小于该值时为区块高度，否则为 Unix 时间。
+const LockTimeThreshold = 500000000
+
+// 相对锁定时间的编码：Sequence 的低 16 位为数值，
+// 设置 SequenceTimeFlag 时以 2^SequenceGranularity 秒为单位，否则以区块为单位。
+const (
+	SequenceTimeFlag    = 1 << 22
+	SequenceMask        = 0xffff
+	SequenceGranularity = 9
+)
+
+// 判断交易能否被打包进高度为 height 的区块。
+// 锁定时间为 0 时总是可以；为区块高度时 height 不小于它；
+// 为 Unix 时间时 medianTime（前一个区块及之前共 11 个区块时间戳的中位数）不小于它。
+func (tx *Transaction) IsFinal(height int, medianTime int64) bool {
+	switch {
+	case tx.LockTime == 0:
+		return true
+	case tx.LockTime < LockTimeThreshold:
+		return int64(height) >= tx.LockTime
+	}
+	return medianTime >= tx.LockTime
+}
+
+// 获取交易输入的相对锁定时间，即被引用的输出上链后需要等待的区块数或秒数。
+// 返回值依次为数值和是否以秒计；Sequence 为 0 时没有相对锁定，数值为 0。
+func (txi *TxInput) RelativeLock() (int64, bool) {
+	value := int64(txi.Sequence & SequenceMask)
+	if txi.Sequence&SequenceTimeFlag != 0 {
+		return value << SequenceGranularity, true
+	}
+	return value, false
+}
+
+// 判断脚本要求的锁定时间是否被交易的锁定时间满足：
+// 两者同为区块高度或同为 Unix 时间，且脚本要求的不大于交易的。
+// 交易能否上链由 IsFinal 决定，因此脚本中的锁定时间到达之前，消费它的交易无法上链。
+func (tx *Transaction) lockTimeReached(lockTime int64) bool {
+	if (lockTime < LockTimeThreshold) != (tx.LockTime < LockTimeThreshold) {
+		return false
+	}
+	return lockTime <= tx.LockTime
+}
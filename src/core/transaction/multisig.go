@@ -0,0 +1,74 @@
+package transaction
+
+import (
+	"blockchain/core/script"
+	"blockchain/utils"
+	"bytes"
+	"crypto/elliptic"
+	"fmt"
+)
+
+// 多重签名赎回脚本最多包含的公钥数。
+const MaxMultisigKeys = 15
+
+// 多重签名赎回脚本：n 个公钥中任意 M 个的签名即可解锁。
+// 输出只记录脚本的哈希值，消费时在输入中给出完整的脚本。
+type MultisigScript struct {
+	M       int      // 解锁所需的签名数。
+	Pubkeys [][]byte // 参与者的公钥，输入中的签名按相同顺序排列。
+}
+
+// 创建多重签名赎回脚本。
+func NewMultisigScript(m int, pubkeys [][]byte) (*MultisigScript, error) {
+	n := len(pubkeys)
+	if n == 0 || n > MaxMultisigKeys {
+		return nil, fmt.Errorf("multisig needs 1 to %d public keys, got %d", MaxMultisigKeys, n)
+	}
+	if m < 1 || m > n {
+		return nil, fmt.Errorf("multisig needs 1 to %d signatures, got %d", n, m)
+	}
+
+	curve := elliptic.P256()
+	size := (curve.Params().BitSize + 7) / 8
+	seen := make(map[string]bool)
+	for _, pubkey := range pubkeys {
+		if len(pubkey) != 2*size || !curve.IsOnCurve(utils.BytesToBigInt(pubkey[:size]), utils.BytesToBigInt(pubkey[size:])) {
+			return nil, fmt.Errorf("invalid public key %x", pubkey)
+		}
+		if seen[string(pubkey)] {
+			return nil, fmt.Errorf("duplicate public key %x", pubkey)
+		}
+		seen[string(pubkey)] = true
+	}
+
+	return &MultisigScript{m, pubkeys}, nil
+}
+
+// 解析赎回脚本的编码。
+func ParseMultisigScript(data []byte) (*MultisigScript, error) {
+	m, pubkeys, err := script.ParseMultisig(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewMultisigScript(m, pubkeys)
+}
+
+// 获取赎回脚本的编码：OP_m <公钥 1> ... <公钥 n> OP_n OP_CHECKMULTISIG
+func (s *MultisigScript) Encode() []byte {
+	return script.Multisig(s.M, s.Pubkeys)
+}
+
+// 获取赎回脚本的哈希值，即输出记录的脚本哈希。（SHA256 + RIPEMD）
+func (s *MultisigScript) Hash() []byte {
+	return utils.GetPubkeyHash(s.Encode())
+}
+
+// 获取公钥在脚本中的位置，不存在时返回 -1。
+func (s *MultisigScript) indexOf(pubkey []byte) int {
+	for index, candidate := range s.Pubkeys {
+		if bytes.Equal(candidate, pubkey) {
+			return index
+		}
+	}
+	return -1
+}
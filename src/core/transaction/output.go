@@ -1,27 +1,91 @@
 package transaction
 
 import (
+	"blockchain/core/script"
 	"blockchain/utils"
 	"bytes"
+	"fmt"
 )
 
+// 多重签名地址的版本号。
+const ScriptHashVersion = byte(0x05)
+
+// 金额的上限。单个输出的金额，以及交易或区块内金额的累计值都不能超过它。
+const MaxMoney = 21000000 * 100000000
+
+// 将金额 value 累加到 sum 上。任一金额为负数或超过 MaxMoney，或者累加结果超过 MaxMoney 时返回错误。
+// 先比较再相加，累加本身不会溢出。
+func AddMoney(sum int, value int) (int, error) {
+	if sum < 0 || sum > MaxMoney || value < 0 || value > MaxMoney {
+		return 0, fmt.Errorf("amount %d or %d is out of range [0, %d]", sum, value, MaxMoney)
+	}
+	if value > MaxMoney-sum {
+		return 0, fmt.Errorf("total of %d and %d exceeds %d", sum, value, MaxMoney)
+	}
+	return sum + value, nil
+}
+
 // 交易输出结构。
 type TxOutput struct {
 	Value      int    // 交易输出存储的价值。
-	PubkeyHash []byte // 公钥哈希值。
+	PubkeyHash []byte // 公钥哈希值；ScriptHash 为 true 时是多重签名赎回脚本的哈希值。
+	ScriptHash bool   // 是否锁定到多重签名赎回脚本。
+	Script     []byte // 自定义的锁定脚本，此时 PubkeyHash 为空；为空时由 PubkeyHash 生成。
 }
 
-// 创建交易输出。
+// 创建交易输出。地址的版本号为 ScriptHashVersion 时，输出锁定到多重签名赎回脚本。
 func NewTxo(value int, address string) *TxOutput {
+	pubkeyHash, scriptHash := ParseAddress(address)
+	return &TxOutput{Value: value, PubkeyHash: pubkeyHash, ScriptHash: scriptHash}
+}
+
+// 创建锁定到自定义脚本的交易输出。只能出现在 ScriptVersion 及之后版本的交易中。
+func NewScriptTxo(value int, locking []byte) *TxOutput {
+	return &TxOutput{Value: value, Script: locking}
+}
+
+// 解析地址，得到其中的哈希值，以及是否为多重签名地址。
+func ParseAddress(address string) ([]byte, bool) {
 	payload := utils.Base58Decode([]byte(address))
-	pubkeyHash := payload[1 : len(payload)-utils.ChecksumLen]
+	return payload[1 : len(payload)-utils.ChecksumLen], payload[0] == ScriptHashVersion
+}
+
+// 获取参与规范编码的锁定数据：普通输出为公钥哈希，多重签名输出在脚本哈希前附加版本号。
+func (txo *TxOutput) lockData() []byte {
+	if !txo.ScriptHash {
+		return txo.PubkeyHash
+	}
+	return append([]byte{ScriptHashVersion}, txo.PubkeyHash...)
+}
+
+// 创建携带数据的交易输出，金额为 0，无法被消费。只能出现在 ScriptVersion 及之后版本的交易中。
+func NewDataTxo(data []byte) *TxOutput {
+	return &TxOutput{Value: 0, Script: script.NullData(data)}
+}
+
+// 获取数据输出携带的数据。不是数据输出时返回 false。
+func (txo *TxOutput) Data() ([]byte, bool) {
+	return script.ParseNullData(txo.Script)
+}
+
+// 判断交易输出是否可证明无法消费。这类输出不进入未消费输出集。
+func (txo *TxOutput) IsUnspendable() bool {
+	return script.IsUnspendable(txo.Script)
+}
 
-	txo := TxOutput{value, pubkeyHash}
-	return &txo
+// 获取锁定脚本。未指定自定义脚本时，普通输出为 P2PKH 脚本，多重签名输出为 P2SH 脚本。
+func (txo *TxOutput) LockingScript() []byte {
+	switch {
+	case len(txo.Script) != 0:
+		return txo.Script
+	case txo.ScriptHash:
+		return script.PayToScriptHash(txo.PubkeyHash)
+	}
+	return script.PayToPubkeyHash(txo.PubkeyHash)
 }
 
 // 检验交易输出是否能被指定公钥解锁。
 // 即：判断交易输出内的公钥，与传入的指定公钥，是不是同一把。
 func (txo *TxOutput) IsUnlockableWith(pubkeyHash []byte) bool {
-	return bytes.Equal(txo.PubkeyHash, pubkeyHash)
+	return len(txo.Script) == 0 && !txo.ScriptHash && bytes.Equal(txo.PubkeyHash, pubkeyHash)
 }
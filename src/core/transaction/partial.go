@@ -0,0 +1,185 @@
+package transaction
+
+import (
+	"blockchain/utils"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// 部分签名交易的格式版本。
+const partialTxVersion = 1
+
+// 部分签名交易：尚未签名完成的交易，连同签名和验证所需的被引用交易。
+// 以 JSON 格式在联网机器与离线签名的机器之间传递，签名时无需访问区块链。
+type PartialTx struct {
+	Version int            // 格式版本。
+	Tx      *Transaction   // 待签名的交易。
+	RefTxs  []*Transaction // 交易输入引用的交易，按 ID 排列。
+}
+
+// 创建部分签名交易。
+func NewPartialTx(tx *Transaction, refTxs map[string]*Transaction) *PartialTx {
+	p := &PartialTx{Version: partialTxVersion, Tx: tx}
+	for _, refTx := range refTxs {
+		p.RefTxs = append(p.RefTxs, refTx)
+	}
+	sort.Slice(p.RefTxs, func(i, j int) bool {
+		return bytes.Compare(p.RefTxs[i].ID, p.RefTxs[j].ID) < 0
+	})
+	return p
+}
+
+// 解析 JSON 格式的部分签名交易，并检查每一笔被引用交易的内容与其 ID 相符，
+// 交易的每一笔输入都能找到被引用的输出，消费多重签名输出的输入带有相符的赎回脚本。
+// 被引用交易由创建方提供，ID 相符才能保证其中的金额与链上一致，据此计算的交易费可信。
+func ParsePartialTx(data []byte) (*PartialTx, error) {
+	var p PartialTx
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Version != partialTxVersion {
+		return nil, fmt.Errorf("unsupported partial transaction version %d", p.Version)
+	}
+	if p.Tx == nil || p.Tx.IsCoinbase() {
+		return nil, errors.New("partial transaction has no spending transaction")
+	}
+
+	for _, refTx := range p.RefTxs {
+		if refTx == nil {
+			return nil, errors.New("partial transaction has an empty referenced transaction")
+		}
+		if !refTx.HasValidID() {
+			return nil, fmt.Errorf("referenced transaction %x does not match its content", refTx.ID)
+		}
+	}
+
+	refTxs := p.refTxMap()
+	for _, txi := range p.Tx.Inputs {
+		refTx := refTxs[hex.EncodeToString(txi.RefID)]
+		if refTx == nil || txi.RefIndex < 0 || txi.RefIndex >= len(refTx.Outputs) {
+			return nil, fmt.Errorf("output %x:%d referenced by the transaction is missing", txi.RefID, txi.RefIndex)
+		}
+		if refTx.Outputs[txi.RefIndex].ScriptHash {
+			script, err := ParseMultisigScript(txi.Pubkey)
+			if err != nil || !bytes.Equal(script.Hash(), refTx.Outputs[txi.RefIndex].PubkeyHash) || len(txi.Signatures) != len(script.Pubkeys) {
+				return nil, fmt.Errorf("input spending multisig output %x:%d has no matching redeem script", txi.RefID, txi.RefIndex)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// 编码为 JSON 格式。
+func (p *PartialTx) Marshal() []byte {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// 获取还能为交易签名的公钥哈希，去除重复：
+// 尚未签名的普通输入引用的输出的公钥哈希，以及签名不足的多重签名输入中尚未签名的公钥的哈希。
+func (p *PartialTx) Signers() [][]byte {
+	refTxs := p.refTxMap()
+	var signers [][]byte
+	seen := make(map[string]bool)
+	add := func(pubkeyHash []byte) {
+		if !seen[hex.EncodeToString(pubkeyHash)] {
+			seen[hex.EncodeToString(pubkeyHash)] = true
+			signers = append(signers, pubkeyHash)
+		}
+	}
+
+	for _, txi := range p.Tx.Inputs {
+		if txi.IsMultisig() {
+			script, err := ParseMultisigScript(txi.Pubkey)
+			if err != nil || isSigned(txi) {
+				continue
+			}
+			for index, pubkey := range script.Pubkeys {
+				if len(txi.Signatures[index]) == 0 {
+					add(utils.GetPubkeyHash(pubkey))
+				}
+			}
+		} else if len(txi.Signature) == 0 && len(txi.Script) == 0 {
+			prevTxo := refTxs[hex.EncodeToString(txi.RefID)].Outputs[txi.RefIndex]
+			if len(prevTxo.Script) == 0 {
+				add(prevTxo.PubkeyHash)
+			}
+		}
+	}
+	return signers
+}
+
+// 用私钥对被其锁定的交易输入签名，返回签名的输入数量。
+func (p *PartialTx) Sign(privkey ecdsa.PrivateKey, pubkey []byte) int {
+	return p.Tx.SignInputs(privkey, pubkey, p.refTxMap())
+}
+
+// 判断交易的每一笔输入是否都已签名，多重签名输入需要有足够数量的签名。
+func (p *PartialTx) IsComplete() bool {
+	for _, txi := range p.Tx.Inputs {
+		if !isSigned(txi) {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断交易输入是否已签名。带自定义解锁脚本的输入视为已签名。
+func isSigned(txi *TxInput) bool {
+	if len(txi.Script) != 0 {
+		return true
+	}
+	if !txi.IsMultisig() {
+		return len(txi.Signature) != 0
+	}
+	script, err := ParseMultisigScript(txi.Pubkey)
+	if err != nil {
+		return false
+	}
+	signed := 0
+	for _, signature := range txi.Signatures {
+		if len(signature) != 0 {
+			signed++
+		}
+	}
+	return signed >= script.M
+}
+
+// 验证交易输入的签名。
+func (p *PartialTx) Verify() error {
+	if !p.IsComplete() {
+		return errors.New("transaction is not completely signed")
+	}
+	return p.Tx.Verify(p.refTxMap())
+}
+
+// 计算交易费，即被引用的输出总额与交易输出总额之差。
+func (p *PartialTx) Fee() int {
+	refTxs := p.refTxMap()
+	fee := 0
+	for _, txi := range p.Tx.Inputs {
+		fee += refTxs[hex.EncodeToString(txi.RefID)].Outputs[txi.RefIndex].Value
+	}
+	for _, txo := range p.Tx.Outputs {
+		fee -= txo.Value
+	}
+	return fee
+}
+
+// 获取被引用的交易，以 ID 的十六进制字符串为键。
+func (p *PartialTx) refTxMap() map[string]*Transaction {
+	refTxs := make(map[string]*Transaction)
+	for _, refTx := range p.RefTxs {
+		refTxs[hex.EncodeToString(refTx.ID)] = refTx
+	}
+	return refTxs
+}
@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"blockchain/utils"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+// 创建一笔向新密钥支付 value 的被引用交易，以及消费它、支付 10 的交易。
+func newSpend(t *testing.T, version int, value int) (*Transaction, *Transaction, ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := (key.Curve.Params().BitSize + 7) / 8
+	pubkey := make([]byte, 2*size)
+	key.X.FillBytes(pubkey[:size])
+	key.Y.FillBytes(pubkey[size:])
+	pubkeyHash := utils.GetPubkeyHash(pubkey)
+
+	refTx := &Transaction{
+		Inputs:  []*TxInput{{RefIndex: -1, Pubkey: []byte("reward")}},
+		Outputs: []*TxOutput{{Value: value, PubkeyHash: pubkeyHash}},
+	}
+	refTx.ID = refTx.ComputeID()
+
+	tx := &Transaction{
+		Inputs:  []*TxInput{NewTxi(refTx.ID, 0, nil, pubkey)},
+		Outputs: []*TxOutput{{Value: 10, PubkeyHash: pubkeyHash}},
+		Version: version,
+	}
+	tx.ID = tx.ComputeID()
+	return refTx, tx, *key, pubkey
+}
+
+// 被引用交易的金额被改动时，部分签名交易无法解析。
+func TestParsePartialTxChecksRefTxIDs(t *testing.T) {
+	refTx, tx, _, _ := newSpend(t, AmountVersion, 50)
+	refTxs := map[string]*Transaction{hex.EncodeToString(refTx.ID): refTx}
+
+	p, err := ParsePartialTx(NewPartialTx(tx, refTxs).Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee := p.Fee(); fee != 40 {
+		t.Errorf("fee = %d, want 40", fee)
+	}
+
+	refTx.Outputs[0].Value = 1000
+	if _, err := ParsePartialTx(NewPartialTx(tx, refTxs).Marshal()); err == nil {
+		t.Error("parsed a partial transaction whose referenced transaction was altered")
+	}
+}
+
+// AmountVersion 版本的签名包含被引用输出的金额：按虚报的金额签名，在真实金额下无法通过验证。
+func TestSignatureCommitsToAmounts(t *testing.T) {
+	tests := []struct {
+		version int
+		commits bool
+	}{
+		{0, false},
+		{ScriptVersion, false},
+		{AmountVersion, true},
+	}
+
+	for _, test := range tests {
+		refTx, tx, privkey, pubkey := newSpend(t, test.version, 50)
+		refTxs := map[string]*Transaction{hex.EncodeToString(refTx.ID): refTx}
+
+		forged := *refTx
+		forged.Outputs = []*TxOutput{{Value: 11, PubkeyHash: refTx.Outputs[0].PubkeyHash}}
+		if signed := tx.SignInputs(privkey, pubkey, map[string]*Transaction{hex.EncodeToString(refTx.ID): &forged}); signed != 1 {
+			t.Fatalf("version %d: signed %d inputs, want 1", test.version, signed)
+		}
+
+		err := tx.Verify(refTxs)
+		if test.commits && err == nil {
+			t.Errorf("version %d: signature over a forged amount verifies", test.version)
+		}
+		if !test.commits && err != nil {
+			t.Errorf("version %d: %v", test.version, err)
+		}
+	}
+}
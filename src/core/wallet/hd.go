@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// 主密钥的 HMAC 密钥，取自 SLIP-0010 对 P-256 曲线的约定。
+const masterHmacKey = "Nist256p1 seed"
+
+// 硬化派生的起始索引。
+const hardenedOffset = 0x80000000
+
+// 派生收款地址的账户路径，地址依次位于其下的 0、1、2 …… 索引处。
+const accountPath = "m/44'/0'/0'/0"
+
+// 扩展私钥：私钥标量与链码。
+type extendedKey struct {
+	key       []byte // 32 字节私钥标量。
+	chainCode []byte // 32 字节链码。
+}
+
+// 由种子生成主扩展私钥。
+// 算法（SLIP-0010）：I = HMAC-SHA512("Nist256p1 seed", 种子)，左半为私钥，右半为链码；
+// 左半为 0 或不小于曲线阶时，以 I 代替种子重新计算。
+func newMasterKey(seed []byte) *extendedKey {
+	n := elliptic.P256().Params().N
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(masterHmacKey))
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return &extendedKey{sum[:32], sum[32:]}
+		}
+		data = sum
+	}
+}
+
+// 派生第 index 个子扩展私钥，index 不小于 hardenedOffset 时为硬化派生。
+// 算法（SLIP-0010）：I = HMAC-SHA512(链码, 数据)，子私钥 = (左半 + 父私钥) mod n，右半为子链码；
+// 硬化派生的数据为 0x00 + 父私钥 + 索引，普通派生的数据为压缩公钥 + 索引；
+// 左半不小于 n 或子私钥为 0 时，以 0x01 + 右半 + 索引为数据重新计算。
+func (k *extendedKey) child(index uint32) *extendedKey {
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	var data []byte
+	if index >= hardenedOffset {
+		data = append([]byte{0x00}, k.key...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.key)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = appendUint32(data, index)
+
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		childKey := new(big.Int).SetBytes(sum[:32])
+		if childKey.Cmp(n) < 0 {
+			childKey.Add(childKey, new(big.Int).SetBytes(k.key))
+			childKey.Mod(childKey, n)
+			if childKey.Sign() != 0 {
+				key := make([]byte, 32)
+				childKey.FillBytes(key)
+				return &extendedKey{key, sum[32:]}
+			}
+		}
+		data = appendUint32(append([]byte{0x01}, sum[32:]...), index)
+	}
+}
+
+// 按路径派生扩展私钥，路径形如 m/44'/0'/0'/0/5，带 ' 的层级为硬化派生。
+func (k *extendedKey) derive(path string) (*extendedKey, error) {
+	levels := strings.Split(path, "/")
+	if levels[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path %q", path)
+	}
+
+	key := k
+	for _, level := range levels[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(level, "'") {
+			offset = hardenedOffset
+			level = strings.TrimSuffix(level, "'")
+		}
+		index, err := strconv.ParseUint(level, 10, 32)
+		if err != nil || uint32(index) >= hardenedOffset {
+			return nil, fmt.Errorf("invalid derivation path %q", path)
+		}
+		key = key.child(uint32(index) + offset)
+	}
+	return key, nil
+}
+
+// 获取扩展私钥对应的 ECDSA 私钥。
+func (k *extendedKey) privateKey() ecdsa.PrivateKey {
+	return privateKeyFromScalar(k.key)
+}
+
+// 获取账户下第 index 个收款地址的派生路径。
+func addressPath(index int) string {
+	return fmt.Sprintf("%s/%d", accountPath, index)
+}
+
+// 追加 4 字节大端序整数。
+func appendUint32(data []byte, value uint32) []byte {
+	buffer := make([]byte, 4)
+	binary.BigEndian.PutUint32(buffer, value)
+	return append(data, buffer...)
+}
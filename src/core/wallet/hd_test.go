@@ -0,0 +1,61 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// SLIP-0010 nist256p1 的测试向量，逐层核对链码与私钥。
+func TestDeriveSlip10Vectors(t *testing.T) {
+	vectors := []struct {
+		seed string
+		keys []struct{ path, chainCode, key string }
+	}{
+		{
+			seed: "000102030405060708090a0b0c0d0e0f",
+			keys: []struct{ path, chainCode, key string }{
+				{"m", "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+				{"m/0'", "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+				{"m/0'/1", "4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c", "284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+				{"m/0'/1/2'", "98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318", "694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7"},
+				{"m/0'/1/2'/2", "ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0", "5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa"},
+				{"m/0'/1/2'/2/1000000000", "b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059", "21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119"},
+			},
+		},
+		{
+			seed: "fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542",
+			keys: []struct{ path, chainCode, key string }{
+				{"m", "96cd4465a9644e31528eda3592aa35eb39a9527769ce1855beafc1b81055e75d", "eaa31c2e46ca2962227cf21d73a7ef0ce8b31c756897521eb6c7b39796633357"},
+				{"m/0", "84e9c258bb8557a40e0d041115b376dd55eda99c0042ce29e81ebe4efed9b86a", "d7d065f63a62624888500cdb4f88b6d59c2927fee9e6d0cdff9cad555884df6e"},
+				{"m/0/2147483647'", "f235b2bc5c04606ca9c30027a84f353acf4e4683edbd11f635d0dcc1cd106ea6", "96d2ec9316746a75e7793684ed01e3d51194d81a42a3276858a5b7376d4b94b9"},
+			},
+		},
+	}
+
+	for _, vector := range vectors {
+		seed, _ := hex.DecodeString(vector.seed)
+		master := newMasterKey(seed)
+		for _, want := range vector.keys {
+			key, err := master.derive(want.path)
+			if err != nil {
+				t.Fatalf("%s: %v", want.path, err)
+			}
+			if got := hex.EncodeToString(key.chainCode); got != want.chainCode {
+				t.Errorf("%s: chain code %s, want %s", want.path, got, want.chainCode)
+			}
+			if got := hex.EncodeToString(key.key); got != want.key {
+				t.Errorf("%s: key %s, want %s", want.path, got, want.key)
+			}
+		}
+	}
+}
+
+// 格式错误的派生路径被拒绝。
+func TestDeriveRejectsInvalidPaths(t *testing.T) {
+	master := newMasterKey(make([]byte, 16))
+	for _, path := range []string{"", "0/1", "m/", "m/x", "m/-1", "m/2147483648", "m/1''"} {
+		if _, err := master.derive(path); err == nil {
+			t.Errorf("derived invalid path %q", path)
+		}
+	}
+}
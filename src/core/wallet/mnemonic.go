@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// BIP39 英文词表，共 2048 个单词。
+//
+//go:embed english.txt
+var englishWords string
+
+// 词表及单词到序号的映射。
+var wordList = strings.Fields(englishWords)
+var wordIndex = func() map[string]int {
+	index := make(map[string]int)
+	for i, word := range wordList {
+		index[word] = i
+	}
+	return index
+}()
+
+// 助记词的熵长度（位），对应 12 个单词。
+const entropyBits = 128
+
+// 由种子派生时，PBKDF2 的迭代次数。
+const seedIterations = 2048
+
+// 助记词无效。
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// 生成新的助记词。
+// 算法（BIP39）：随机熵后附加其 SHA-256 的前 熵长度/32 位作为校验和，每 11 位对应词表中的一个单词。
+func newMnemonic() string {
+	entropy := make([]byte, entropyBits/8)
+	_, err := rand.Read(entropy)
+	if err != nil {
+		panic(err)
+	}
+
+	checksumBits := entropyBits / 32
+	hash := sha256.Sum256(entropy)
+	bits := new(big.Int).SetBytes(entropy)
+	bits.Lsh(bits, uint(checksumBits))
+	bits.Or(bits, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	count := (entropyBits + checksumBits) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(bits, mask).Int64()]
+		bits.Rsh(bits, 11)
+	}
+	return strings.Join(words, " ")
+}
+
+// 检查助记词的单词和校验和，返回规范化（小写、单个空格分隔）的助记词。
+func checkMnemonic(mnemonic string) (string, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return "", ErrInvalidMnemonic
+	}
+
+	bits := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return "", ErrInvalidMnemonic
+		}
+		bits.Lsh(bits, 11)
+		bits.Or(bits, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) * 11 / 33
+	entropyLen := len(words) * 11 * 32 / 33 / 8
+	checksum := new(big.Int).And(bits, big.NewInt(int64(1)<<checksumBits-1)).Int64()
+	entropy := make([]byte, entropyLen)
+	bits.Rsh(bits, uint(checksumBits)).FillBytes(entropy)
+
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return "", ErrInvalidMnemonic
+	}
+	return strings.Join(words, " "), nil
+}
+
+// 由助记词派生种子：PBKDF2-HMAC-SHA512，盐为 "mnemonic"，迭代 2048 次，输出 64 字节。
+// 英文词表只含 ASCII 字符，NFKD 规范化不改变助记词。
+func mnemonicToSeed(mnemonic string) []byte {
+	return pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"), seedIterations, 64, sha512.New)
+}
@@ -0,0 +1,67 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// BIP39 英文测试向量的助记词都能通过校验，并被规范化。
+func TestCheckMnemonic(t *testing.T) {
+	valid := []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+	}
+	for _, mnemonic := range valid {
+		got, err := checkMnemonic(mnemonic)
+		if err != nil {
+			t.Errorf("%q: %v", mnemonic, err)
+		}
+		if got != mnemonic {
+			t.Errorf("%q normalized to %q", mnemonic, got)
+		}
+	}
+
+	got, err := checkMnemonic("  Legal WINNER thank year wave sausage\tworth useful legal winner thank yellow ")
+	if err != nil || got != valid[1] {
+		t.Errorf("mixed case and spacing: %q, %v", got, err)
+	}
+
+	invalid := []string{
+		// 校验和错误。
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo",
+		"legal winner thank year wave sausage worth useful legal winner thank thank",
+		// 单词数不符。
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		// 不在词表中的单词。
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon bitcoin",
+		"",
+	}
+	for _, mnemonic := range invalid {
+		if _, err := checkMnemonic(mnemonic); err != ErrInvalidMnemonic {
+			t.Errorf("%q: error %v, want %v", mnemonic, err, ErrInvalidMnemonic)
+		}
+	}
+}
+
+// 口令为空时由助记词派生的种子。
+func TestMnemonicToSeed(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	want := "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4"
+	if got := hex.EncodeToString(mnemonicToSeed(mnemonic)); got != want {
+		t.Errorf("seed %s, want %s", got, want)
+	}
+}
+
+// 新生成的助记词能通过校验。
+func TestNewMnemonicIsValid(t *testing.T) {
+	mnemonic := newMnemonic()
+	if _, err := checkMnemonic(mnemonic); err != nil {
+		t.Fatalf("%q: %v", mnemonic, err)
+	}
+}
@@ -1,37 +1,143 @@
 package wallet
 
 import (
+	"blockchain/core/script"
+	"blockchain/core/transaction"
 	"blockchain/utils"
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
 )
 
 // 当前版本号。
 const version = byte(0x00)
 
+// 导出私钥的版本号。
+const privkeyVersion = byte(0x80)
+
+// 私钥字符串无效。
+var ErrInvalidKey = errors.New("invalid private key")
+
+// 公钥无效。
+var ErrInvalidPubkey = errors.New("invalid public key")
+
 // 钱包结构。
 type wallet struct {
 	Privkey ecdsa.PrivateKey // 私钥。
 	Pubkey  []byte           // 公钥。
+	Path    string           // 由种子派生时的派生路径，随机生成的钱包为空。
+
+	WatchOnly bool // 是否只观察：没有私钥，不能签名；只给出地址时公钥也为空。
 }
 
 // 创建钱包。
 func newWallet() *wallet {
 	privkey, pubkey := newKeyPair()
-	return &wallet{privkey, pubkey}
+	return &wallet{Privkey: privkey, Pubkey: pubkey}
+}
+
+// 创建只观察的钱包，公钥未知时为 nil。
+func newWatchWallet(pubkey []byte) *wallet {
+	return &wallet{Pubkey: pubkey, WatchOnly: true}
+}
+
+// 检查公钥是否为 P-256 曲线上的点，格式与 publicKeyBytes 相同。
+func checkPubkey(pubkey []byte) error {
+	curve := elliptic.P256()
+	size := (curve.Params().BitSize + 7) / 8
+	if len(pubkey) != 2*size {
+		return ErrInvalidPubkey
+	}
+	x := new(big.Int).SetBytes(pubkey[:size])
+	y := new(big.Int).SetBytes(pubkey[size:])
+	if !curve.IsOnCurve(x, y) {
+		return ErrInvalidPubkey
+	}
+	return nil
+}
+
+// 由种子按派生路径创建钱包。
+func newHDWallet(seed []byte, path string) *wallet {
+	key, err := newMasterKey(seed).derive(path)
+	if err != nil {
+		panic(err)
+	}
+	privkey := key.privateKey()
+	return &wallet{Privkey: privkey, Pubkey: publicKeyBytes(&privkey), Path: path}
 }
 
 // 获取钱包地址。
 // 算法：地址 = (版本号 + 公钥哈希 + 校验和) 的 Base58 编码。
 func (w *wallet) address() string {
-	pubkeyHash := utils.GetPubkeyHash(w.Pubkey)
-	payload := append([]byte{version}, pubkeyHash...)
+	return AddressOf(utils.GetPubkeyHash(w.Pubkey))
+}
+
+// 由公钥哈希得到地址。
+func AddressOf(pubkeyHash []byte) string {
+	return encodeAddress(version, pubkeyHash)
+}
+
+// 由多重签名赎回脚本的哈希得到地址。
+func ScriptAddressOf(scriptHash []byte) string {
+	return encodeAddress(transaction.ScriptHashVersion, scriptHash)
+}
+
+// 获取交易输出锁定到的地址。锁定到自定义脚本的输出没有地址，返回携带的数据或脚本的反汇编。
+func OutputAddress(txo *transaction.TxOutput) string {
+	if data, ok := txo.Data(); ok {
+		return fmt.Sprintf("data %x", data)
+	}
+	if len(txo.Script) != 0 {
+		return "script " + script.Disassemble(txo.Script)
+	}
+	if txo.ScriptHash {
+		return ScriptAddressOf(txo.PubkeyHash)
+	}
+	return AddressOf(txo.PubkeyHash)
+}
+
+// 按版本号编码地址。
+func encodeAddress(version byte, hash []byte) string {
+	payload := append([]byte{version}, hash...)
 	checksum := utils.GetChecksum(payload)
 	payload = append(payload, checksum...)
 	return string(utils.Base58Encode(payload))
 }
 
+// 导出私钥。
+// 算法：私钥字符串 = (版本号 + 32 字节私钥标量 + 校验和) 的 Base58 编码。
+func (w *wallet) exportKey() string {
+	payload := make([]byte, 33)
+	payload[0] = privkeyVersion
+	w.Privkey.D.FillBytes(payload[1:])
+	payload = append(payload, utils.GetChecksum(payload)...)
+	return string(utils.Base58Encode(payload))
+}
+
+// 由导出的私钥字符串创建钱包。
+func importWallet(key string) (*wallet, error) {
+	payload := utils.Base58Decode([]byte(key))
+	if len(payload) != 33+utils.ChecksumLen || payload[0] != privkeyVersion {
+		return nil, ErrInvalidKey
+	}
+	checksum := payload[33:]
+	payload = payload[:33]
+	if !bytes.Equal(checksum, utils.GetChecksum(payload)) {
+		return nil, ErrInvalidKey
+	}
+
+	d := new(big.Int).SetBytes(payload[1:])
+	if d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, ErrInvalidKey
+	}
+	privkey := privateKeyFromScalar(payload[1:])
+	return &wallet{Privkey: privkey, Pubkey: publicKeyBytes(&privkey)}, nil
+}
+
 // 创建新公钥-私钥对。
 func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	// 椭圆加密产生私钥。
@@ -41,8 +147,24 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 		panic(err)
 	}
 
-	// 由私钥衍生出公钥。
-	pubkey := append(privkey.PublicKey.X.Bytes(), privkey.Y.Bytes()...)
+	return *privkey, publicKeyBytes(privkey)
+}
 
-	return *privkey, pubkey
+// 由私钥衍生出公钥：X、Y 坐标各自补齐到曲线的字节长度后拼接。
+func publicKeyBytes(privkey *ecdsa.PrivateKey) []byte {
+	size := (privkey.Curve.Params().BitSize + 7) / 8
+	pubkey := make([]byte, 2*size)
+	privkey.X.FillBytes(pubkey[:size])
+	privkey.Y.FillBytes(pubkey[size:])
+	return pubkey
+}
+
+// 由私钥的标量恢复出完整的私钥。
+func privateKeyFromScalar(d []byte) ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	var privkey ecdsa.PrivateKey
+	privkey.Curve = curve
+	privkey.D = new(big.Int).SetBytes(d)
+	privkey.X, privkey.Y = curve.ScalarBaseMult(d)
+	return privkey
 }
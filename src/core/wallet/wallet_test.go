@@ -0,0 +1,77 @@
+package wallet
+
+import (
+	"blockchain/utils"
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+)
+
+// 由版本号与私钥标量编码私钥字符串，校验和按实际内容计算。
+func encodeKey(version byte, scalar []byte) string {
+	payload := append([]byte{version}, scalar...)
+	payload = append(payload, utils.GetChecksum(payload)...)
+	return string(utils.Base58Encode(payload))
+}
+
+// 导出再导入的私钥与原私钥相同，包括首字节为 0 的私钥标量。
+func TestExportImportRoundTrip(t *testing.T) {
+	leadingZero := bytes.Repeat([]byte{0x01}, 32)
+	leadingZero[0] = 0
+	one := make([]byte, 32)
+	one[31] = 1
+
+	wallets := []*wallet{newWallet()}
+	for _, scalar := range [][]byte{leadingZero, one} {
+		privkey := privateKeyFromScalar(scalar)
+		wallets = append(wallets, &wallet{Privkey: privkey, Pubkey: publicKeyBytes(&privkey)})
+	}
+
+	for _, w := range wallets {
+		key := w.exportKey()
+		if payload := utils.Base58Decode([]byte(key)); len(payload) != 33+utils.ChecksumLen {
+			t.Errorf("exported key %s decodes to %d bytes", key, len(payload))
+		}
+		imported, err := importWallet(key)
+		if err != nil {
+			t.Fatalf("import %s: %v", key, err)
+		}
+		if imported.Privkey.D.Cmp(w.Privkey.D) != 0 || !bytes.Equal(imported.Pubkey, w.Pubkey) {
+			t.Errorf("import %s returned a different key", key)
+		}
+		if imported.address() != w.address() {
+			t.Errorf("import %s: address %s, want %s", key, imported.address(), w.address())
+		}
+	}
+}
+
+// 校验和、版本号、长度或标量范围不正确的私钥字符串被拒绝。
+func TestImportRejectsInvalidKeys(t *testing.T) {
+	scalar := bytes.Repeat([]byte{0x01}, 32)
+	valid := encodeKey(privkeyVersion, scalar)
+	if _, err := importWallet(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	badChecksum := utils.Base58Decode([]byte(valid))
+	badChecksum[len(badChecksum)-1] ^= 0x01
+
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"bad checksum", string(utils.Base58Encode(badChecksum))},
+		{"wrong version", encodeKey(privkeyVersion+1, scalar)},
+		{"address version", encodeKey(0x00, scalar)},
+		{"short scalar", encodeKey(privkeyVersion, scalar[:31])},
+		{"long scalar", encodeKey(privkeyVersion, append(scalar, 0x01))},
+		{"zero scalar", encodeKey(privkeyVersion, make([]byte, 32))},
+		{"scalar not below order", encodeKey(privkeyVersion, elliptic.P256().Params().N.Bytes())},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		if _, err := importWallet(test.key); err != ErrInvalidKey {
+			t.Errorf("%s: error %v, want %v", test.name, err, ErrInvalidKey)
+		}
+	}
+}
@@ -1,26 +1,115 @@
 package wallet
 
 import (
+	"blockchain/core/transaction"
 	"bytes"
-	"crypto/elliptic"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
 	"io/ioutil"
+	"math/big"
 	"os"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
 )
 
 // 钱包集数据库。
 const walletsDbPath = "wallets.dat"
 
+// 解锁缓存文件，在解锁期限内保存由口令导出的密钥。
+const unlockCachePath = "wallets.unlock"
+
+// 由口令导出密钥的 scrypt 参数。
+const scryptN = 1 << 15
+const scryptR = 8
+const scryptP = 1
+const keyLen = 32
+const saltLen = 16
+
+// 口令错误。
+var ErrWrongPassphrase = errors.New("incorrect passphrase")
+
+// 钱包集已加密。
+var ErrEncrypted = errors.New("wallet is already encrypted")
+
+// 钱包集未加密。
+var ErrNotEncrypted = errors.New("wallet is not encrypted")
+
+// 钱包集已有该地址。
+var ErrAddressExists = errors.New("wallet already contains this address")
+
+// 地址只被观察，没有私钥。
+var ErrWatchOnly = errors.New("address is watch-only")
+
+// 多重签名地址只能通过部分签名交易消费。
+var ErrMultisig = errors.New("multisig address must be spent with tx create and tx sign")
+
+// 钱包集已有该私钥。
+var ErrKeyExists = errors.New("wallet already contains this key")
+
+// 钱包集已有种子。
+var ErrHasSeed = errors.New("wallet already has a seed")
+
+// 重新扫描时，连续这么多个派生地址都未被使用，就认为之后的地址也未被使用。
+const DefaultGapLimit = 20
+
+// 钱包集被锁定时用于获取口令的函数，由命令行设置。为 nil 时无法自动解锁。
+var Prompt func(prompt string) ([]byte, error)
+
 // 钱包集结构。
 type wallets struct {
 	Map map[string]*wallet // 钱包地址 - 钱包内容。
+
+	scripts map[string][]byte // 多重签名地址 - 赎回脚本的编码。
+
+	seed      []byte // 派生钱包用的种子，没有种子或锁定时为 nil。
+	hasSeed   bool   // 是否有种子，锁定时也可判断。
+	nextIndex int    // 下一个派生地址的索引。
+
+	salt   []byte // 导出密钥用的盐，未加密时为 nil。
+	key    []byte // 由口令导出的密钥，锁定时为 nil。
+	sealed []byte // 加密的私钥，解锁后不再使用。
+	nonce  []byte // 加密私钥时使用的随机数。
+}
+
+// 钱包集数据库文件的内容。
+// 地址、公钥和派生路径以明文存储，查询地址无需口令；私钥和种子在加密时整体密封在 Sealed 中。
+type walletsFile struct {
+	Pubkeys   map[string][]byte // 钱包地址 - 公钥。
+	Privkeys  map[string][]byte // 钱包地址 - 私钥标量，加密时为空。
+	Paths     map[string]string // 钱包地址 - 派生路径，只记录由种子派生的钱包。
+	Watched   map[string][]byte // 只观察的地址 - 公钥，公钥未知时为空。
+	Scripts   map[string][]byte // 多重签名地址 - 赎回脚本的编码。
+	Seed      []byte            // 种子，加密时为空。
+	HasSeed   bool              // 是否有种子。
+	NextIndex int               // 下一个派生地址的索引。
+	Salt      []byte            // 导出密钥用的盐，未加密时为空。
+	Nonce     []byte            // AES-GCM 随机数。
+	Sealed    []byte            // AES-GCM 加密的 sealedSecrets。
+}
+
+// 加密时被密封的内容。
+// 升级前的钱包集只密封了私钥集，即 Privkeys 本身。
+type sealedSecrets struct {
+	Privkeys map[string][]byte // 钱包地址 - 私钥标量。
+	Seed     []byte            // 种子。
+}
+
+// 解锁缓存。
+type unlockCache struct {
+	Salt    []byte // 对应的钱包集的盐，用于识别口令已经更换的情况。
+	Key     []byte // 由口令导出的密钥。
+	Expires int64  // 失效时间戳。
 }
 
-// 读取钱包集。
+// 读取钱包集。钱包集已加密时，若解锁缓存仍然有效就直接解锁，否则保持锁定。
 func LoadWallets() *wallets {
 	// 如果数据库不存在，就返回空钱包集。
 	if walletsDbNotExists() {
-		return &wallets{make(map[string]*wallet)}
+		return &wallets{Map: make(map[string]*wallet), scripts: make(map[string][]byte)}
 	}
 
 	// 从数据库读取目前的钱包集信息。
@@ -29,17 +118,202 @@ func LoadWallets() *wallets {
 		panic(err)
 	}
 
-	return deserializeWallets(seq)
+	ws := deserializeWallets(seq)
+	if ws.IsLocked() {
+		if cache := loadUnlockCache(); cache != nil {
+			// 口令更换后缓存的密钥不再有效，立即删除。
+			if !bytes.Equal(cache.Salt, ws.salt) || ws.unseal(cache.Key) != nil {
+				Lock()
+			}
+		}
+	}
+	return ws
 }
 
-// 向钱包集添加钱包。
+// 向钱包集添加钱包。钱包集有种子时派生下一个地址，否则随机生成。
 func (ws *wallets) AddWallet() string {
-	wallet := newWallet()
+	ws.unlockWithPrompt()
+
+	var wallet *wallet
+	if ws.hasSeed {
+		wallet = newHDWallet(ws.seed, addressPath(ws.nextIndex))
+		ws.nextIndex++
+	} else {
+		wallet = newWallet()
+	}
 	address := wallet.address()
 	ws.Map[address] = wallet
 	return address
 }
 
+// 判断指定地址是否只被观察。
+func (ws *wallets) IsWatchOnly(address string) bool {
+	wallet := ws.Map[address]
+	return wallet != nil && wallet.WatchOnly
+}
+
+// 添加只观察的地址，用于跟踪不受自己控制的地址的余额和交易记录。
+// 给出公钥时由公钥得到地址，否则只记录地址。返回被观察的地址。
+func (ws *wallets) Watch(address string, pubkey []byte) (string, error) {
+	wallet := newWatchWallet(pubkey)
+	if pubkey != nil {
+		err := checkPubkey(pubkey)
+		if err != nil {
+			return "", err
+		}
+		address = wallet.address()
+	}
+	if ws.Map[address] != nil {
+		return "", ErrAddressExists
+	}
+	ws.Map[address] = wallet
+	return address, nil
+}
+
+// 获取指定地址的公钥，地址不在钱包集中或公钥未知时返回 nil。
+func (ws *wallets) Pubkey(address string) []byte {
+	if wallet := ws.Map[address]; wallet != nil {
+		return wallet.Pubkey
+	}
+	return nil
+}
+
+// 添加由 n 个公钥中任意 m 个签名即可消费的多重签名地址，返回该地址。
+// 地址只取决于 m 和公钥的顺序，各参与者用相同的参数创建，就能得到相同的地址。
+func (ws *wallets) AddMultisig(m int, pubkeys [][]byte) (string, error) {
+	script, err := transaction.NewMultisigScript(m, pubkeys)
+	if err != nil {
+		return "", err
+	}
+	address := ScriptAddressOf(script.Hash())
+	if ws.scripts[address] != nil {
+		return "", ErrAddressExists
+	}
+	ws.scripts[address] = script.Encode()
+	return address, nil
+}
+
+// 获取多重签名地址的赎回脚本，不是钱包集中的多重签名地址时返回 nil。
+func (ws *wallets) Multisig(address string) *transaction.MultisigScript {
+	data := ws.scripts[address]
+	if data == nil {
+		return nil
+	}
+	script, err := transaction.ParseMultisigScript(data)
+	if err != nil {
+		panic(err)
+	}
+	return script
+}
+
+// 获取各多重签名地址。
+func (ws *wallets) MultisigAddresses() []string {
+	var addresses []string
+	for address := range ws.scripts {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// 导出指定地址的私钥，可用 ImportKey 导入其他钱包集。
+func (ws *wallets) ExportKey(address string) string {
+	return ws.GetWallet(address).exportKey()
+}
+
+// 导入由 ExportKey 导出的私钥，返回对应的地址。地址已被观察时转为完整的钱包。
+func (ws *wallets) ImportKey(key string) (string, error) {
+	wallet, err := importWallet(key)
+	if err != nil {
+		return "", err
+	}
+	address := wallet.address()
+	if existing := ws.Map[address]; existing != nil && !existing.WatchOnly {
+		return "", ErrKeyExists
+	}
+
+	ws.unlockWithPrompt()
+	ws.Map[address] = wallet
+	return address, nil
+}
+
+// 用钱包集中的私钥，对部分签名交易中被这些私钥锁定的输入签名，返回签名的输入数量。
+// 只观察的地址和不在钱包集中的地址被跳过。
+func (ws *wallets) SignPartialTx(p *transaction.PartialTx) int {
+	signed := 0
+	for _, pubkeyHash := range p.Signers() {
+		address := AddressOf(pubkeyHash)
+		if wallet := ws.Map[address]; wallet == nil || wallet.WatchOnly {
+			continue
+		}
+		wallet := ws.GetWallet(address)
+		signed += p.Sign(wallet.Privkey, wallet.Pubkey)
+	}
+	return signed
+}
+
+// 判断钱包集是否有种子。
+func (ws *wallets) HasSeed() bool {
+	return ws.hasSeed
+}
+
+// 为钱包集生成新的种子，返回用于备份的助记词。此后添加的钱包都由种子派生。
+func (ws *wallets) CreateSeed() (string, error) {
+	if ws.hasSeed {
+		return "", ErrHasSeed
+	}
+	mnemonic := newMnemonic()
+	ws.unlockWithPrompt()
+	ws.setSeed(mnemonicToSeed(mnemonic))
+	return mnemonic, nil
+}
+
+// 从助记词恢复钱包集的种子。恢复后需要调用 Rescan 找回已经使用过的地址。
+func (ws *wallets) RestoreSeed(mnemonic string) error {
+	if ws.hasSeed {
+		return ErrHasSeed
+	}
+	mnemonic, err := checkMnemonic(mnemonic)
+	if err != nil {
+		return err
+	}
+	ws.unlockWithPrompt()
+	ws.setSeed(mnemonicToSeed(mnemonic))
+	return nil
+}
+
+// 从第一个派生地址开始重新扫描，找回被使用过的地址，返回新添加的地址。
+// 连续 gapLimit 个地址都未被使用时停止；最后一个被使用的地址及之前的地址都会加入钱包集，
+// 已被观察的地址转为完整的钱包。
+func (ws *wallets) Rescan(gapLimit int, used func(address string) bool) []string {
+	if !ws.hasSeed {
+		return nil
+	}
+	ws.unlockWithPrompt()
+
+	var derived []*wallet
+	lastUsed := -1
+	for index := 0; index-lastUsed <= gapLimit; index++ {
+		wallet := newHDWallet(ws.seed, addressPath(index))
+		derived = append(derived, wallet)
+		if used(wallet.address()) {
+			lastUsed = index
+		}
+	}
+
+	var added []string
+	for _, wallet := range derived[:lastUsed+1] {
+		address := wallet.address()
+		if existing := ws.Map[address]; existing == nil || existing.WatchOnly {
+			ws.Map[address] = wallet
+			added = append(added, address)
+		}
+	}
+	if lastUsed+1 > ws.nextIndex {
+		ws.nextIndex = lastUsed + 1
+	}
+	return added
+}
+
 // 获取各钱包的地址。
 func (ws *wallets) Addresses() []string {
 	var addresses []string
@@ -49,26 +323,281 @@ func (ws *wallets) Addresses() []string {
 	return addresses
 }
 
-// 获取指定地址的钱包。
+// 获取指定地址的钱包。钱包集被锁定时先请求口令解锁；只观察的地址没有私钥，直接报错。
 func (ws *wallets) GetWallet(address string) *wallet {
-	return ws.Map[address]
+	wallet := ws.Map[address]
+	if wallet == nil {
+		panic("wallet not found")
+	}
+	if wallet.WatchOnly {
+		panic(ErrWatchOnly)
+	}
+	ws.unlockWithPrompt()
+	return wallet
+}
+
+// 判断钱包集是否已加密。
+func (ws *wallets) IsEncrypted() bool {
+	return ws.salt != nil
+}
+
+// 判断钱包集是否被锁定，即已加密且尚未解锁。
+func (ws *wallets) IsLocked() bool {
+	return ws.IsEncrypted() && ws.key == nil
+}
+
+// 用口令加密钱包集，并存储进数据库。
+func (ws *wallets) Encrypt(passphrase []byte) error {
+	if ws.IsEncrypted() {
+		return ErrEncrypted
+	}
+	ws.setPassphrase(passphrase)
+	ws.Persist()
+	return nil
+}
+
+// 用口令解锁钱包集。
+func (ws *wallets) Unlock(passphrase []byte) error {
+	if !ws.IsEncrypted() {
+		return ErrNotEncrypted
+	}
+	if !ws.IsLocked() {
+		return nil
+	}
+	return ws.unseal(deriveKey(passphrase, ws.salt))
+}
+
+// 在指定时长内保持钱包集解锁，期间使用钱包无需再输入口令。
+// 每条命令都是独立的进程，无法在内存中保留密钥，因此由口令导出的密钥以明文写入仅当前用户可读的缓存文件。
+// 没有后台进程按时删除缓存：失效时间只在之后有命令读取钱包集时检查，届时缓存被覆盖并删除；
+// 口令更换时或执行 Lock 时也会删除。在此之前，即使已经超过时长，能读取该文件的人仍可以解密私钥。
+func (ws *wallets) KeepUnlocked(timeout time.Duration) {
+	if ws.IsLocked() {
+		panic("wallet is locked")
+	}
+	cache := unlockCache{ws.salt, ws.key, time.Now().Add(timeout).Unix()}
+
+	var seq bytes.Buffer
+	encoder := gob.NewEncoder(&seq)
+	err := encoder.Encode(&cache)
+	if err != nil {
+		panic(err)
+	}
+
+	err = ioutil.WriteFile(unlockCachePath, seq.Bytes(), 0600)
+	if err != nil {
+		panic(err)
+	}
+	// WriteFile 不会修改已存在文件的权限。
+	err = os.Chmod(unlockCachePath, 0600)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// 更换口令，并存储进数据库。之前的解锁缓存随之失效。
+func (ws *wallets) ChangePassphrase(oldPassphrase []byte, newPassphrase []byte) error {
+	if !ws.IsEncrypted() {
+		return ErrNotEncrypted
+	}
+	ws.key = nil
+	err := ws.Unlock(oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	ws.setPassphrase(newPassphrase)
+	ws.Persist()
+	Lock()
+	return nil
+}
+
+// 锁定钱包集：先用零覆盖解锁缓存中的密钥，再删除缓存文件。
+func Lock() {
+	info, err := os.Stat(unlockCachePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = ioutil.WriteFile(unlockCachePath, make([]byte, info.Size()), 0600)
+	}
+	if err == nil {
+		err = os.Remove(unlockCachePath)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
 }
 
 // 将钱包集存储进数据库。
+// 锁定时私钥集不可能发生变化，沿用原先密封的内容，因此添加只观察的地址无需口令。
 func (ws *wallets) Persist() {
-	err := ioutil.WriteFile(walletsDbPath, ws.serialize(), 0644)
+	err := ioutil.WriteFile(walletsDbPath, ws.serialize(), 0600)
+	if err != nil {
+		panic(err)
+	}
+	// WriteFile 不会修改已存在文件的权限。
+	err = os.Chmod(walletsDbPath, 0600)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// 钱包集被锁定时，通过 Prompt 请求口令并解锁。
+func (ws *wallets) unlockWithPrompt() {
+	if !ws.IsLocked() {
+		return
+	}
+	if Prompt == nil {
+		panic("wallet is locked")
+	}
+	passphrase, err := Prompt("Enter wallet passphrase: ")
+	if err != nil {
+		panic(err)
+	}
+	err = ws.Unlock(passphrase)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// 设置种子，从第一个派生地址开始派生。
+func (ws *wallets) setSeed(seed []byte) {
+	ws.seed = seed
+	ws.hasSeed = true
+	ws.nextIndex = 0
+}
+
+// 设置新口令：生成新的盐并导出密钥。
+func (ws *wallets) setPassphrase(passphrase []byte) {
+	ws.salt = make([]byte, saltLen)
+	_, err := rand.Read(ws.salt)
 	if err != nil {
 		panic(err)
 	}
+	ws.key = deriveKey(passphrase, ws.salt)
+}
+
+// 用密钥解密私钥集。密钥错误时返回 ErrWrongPassphrase。
+func (ws *wallets) unseal(key []byte) error {
+	seq, err := newCipher(key).Open(nil, ws.nonce, ws.sealed, ws.salt)
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+
+	var secrets sealedSecrets
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	if decoder.Decode(&secrets) != nil {
+		decoder = gob.NewDecoder(bytes.NewReader(seq))
+		err = decoder.Decode(&secrets.Privkeys)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	ws.seed = secrets.Seed
+	for address, d := range secrets.Privkeys {
+		if wallet := ws.Map[address]; wallet != nil {
+			wallet.Privkey = privateKeyFromScalar(d)
+		}
+	}
+	ws.key = key
+	return nil
+}
+
+// 由口令和盐导出密钥。
+func deriveKey(passphrase []byte, salt []byte) []byte {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, keyLen)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// 创建 AES-GCM 加密器。
+func newCipher(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// 读取解锁缓存，缓存不存在或已失效时返回 nil。已失效或无法解码的缓存随即被删除。
+func loadUnlockCache() *unlockCache {
+	seq, err := ioutil.ReadFile(unlockCachePath)
+	if err != nil {
+		return nil
+	}
+
+	var cache unlockCache
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	if decoder.Decode(&cache) != nil || time.Now().Unix() >= cache.Expires {
+		Lock()
+		return nil
+	}
+	return &cache
 }
 
 // 序列化钱包集。
 func (ws *wallets) serialize() []byte {
-	var seq bytes.Buffer
+	file := walletsFile{
+		Pubkeys:   make(map[string][]byte),
+		Privkeys:  make(map[string][]byte),
+		Paths:     make(map[string]string),
+		Watched:   make(map[string][]byte),
+		Scripts:   ws.scripts,
+		Seed:      ws.seed,
+		HasSeed:   ws.hasSeed,
+		NextIndex: ws.nextIndex,
+	}
+	for address, wallet := range ws.Map {
+		if wallet.WatchOnly {
+			file.Watched[address] = wallet.Pubkey
+			continue
+		}
+		file.Pubkeys[address] = wallet.Pubkey
+		if !ws.IsLocked() {
+			file.Privkeys[address] = wallet.Privkey.D.Bytes()
+		}
+		if wallet.Path != "" {
+			file.Paths[address] = wallet.Path
+		}
+	}
+
+	// 加密时将私钥集和种子整体密封，以盐作为附加数据；锁定时沿用原先密封的内容。
+	if ws.IsLocked() {
+		file.Salt = ws.salt
+		file.Nonce = ws.nonce
+		file.Sealed = ws.sealed
+		file.Privkeys = nil
+	} else if ws.IsEncrypted() {
+		var seq bytes.Buffer
+		encoder := gob.NewEncoder(&seq)
+		err := encoder.Encode(&sealedSecrets{file.Privkeys, file.Seed})
+		if err != nil {
+			panic(err)
+		}
+
+		aead := newCipher(ws.key)
+		file.Nonce = make([]byte, aead.NonceSize())
+		_, err = rand.Read(file.Nonce)
+		if err != nil {
+			panic(err)
+		}
+		file.Salt = ws.salt
+		file.Sealed = aead.Seal(nil, file.Nonce, seq.Bytes(), ws.salt)
+		file.Privkeys = nil
+		file.Seed = nil
+	}
 
-	gob.Register(elliptic.P256())
+	var seq bytes.Buffer
 	encoder := gob.NewEncoder(&seq)
-	err := encoder.Encode(ws)
+	err := encoder.Encode(&file)
 	if err != nil {
 		panic(err)
 	}
@@ -78,16 +607,61 @@ func (ws *wallets) serialize() []byte {
 
 // 反序列化钱包集。
 func deserializeWallets(seq []byte) *wallets {
-	var wallets wallets
+	var file walletsFile
+
+	decoder := gob.NewDecoder(bytes.NewReader(seq))
+	err := decoder.Decode(&file)
+	if err != nil {
+		return deserializeLegacyWallets(seq)
+	}
+
+	ws := &wallets{
+		Map:       make(map[string]*wallet),
+		scripts:   file.Scripts,
+		seed:      file.Seed,
+		hasSeed:   file.HasSeed,
+		nextIndex: file.NextIndex,
+		salt:      file.Salt,
+		sealed:    file.Sealed,
+		nonce:     file.Nonce,
+	}
+	for address, pubkey := range file.Pubkeys {
+		wallet := &wallet{Pubkey: pubkey, Path: file.Paths[address]}
+		if d, ok := file.Privkeys[address]; ok {
+			wallet.Privkey = privateKeyFromScalar(d)
+		}
+		ws.Map[address] = wallet
+	}
+	for address, pubkey := range file.Watched {
+		ws.Map[address] = newWatchWallet(pubkey)
+	}
+	if ws.scripts == nil {
+		ws.scripts = make(map[string][]byte)
+	}
+	return ws
+}
+
+// 反序列化升级前直接以 gob 编码 ecdsa.PrivateKey 存储的钱包集。
+// 曲线以接口类型编码，其注册名随 Go 版本变化，因此只解码私钥的标量，曲线固定为 P-256。
+func deserializeLegacyWallets(seq []byte) *wallets {
+	var legacy struct {
+		Map map[string]*struct {
+			Privkey struct{ D *big.Int }
+			Pubkey  []byte
+		}
+	}
 
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(seq))
-	err := decoder.Decode(&wallets)
+	err := decoder.Decode(&legacy)
 	if err != nil {
 		panic(err)
 	}
 
-	return &wallets
+	ws := &wallets{Map: make(map[string]*wallet), scripts: make(map[string][]byte)}
+	for address, w := range legacy.Map {
+		ws.Map[address] = &wallet{Privkey: privateKeyFromScalar(w.Privkey.D.Bytes()), Pubkey: w.Pubkey}
+	}
+	return ws
 }
 
 // 判断钱包集数据库是否存在。
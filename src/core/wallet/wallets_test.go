@@ -0,0 +1,172 @@
+package wallet
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// 判断解锁缓存文件是否存在。
+func unlockCacheExists() bool {
+	_, err := os.Stat(unlockCachePath)
+	return err == nil
+}
+
+// 切换到临时目录，钱包文件随工作目录隔离。
+func chdirTemp(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// 解锁缓存在失效后首次读取时，以及执行 Lock 时被删除。
+func TestUnlockCacheIsDeleted(t *testing.T) {
+	chdirTemp(t)
+
+	ws := LoadWallets()
+	ws.AddWallet()
+	if err := ws.Encrypt([]byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.KeepUnlocked(-time.Second)
+	if !unlockCacheExists() {
+		t.Fatal("unlock cache was not written")
+	}
+	if !LoadWallets().IsLocked() {
+		t.Error("expired unlock cache unlocked the wallet")
+	}
+	if unlockCacheExists() {
+		t.Error("expired unlock cache was not deleted")
+	}
+
+	ws.KeepUnlocked(time.Hour)
+	if LoadWallets().IsLocked() {
+		t.Error("valid unlock cache did not unlock the wallet")
+	}
+	Lock()
+	if unlockCacheExists() {
+		t.Error("unlock cache was not deleted by Lock")
+	}
+	if !LoadWallets().IsLocked() {
+		t.Error("wallet is still unlocked after Lock")
+	}
+}
+
+// 加密并存储后重新读取的钱包集处于锁定状态，用正确的口令解锁后私钥与种子不变；口令错误时保持锁定。
+func TestEncryptedWalletsRoundTrip(t *testing.T) {
+	chdirTemp(t)
+
+	ws := LoadWallets()
+	if _, err := ws.CreateSeed(); err != nil {
+		t.Fatal(err)
+	}
+	derived := ws.AddWallet()
+	imported, err := ws.ImportKey(newWallet().exportKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string][]byte)
+	for _, address := range []string{derived, imported} {
+		want[address] = ws.GetWallet(address).Privkey.D.Bytes()
+	}
+	seed := append([]byte{}, ws.seed...)
+
+	passphrase := []byte("correct horse battery staple")
+	if err := ws.Encrypt(passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt(passphrase); err != ErrEncrypted {
+		t.Errorf("encrypting twice: %v, want ErrEncrypted", err)
+	}
+	ws.Persist()
+
+	loaded := LoadWallets()
+	if !loaded.IsEncrypted() || !loaded.IsLocked() {
+		t.Fatal("loaded wallets are not locked")
+	}
+	if loaded.seed != nil {
+		t.Error("seed is readable while locked")
+	}
+	for address := range want {
+		if loaded.Map[address] == nil {
+			t.Fatalf("address %s is missing", address)
+		}
+		if loaded.Map[address].Privkey.D != nil {
+			t.Errorf("private key of %s is readable while locked", address)
+		}
+	}
+
+	if err := loaded.Unlock([]byte("wrong passphrase")); err != ErrWrongPassphrase {
+		t.Fatalf("unlock with a wrong passphrase: %v, want ErrWrongPassphrase", err)
+	}
+	if !loaded.IsLocked() {
+		t.Fatal("wrong passphrase unlocked the wallets")
+	}
+
+	if err := loaded.Unlock(passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.IsLocked() {
+		t.Fatal("wallets are still locked")
+	}
+	if !bytes.Equal(loaded.seed, seed) {
+		t.Errorf("seed = %x, want %x", loaded.seed, seed)
+	}
+	for address, d := range want {
+		if got := loaded.Map[address].Privkey.D.Bytes(); !bytes.Equal(got, d) {
+			t.Errorf("private key of %s = %x, want %x", address, got, d)
+		}
+	}
+}
+
+// 重新扫描在连续 gapLimit 个地址未被使用时停止，之后的地址即使被使用也不会找回。
+func TestRescanStopsAtGapLimit(t *testing.T) {
+	chdirTemp(t)
+
+	ws := LoadWallets()
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	if err := ws.RestoreSeed(mnemonic); err != nil {
+		t.Fatal(err)
+	}
+	seed := mnemonicToSeed(mnemonic)
+	addresses := make([]string, 12)
+	for i := range addresses {
+		addresses[i] = newHDWallet(seed, addressPath(i)).address()
+	}
+
+	// 使用第 0、3 个地址，以及间隔超过上限的第 9 个地址。
+	const gapLimit = 5
+	usedAddresses := map[string]bool{addresses[0]: true, addresses[3]: true, addresses[9]: true}
+	var checked []string
+	added := ws.Rescan(gapLimit, func(address string) bool {
+		checked = append(checked, address)
+		return usedAddresses[address]
+	})
+
+	if len(checked) != 3+gapLimit+1 {
+		t.Errorf("checked %d addresses, want %d", len(checked), 3+gapLimit+1)
+	}
+	if len(added) != 4 {
+		t.Fatalf("added %d addresses, want 4", len(added))
+	}
+	for i, address := range added {
+		if address != addresses[i] {
+			t.Errorf("added[%d] = %s, want %s", i, address, addresses[i])
+		}
+	}
+	if ws.Map[addresses[9]] != nil {
+		t.Error("found an address beyond the gap limit")
+	}
+
+	// 此后添加的钱包从最后一个被使用的地址之后继续派生。
+	if next := ws.AddWallet(); next != addresses[4] {
+		t.Errorf("next address %s, want %s", next, addresses[4])
+	}
+}
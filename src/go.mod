@@ -1,10 +1,11 @@
 module blockchain
 
-go 1.17
+go 1.18
 
 require (
 	github.com/boltdb/bolt v1.3.1
 	golang.org/x/crypto v0.1.0
+	golang.org/x/term v0.1.0
 )
 
 require golang.org/x/sys v0.1.0 // indirect
@@ -0,0 +1,156 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// 协议版本号。版本不同的节点拒绝通信。
+// 版本 3 起先同步区块头，核对工作量证明后再下载区块，取代按区块清单同步。
+const protocolVersion = 3
+
+// 消息头中命令字段的字节长度。
+const commandLen = 12
+
+// 单条消息负载的最大字节数。
+const maxPayloadLen = 32 * 1024 * 1024
+
+// 消息命令。
+const (
+	cmdVersion    = "version"
+	cmdVerack     = "verack"
+	cmdInv        = "inv"
+	cmdGetHeaders = "getheaders"
+	cmdHeaders    = "headers"
+	cmdGetData    = "getdata"
+	cmdBlock      = "block"
+	cmdTx         = "tx"
+)
+
+// 清单条目的类型。
+const (
+	invBlock = "block"
+	invTx    = "tx"
+)
+
+// 单次回复 getheaders 时最多发送的区块头数。
+const maxHeaders = 2000
+
+// 同步时每批请求的区块数，不超过发送队列的长度。
+const maxBlocksInFlight = 128
+
+// 同步时等待对方发来下一个区块的期限。超过期限视为停滞，重新请求区块头。
+const blockDownloadTimeout = time.Minute
+
+// 同步连续停滞的次数超过此值时，断开与该节点的连接。
+const maxSyncStalls = 3
+
+// 版本消息：握手时交换协议版本和区块链高度。
+type versionMsg struct {
+	Version    int    // 协议版本号。
+	BestHeight int    // 区块链高度。
+	AddrFrom   string // 发送方的监听地址，一次性连接时为空。
+}
+
+// 版本确认消息。
+type verackMsg struct{}
+
+// 清单消息：告知对方自己拥有的区块或交易。
+type invMsg struct {
+	Kind  string   // 条目类型。
+	Items [][]byte // 区块哈希值或交易 ID。
+}
+
+// 请求区块头的消息。
+type getHeadersMsg struct {
+	Locator [][]byte // 请求方的区块定位器，对方从其中第一个已知区块之后开始发送。
+}
+
+// 区块头消息。
+type headersMsg struct {
+	Headers [][]byte // 序列化的区块头，按从旧到新排列。
+}
+
+// 请求数据的消息。
+type getDataMsg struct {
+	Kind string // 条目类型。
+	ID   []byte // 区块哈希值或交易 ID。
+}
+
+// 区块消息。
+type blockMsg struct {
+	Block []byte // 序列化的区块。
+}
+
+// 交易消息。
+type txMsg struct {
+	Tx []byte // 序列化的交易。
+}
+
+// 消息结构。
+type message struct {
+	Command string // 命令。
+	Payload []byte // gob 编码的负载。
+}
+
+// 创建消息。
+func newMessage(command string, payload interface{}) *message {
+	var seq bytes.Buffer
+
+	encoder := gob.NewEncoder(&seq)
+	err := encoder.Encode(payload)
+	if err != nil {
+		panic(err)
+	}
+
+	return &message{command, seq.Bytes()}
+}
+
+// 解码消息负载。
+func (m *message) decode(payload interface{}) error {
+	decoder := gob.NewDecoder(bytes.NewReader(m.Payload))
+	return decoder.Decode(payload)
+}
+
+// 写出消息。
+// 格式：命令（12 字节，不足补零）+ 负载长度（4 字节，大端序）+ 负载。
+func writeMessage(w io.Writer, m *message) error {
+	if len(m.Command) > commandLen {
+		return fmt.Errorf("command %q is too long", m.Command)
+	}
+
+	header := make([]byte, commandLen+4)
+	copy(header, m.Command)
+	binary.BigEndian.PutUint32(header[commandLen:], uint32(len(m.Payload)))
+
+	_, err := w.Write(append(header, m.Payload...))
+	return err
+}
+
+// 读入消息。
+func readMessage(r io.Reader) (*message, error) {
+	header := make([]byte, commandLen+4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[commandLen:])
+	if length > maxPayloadLen {
+		return nil, errors.New("payload is too large")
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	command := string(bytes.TrimRight(header[:commandLen], "\x00"))
+	return &message{command, payload}, nil
+}
@@ -0,0 +1,529 @@
+package network
+
+import (
+	"blockchain/core/block"
+	"blockchain/core/blockchain"
+	"blockchain/core/transaction"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// 连接对等节点的超时时间。
+const dialTimeout = 5 * time.Second
+
+// 节点结构。
+type Node struct {
+	addr  string            // 本节点的监听地址。
+	miner string            // 接收挖矿奖励的地址，为空时不挖矿。
+	chain *blockchain.Chain // 本地区块链。
+	mu    sync.Mutex        // 保护对区块链的访问。
+
+	peersMu sync.Mutex     // 保护 peers。
+	peers   map[*peer]bool // 已连接的对等节点。
+
+	miningMu     sync.Mutex         // 保护 cancelMining。
+	cancelMining context.CancelFunc // 中止正在进行的挖矿。
+	mineSignal   chan struct{}      // 交易池有新交易时通知挖矿协程。
+}
+
+// 创建节点。
+func NewNode(addr string, chain *blockchain.Chain, miner string) *Node {
+	return &Node{
+		addr:       addr,
+		miner:      miner,
+		chain:      chain,
+		peers:      make(map[*peer]bool),
+		mineSignal: make(chan struct{}, 1),
+	}
+}
+
+// 启动节点：连接种子节点，并在监听地址上接受其他节点的连接。
+// 该函数会一直阻塞，直到监听失败。
+func (n *Node) Run(seeds []string) error {
+	listener, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("Node listening on %s, chain height %d.", n.addr, n.chain.Height())
+
+	for _, seed := range seeds {
+		go n.connect(seed)
+	}
+	if n.miner != "" {
+		go n.mineLoop()
+		n.notifyMiner()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go n.serve(newPeer(conn, true))
+	}
+}
+
+// 主动连接对等节点，并发送版本消息发起握手。
+func (n *Node) connect(addr string) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		log.Printf("Failed to connect to %s: %s", addr, err)
+		return
+	}
+	p := newPeer(conn, false)
+	p.addr = addr
+	p.send(n.versionMessage())
+	n.serve(p)
+}
+
+// 生成本节点的版本消息。
+func (n *Node) versionMessage() *message {
+	n.mu.Lock()
+	height := n.chain.Height()
+	n.mu.Unlock()
+
+	return newMessage(cmdVersion, versionMsg{protocolVersion, height, n.addr})
+}
+
+// 接收并处理对等节点的消息，直到连接断开。
+func (n *Node) serve(p *peer) {
+	n.peersMu.Lock()
+	n.peers[p] = true
+	n.peersMu.Unlock()
+
+	defer func() {
+		n.peersMu.Lock()
+		delete(n.peers, p)
+		n.peersMu.Unlock()
+		p.close()
+	}()
+
+	for {
+		if p.syncLast != nil {
+			stalled := time.Now().After(p.syncDeadline)
+			if !stalled {
+				var err error
+				stalled, err = p.waitUntil(p.syncDeadline)
+				if err != nil {
+					return
+				}
+			}
+			if stalled {
+				if err := n.restartSync(p); err != nil {
+					log.Printf("Dropping peer %s: %s", p.addr, err)
+					return
+				}
+				continue
+			}
+		}
+
+		m, err := readMessage(p.reader)
+		if err != nil {
+			return
+		}
+		if err := n.handle(p, m); err != nil {
+			log.Printf("Dropping peer %s: %s", p.addr, err)
+			return
+		}
+	}
+}
+
+// 处理一条消息。返回错误时断开与该节点的连接。
+func (n *Node) handle(p *peer, m *message) (err error) {
+	// 解码或处理畸形数据时可能发生 panic，视为对方违反协议。
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed %s message: %v", m.Command, r)
+		}
+	}()
+
+	if m.Command != cmdVersion && !p.versioned {
+		return fmt.Errorf("received %s before version", m.Command)
+	}
+
+	switch m.Command {
+	case cmdVersion:
+		var payload versionMsg
+		if err := m.decode(&payload); err != nil {
+			return err
+		}
+		return n.handleVersion(p, &payload)
+	case cmdVerack:
+		return nil
+	case cmdInv:
+		var payload invMsg
+		if err := m.decode(&payload); err != nil {
+			return err
+		}
+		n.handleInv(p, &payload)
+	case cmdGetHeaders:
+		var payload getHeadersMsg
+		if err := m.decode(&payload); err != nil {
+			return err
+		}
+		n.handleGetHeaders(p, &payload)
+	case cmdHeaders:
+		var payload headersMsg
+		if err := m.decode(&payload); err != nil {
+			return err
+		}
+		return n.handleHeaders(p, &payload)
+	case cmdGetData:
+		var payload getDataMsg
+		if err := m.decode(&payload); err != nil {
+			return err
+		}
+		n.handleGetData(p, &payload)
+	case cmdBlock:
+		var payload blockMsg
+		if err := m.decode(&payload); err != nil {
+			return err
+		}
+		n.handleBlock(p, block.DeserializeBlock(payload.Block))
+	case cmdTx:
+		var payload txMsg
+		if err := m.decode(&payload); err != nil {
+			return err
+		}
+		n.handleTx(p, transaction.DeserializeTransaction(payload.Tx))
+	default:
+		return fmt.Errorf("unknown command %q", m.Command)
+	}
+	return nil
+}
+
+// 处理版本消息：确认协议版本，必要时回复自己的版本，并在对方区块链更高时请求区块头。
+func (n *Node) handleVersion(p *peer, payload *versionMsg) error {
+	if p.versioned {
+		return errors.New("duplicate version message")
+	}
+	if payload.Version != protocolVersion {
+		return fmt.Errorf("unsupported protocol version %d", payload.Version)
+	}
+	n.peersMu.Lock()
+	p.versioned = true
+	n.peersMu.Unlock()
+	if payload.AddrFrom != "" {
+		p.addr = payload.AddrFrom
+	}
+
+	if p.inbound {
+		p.send(n.versionMessage())
+	}
+	p.send(newMessage(cmdVerack, verackMsg{}))
+
+	n.mu.Lock()
+	height, locator := n.chain.Height(), n.chain.Locator()
+	n.mu.Unlock()
+	if payload.BestHeight > height {
+		p.send(newMessage(cmdGetHeaders, getHeadersMsg{locator}))
+	}
+	return nil
+}
+
+// 处理清单消息：请求本地还没有的区块或交易。
+func (n *Node) handleInv(p *peer, payload *invMsg) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, item := range payload.Items {
+		switch payload.Kind {
+		case invBlock:
+			if !n.chain.HasBlock(item) {
+				p.send(newMessage(cmdGetData, getDataMsg{invBlock, item}))
+			}
+		case invTx:
+			if n.chain.PendingTx(item) == nil {
+				p.send(newMessage(cmdGetData, getDataMsg{invTx, item}))
+			}
+		}
+	}
+}
+
+// 处理区块头请求：发送双方区块链分叉点之后的区块头。
+func (n *Node) handleGetHeaders(p *peer, payload *getHeadersMsg) {
+	n.mu.Lock()
+	headers := n.chain.HeadersAfter(payload.Locator, maxHeaders)
+	n.mu.Unlock()
+
+	if len(headers) == 0 {
+		return
+	}
+	seqs := make([][]byte, 0, len(headers))
+	for _, header := range headers {
+		seqs = append(seqs, header.Serialize())
+	}
+	p.send(newMessage(cmdHeaders, headersMsg{seqs}))
+}
+
+// 处理区块头消息：区块头须首尾相连、接在本地已知的区块之后，并各自满足工作量证明，
+// 否则视为对方违反协议。之后分批请求其中本地还没有的区块；
+// 区块头达到单批上限时，继续请求之后的区块头。
+func (n *Node) handleHeaders(p *peer, payload *headersMsg) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var prev []byte
+	for index, seq := range payload.Headers {
+		header := block.DeserializeHeader(seq)
+		hash := header.ComputeHash()
+		if index == 0 && !n.chain.HasBlock(header.PrevBlockHash) {
+			return fmt.Errorf("header %x does not connect to the local chain", hash)
+		}
+		if index > 0 && !bytes.Equal(header.PrevBlockHash, prev) {
+			return fmt.Errorf("header %x does not follow header %x", hash, prev)
+		}
+		if !header.VerifyWork(hash) {
+			return fmt.Errorf("header %x fails proof of work", hash)
+		}
+		if !n.chain.HasBlock(hash) {
+			p.syncQueue = append(p.syncQueue, hash)
+		}
+		prev = hash
+	}
+
+	if len(payload.Headers) == maxHeaders {
+		p.send(newMessage(cmdGetHeaders, getHeadersMsg{[][]byte{prev}}))
+	}
+	if p.syncLast == nil {
+		n.requestBlocks(p)
+	}
+	return nil
+}
+
+// 从同步队列中取出下一批区块发出请求，记下最后一个，收到它之后再请求下一批。
+func (n *Node) requestBlocks(p *peer) {
+	count := len(p.syncQueue)
+	if count > maxBlocksInFlight {
+		count = maxBlocksInFlight
+	}
+	if count == 0 {
+		return
+	}
+	for _, hash := range p.syncQueue[:count] {
+		p.send(newMessage(cmdGetData, getDataMsg{invBlock, hash}))
+	}
+	p.syncLast = p.syncQueue[count-1]
+	p.syncQueue = p.syncQueue[count:]
+	p.syncDeadline = time.Now().Add(blockDownloadTimeout)
+}
+
+// 同步停滞时（对方没有回复请求的区块，或请求在途中丢失），清空同步状态并重新请求区块头，
+// 之后重新请求本地仍然没有的区块。连续停滞次数过多时返回错误，断开与该节点的连接。
+func (n *Node) restartSync(p *peer) error {
+	p.syncStalls++
+	if p.syncStalls > maxSyncStalls {
+		return fmt.Errorf("block download stalled %d times", p.syncStalls)
+	}
+	log.Printf("Block download from %s stalled, requesting headers again.", p.addr)
+
+	p.syncQueue = nil
+	p.syncLast = nil
+	n.mu.Lock()
+	locator := n.chain.Locator()
+	n.mu.Unlock()
+	p.send(newMessage(cmdGetHeaders, getHeadersMsg{locator}))
+	return nil
+}
+
+// 处理数据请求：发送对方请求的区块或交易。
+func (n *Node) handleGetData(p *peer, payload *getDataMsg) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch payload.Kind {
+	case invBlock:
+		b, err := n.chain.BlockByHash(payload.ID)
+		if err == nil {
+			p.send(newMessage(cmdBlock, blockMsg{b.Serialize()}))
+		}
+	case invTx:
+		tx := n.chain.PendingTx(payload.ID)
+		if tx != nil {
+			p.send(newMessage(cmdTx, txMsg{tx.Serialize()}))
+		}
+	}
+}
+
+// 处理区块消息：校验并收录区块。
+// 区块使区块链尾部发生变化时（包括切换到更长的分支），中止基于旧尾部的挖矿，再转告其他节点。
+func (n *Node) handleBlock(p *peer, b *block.Block) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	oldTip := n.chain.Tip()
+	err := n.chain.AcceptBlock(b)
+	tip := n.chain.Tip()
+	height := n.chain.Height()
+	locator := n.chain.Locator()
+
+	// 挖矿协程在持有 n.mu 时创建区块模板并登记 cancelMining，
+	// 因此尾部变化前创建的模板一定会在这里被中止。
+	if !bytes.Equal(tip, oldTip) {
+		n.stopMining()
+	}
+
+	switch err {
+	case nil:
+		switch {
+		case bytes.Equal(tip, oldTip):
+			log.Printf("Stored side branch block %x from %s.", b.Hash, p.addr)
+		case bytes.Equal(b.PrevBlockHash, oldTip):
+			log.Printf("Accepted block %x at height %d from %s.", b.Hash, height, p.addr)
+		default:
+			log.Printf("Reorganized to block %x at height %d from %s.", b.Hash, height, p.addr)
+		}
+		if !bytes.Equal(tip, oldTip) {
+			n.broadcast(newMessage(cmdInv, invMsg{invBlock, [][]byte{b.Hash}}), p)
+			n.notifyMiner()
+		}
+	case blockchain.ErrKnownBlock:
+	case blockchain.ErrOrphanBlock:
+		p.send(newMessage(cmdGetHeaders, getHeadersMsg{locator}))
+	default:
+		log.Printf("Rejected block %x from %s: %s", b.Hash, p.addr, err)
+	}
+
+	// 同步期间对方发来区块，延长等待期限；本批请求的区块都已收到时，继续请求下一批。
+	if p.syncLast != nil {
+		p.syncDeadline = time.Now().Add(blockDownloadTimeout)
+	}
+	if bytes.Equal(b.Hash, p.syncLast) {
+		p.syncLast = nil
+		p.syncStalls = 0
+		n.requestBlocks(p)
+	}
+}
+
+// 处理交易消息：将交易加入交易池，再转告其他节点。
+func (n *Node) handleTx(p *peer, tx *transaction.Transaction) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	err := n.chain.SubmitTx(tx)
+	if err != nil {
+		log.Printf("Rejected transaction %x from %s: %s", tx.ID, p.addr, err)
+		return
+	}
+	log.Printf("Accepted transaction %x from %s.", tx.ID, p.addr)
+	n.broadcast(newMessage(cmdInv, invMsg{invTx, [][]byte{tx.ID}}), p)
+	n.notifyMiner()
+}
+
+// 向除 except 以外的所有已握手节点发送消息。
+func (n *Node) broadcast(m *message, except *peer) {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+
+	for p := range n.peers {
+		if p != except && p.versioned {
+			p.send(m)
+		}
+	}
+}
+
+// 通知挖矿协程检查交易池。
+func (n *Node) notifyMiner() {
+	select {
+	case n.mineSignal <- struct{}{}:
+	default:
+	}
+}
+
+// 中止正在进行的挖矿。
+func (n *Node) stopMining() {
+	n.miningMu.Lock()
+	defer n.miningMu.Unlock()
+
+	if n.cancelMining != nil {
+		n.cancelMining()
+	}
+}
+
+// 挖矿协程：交易池有待确认交易时挖出新区块，并通告给所有节点。
+// 只在创建区块模板和收录区块时持有 n.mu，证明工作量期间其他消息照常处理。
+// 收到其他节点的新区块时，当前的挖矿会被中止，之后重新从交易池挑选交易。
+func (n *Node) mineLoop() {
+	for range n.mineSignal {
+		for {
+			template, ctx, cancel, err := n.newBlockTemplate()
+			if template == nil {
+				if err != nil {
+					log.Printf("Mining failed: %s", err)
+				}
+				break
+			}
+
+			_, err = template.Mine(ctx)
+			cancel()
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					log.Printf("Mining failed: %s", err)
+					break
+				}
+				continue
+			}
+
+			n.mu.Lock()
+			// 挖矿期间尾部已经变化时，区块已经过时，重新挑选交易。
+			if !bytes.Equal(n.chain.Tip(), template.PrevBlockHash) {
+				n.mu.Unlock()
+				continue
+			}
+			err = n.chain.AcceptBlock(template)
+			height := n.chain.Height()
+			n.mu.Unlock()
+			if err != nil {
+				log.Printf("Mining failed: %s", err)
+				break
+			}
+
+			log.Printf("Mined block %x at height %d with %d transactions.", template.Hash, height, len(template.Transactions)-1)
+			n.broadcast(newMessage(cmdInv, invMsg{invBlock, [][]byte{template.Hash}}), nil)
+		}
+	}
+}
+
+// 在持有 n.mu 时从交易池创建区块模板，并登记中止挖矿的函数。
+// 交易池内没有可以打包的交易（包括只剩锁定时间尚未到达的交易）或创建失败时，返回的模板为 nil。
+func (n *Node) newBlockTemplate() (*block.Block, context.Context, context.CancelFunc, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	selected := n.chain.SelectPending()
+	if len(selected) == 0 {
+		return nil, nil, nil, nil
+	}
+	template, err := n.chain.NewBlockTemplate(n.chain.AssembleBlock(n.miner, selected))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.miningMu.Lock()
+	n.cancelMining = cancel
+	n.miningMu.Unlock()
+	return template, ctx, cancel, nil
+}
+
+// 通过一次性连接把交易发送给指定节点。
+func SendTx(addr string, tx *transaction.Transaction) error {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = writeMessage(conn, newMessage(cmdVersion, versionMsg{protocolVersion, 0, ""}))
+	if err != nil {
+		return err
+	}
+	return writeMessage(conn, newMessage(cmdTx, txMsg{tx.Serialize()}))
+}
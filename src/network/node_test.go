@@ -0,0 +1,210 @@
+package network
+
+import (
+	"blockchain/core/blockchain"
+	"blockchain/core/wallet"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 在临时目录中创建钱包与区块链，返回区块链及两个钱包地址。
+func newTestChain(t *testing.T) (*blockchain.Chain, string, string) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	ws := wallet.LoadWallets()
+	alice := ws.AddWallet()
+	bob := ws.AddWallet()
+	ws.Persist()
+
+	chain := blockchain.NewChain(filepath.Join(dir, "chain.db"), alice)
+	t.Cleanup(chain.Close)
+	return chain, alice, bob
+}
+
+// 交易池内只有锁定时间尚未到达的交易时，矿工不创建区块模板，不会持续挖出空区块。
+func TestNewBlockTemplateSkipsLockedTxs(t *testing.T) {
+	chain, alice, bob := newTestChain(t)
+
+	// 挖出几个区块，使 coinbase 输出成熟。
+	mine := func(count int) {
+		for i := 0; i < count; i++ {
+			if _, err := chain.MinePending(context.Background(), alice); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	mine(4)
+
+	n := NewNode("localhost:0", chain, alice)
+	if template, _, _, err := n.newBlockTemplate(); template != nil || err != nil {
+		t.Fatalf("empty mempool: template = %v, err = %v", template, err)
+	}
+
+	locked := chain.NewUtxoTx(alice, bob, 3, 1, 0, int64(chain.Height()+100))
+	if err := chain.SubmitTx(locked); err != nil {
+		t.Fatal(err)
+	}
+	template, _, _, err := n.newBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template != nil {
+		t.Fatalf("created a block template with %d transactions while the only pending one is locked", len(template.Transactions))
+	}
+	if chain.PendingTx(locked.ID) == nil {
+		t.Fatal("locked transaction was removed from the mempool")
+	}
+
+	// 锁定的交易用掉了全部成熟的输出，再挖几个区块得到新的输出。
+	mine(3)
+	unlocked := chain.NewUtxoTx(alice, bob, 3, 1, 0, 0)
+	if err := chain.SubmitTx(unlocked); err != nil {
+		t.Fatal(err)
+	}
+	template, _, cancel, err := n.newBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template == nil {
+		t.Fatal("no block template with a spendable pending transaction")
+	}
+	defer cancel()
+	if len(template.Transactions) != 2 {
+		t.Fatalf("template has %d transactions, want the coinbase and the unlocked one", len(template.Transactions))
+	}
+}
+
+// 读入对方发来的下一条消息。
+func expectMessage(t *testing.T, conn net.Conn, command string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m, err := readMessage(conn)
+	if err != nil {
+		t.Fatalf("waiting for %s: %v", command, err)
+	}
+	if m.Command != command {
+		t.Fatalf("received %s, want %s", m.Command, command)
+	}
+}
+
+// 同步停滞时重新请求区块头；连续停滞次数过多时断开连接。
+func TestStalledSyncIsRestarted(t *testing.T) {
+	chain, alice, _ := newTestChain(t)
+	n := NewNode("localhost:0", chain, alice)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	p := newPeer(local, true)
+	p.versioned = true
+	p.syncQueue = [][]byte{[]byte("queued block")}
+	p.syncLast = []byte("requested block")
+	p.syncDeadline = time.Now().Add(-time.Second)
+
+	done := make(chan struct{})
+	go func() {
+		n.serve(p)
+		close(done)
+	}()
+
+	// 每次停滞都重新请求区块头。对方不回复，同步状态已清空，不再计时。
+	expectMessage(t, remote, cmdGetHeaders)
+	select {
+	case <-done:
+		t.Fatal("peer was dropped after the first stall")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 对方发来区块头之前的其他消息照常处理，连接保持。
+	if err := writeMessage(remote, newMessage(cmdVerack, verackMsg{})); err != nil {
+		t.Fatal(err)
+	}
+	remote.Close()
+	<-done
+	if p.syncLast != nil || p.syncQueue != nil {
+		t.Errorf("sync state was not reset: last %q, queue %q", p.syncLast, p.syncQueue)
+	}
+	if p.syncStalls != 1 {
+		t.Errorf("stalls = %d, want 1", p.syncStalls)
+	}
+}
+
+// 连续停滞超过 maxSyncStalls 次后断开连接。
+func TestRepeatedStallsDropPeer(t *testing.T) {
+	chain, alice, _ := newTestChain(t)
+	n := NewNode("localhost:0", chain, alice)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	p := newPeer(local, true)
+	p.versioned = true
+	p.syncLast = []byte("requested block")
+	p.syncDeadline = time.Now().Add(-time.Second)
+	p.syncStalls = maxSyncStalls
+
+	done := make(chan struct{})
+	go func() {
+		n.serve(p)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer that stalled too often was not dropped")
+	}
+}
+
+// 等待数据时到期不影响随后完整地读入消息。
+func TestWaitUntilKeepsMessagesIntact(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	p := newPeer(local, true)
+	defer p.close()
+
+	stalled, err := p.waitUntil(time.Now().Add(20 * time.Millisecond))
+	if err != nil || !stalled {
+		t.Fatalf("waitUntil on a silent peer = %v, %v, want a stall", stalled, err)
+	}
+
+	go writeMessage(remote, newMessage(cmdGetHeaders, getHeadersMsg{[][]byte{[]byte("hash")}}))
+	stalled, err = p.waitUntil(time.Now().Add(5 * time.Second))
+	if err != nil || stalled {
+		t.Fatalf("waitUntil with pending data = %v, %v", stalled, err)
+	}
+	m, err := readMessage(p.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload getHeadersMsg
+	if err := m.decode(&payload); err != nil || m.Command != cmdGetHeaders || string(payload.Locator[0]) != "hash" {
+		t.Fatalf("read %s %v, %v", m.Command, payload, err)
+	}
+}
+
+// 发送队列已满时断开连接，而不是悄悄丢弃消息。
+func TestSendDropsPeerWhenQueueIsFull(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	p := newPeer(local, true)
+
+	// 对方不读取，发送协程阻塞在第一条消息上。
+	for i := 0; i < sendQueueLen+2; i++ {
+		p.send(newMessage(cmdVerack, verackMsg{}))
+	}
+	select {
+	case <-p.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer with a full send queue was not closed")
+	}
+}
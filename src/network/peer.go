@@ -0,0 +1,96 @@
+package network
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// 每个对等节点待发送消息队列的长度。
+const sendQueueLen = 256
+
+// 对等节点结构。
+type peer struct {
+	conn     net.Conn      // TCP 连接。
+	reader   *bufio.Reader // 连接的读缓冲，用于在不读走消息的情况下等待对方的数据。
+	addr     string        // 对方的监听地址，未知时为连接的远端地址。
+	inbound  bool          // 是否由对方发起连接。
+	outgoing chan *message // 待发送的消息。
+	closed   chan struct{} // 连接关闭信号。
+	once     sync.Once     // 保证只关闭一次。
+
+	// 以下字段只在该节点的读协程中修改。
+	versioned    bool      // 是否已收到对方的版本消息，修改时需持有节点的 peersMu。
+	syncQueue    [][]byte  // 区块头已通过校验、尚未请求的区块哈希值，按从旧到新排列。
+	syncLast     []byte    // 分批同步时，本批请求的最后一个区块的哈希值。
+	syncDeadline time.Time // 分批同步时，对方应在此之前发来下一个区块。
+	syncStalls   int       // 同步连续停滞的次数。
+}
+
+// 创建对等节点，并启动发送协程。
+func newPeer(conn net.Conn, inbound bool) *peer {
+	p := &peer{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		addr:     conn.RemoteAddr().String(),
+		inbound:  inbound,
+		outgoing: make(chan *message, sendQueueLen),
+		closed:   make(chan struct{}),
+	}
+	go p.writeLoop()
+	return p
+}
+
+// 将消息加入发送队列。队列已满说明对方长期不接收，断开连接而不是丢弃消息，
+// 避免对方漏掉请求或回复后同步停滞，也避免拖慢整个节点。
+func (p *peer) send(m *message) {
+	select {
+	case p.outgoing <- m:
+	case <-p.closed:
+	default:
+		log.Printf("Dropping peer %s: send queue is full", p.addr)
+		p.close()
+	}
+}
+
+// 等待对方发来数据，直到 deadline。到期时仍没有数据返回 true，连接仍可继续使用；
+// 只查看缓冲而不读走数据，因此不会截断消息。
+func (p *peer) waitUntil(deadline time.Time) (bool, error) {
+	err := p.conn.SetReadDeadline(deadline)
+	if err != nil {
+		return false, err
+	}
+	_, err = p.reader.Peek(1)
+	if resetErr := p.conn.SetReadDeadline(time.Time{}); resetErr != nil {
+		return false, resetErr
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true, nil
+	}
+	return false, err
+}
+
+// 依次发送队列中的消息。
+func (p *peer) writeLoop() {
+	for {
+		select {
+		case m := <-p.outgoing:
+			if err := writeMessage(p.conn, m); err != nil {
+				p.close()
+				return
+			}
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// 关闭连接。
+func (p *peer) close() {
+	p.once.Do(func() {
+		close(p.closed)
+		p.conn.Close()
+	})
+}
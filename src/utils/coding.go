@@ -27,13 +27,13 @@ func Base58Encode(input []byte) []byte {
 		output = append(output, alphabet[mod.Int64()])
 	}
 
+	// 每个前导的 0 字节编码为一个字母表首字符。
 	output = reverseBytes(output)
-	for b := range input {
-		if b == 0x00 {
-			output = append([]byte{alphabet[0]}, output...)
-		} else {
+	for _, b := range input {
+		if b != 0x00 {
 			break
 		}
+		output = append([]byte{alphabet[0]}, output...)
 	}
 	return output
 }
@@ -43,10 +43,12 @@ func Base58Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	// 每个前导的字母表首字符解码为一个 0 字节。
+	for _, b := range input {
+		if b != alphabet[0] {
+			break
 		}
+		zeroBytes++
 	}
 
 	payload := input[zeroBytes:]
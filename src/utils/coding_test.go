@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Base58 编码的已知结果，每个前导 0 字节对应一个字符 1。
+func TestBase58(t *testing.T) {
+	tests := []struct {
+		hex     string
+		encoded string
+	}{
+		{"", ""},
+		{"61", "2g"},
+		{"626262", "a3gV"},
+		{"516b6fcd0f", "ABnLTmg"},
+		{"00", "1"},
+		{"0000", "11"},
+		{"0001", "12"},
+		{"000000287fb4cd", "111233QC4"},
+		{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+	}
+	for _, test := range tests {
+		input, _ := hex.DecodeString(test.hex)
+		if got := string(Base58Encode(input)); got != test.encoded {
+			t.Errorf("encode %s = %q, want %q", test.hex, got, test.encoded)
+		}
+		if got := Base58Decode([]byte(test.encoded)); !bytes.Equal(got, input) {
+			t.Errorf("decode %q = %x, want %s", test.encoded, got, test.hex)
+		}
+	}
+}
+
+// 任意数量的前导 0 字节在编解码后保持不变。
+func TestBase58LeadingZeros(t *testing.T) {
+	for zeros := 0; zeros <= 4; zeros++ {
+		for _, tail := range [][]byte{nil, {0x01}, {0xff, 0x00, 0x10}} {
+			input := append(make([]byte, zeros), tail...)
+			if got := Base58Decode(Base58Encode(input)); !bytes.Equal(got, input) {
+				t.Errorf("round trip of %x = %x", input, got)
+			}
+		}
+	}
+}